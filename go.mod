@@ -1,6 +1,6 @@
 module github.com/prometheus/client_golang
 
-go 1.17
+go 1.19
 
 require (
 	github.com/beorn7/perks v1.0.1
@@ -8,11 +8,12 @@ require (
 	github.com/davecgh/go-spew v1.1.1
 	github.com/golang/protobuf v1.5.2
 	github.com/json-iterator/go v1.1.12
-	github.com/prometheus/client_model v0.3.0
+	github.com/klauspost/compress v1.15.15
+	github.com/prometheus/client_model v0.6.0
 	github.com/prometheus/common v0.39.0
 	github.com/prometheus/procfs v0.9.0
 	golang.org/x/sys v0.4.0
-	google.golang.org/protobuf v1.28.1
+	google.golang.org/protobuf v1.32.0
 )
 
 require (
@@ -14,6 +14,7 @@
 package prometheus
 
 import (
+	"fmt"
 	"sync"
 	"testing"
 )
@@ -197,3 +198,58 @@ func BenchmarkParallelCounter(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkParallelShardedCounter(b *testing.B) {
+	c := NewShardedCounter(CounterOpts{
+		Name: "benchmark_counter",
+		Help: "A Counter to benchmark it.",
+	})
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Inc()
+		}
+	})
+}
+
+func BenchmarkParallelCounterAdd(b *testing.B) {
+	for _, sharded := range []bool{false, true} {
+		b.Run(fmt.Sprintf("sharded=%t", sharded), func(b *testing.B) {
+			opts := CounterOpts{
+				Name: "benchmark_counter",
+				Help: "A Counter to benchmark it.",
+			}
+			var c Counter
+			if sharded {
+				c = NewShardedCounter(opts)
+			} else {
+				c = NewCounter(opts)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					c.Add(0.5)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkHistogramObserveMany(b *testing.B) {
+	values := make([]float64, 1000)
+	for i := range values {
+		values[i] = float64(i) / 100
+	}
+	m := NewHistogram(HistogramOpts{
+		Name:    "benchmark_histogram",
+		Help:    "A histogram to benchmark it.",
+		Buckets: DefBuckets,
+	}).(BatchObserver)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.ObserveMany(values)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// CachedCollectorOption is an option for NewCachedCollector.
+type CachedCollectorOption func(*cachedCollector)
+
+// WithSnapshotAge makes the Collector returned by NewCachedCollector also
+// collect a gauge, described by the provided GaugeOpts, with the age of the
+// served snapshot in seconds.
+func WithSnapshotAge(opts GaugeOpts) CachedCollectorOption {
+	return func(c *cachedCollector) {
+		c.ageDesc = NewDescWithUnit(
+			BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+			opts.Help,
+			opts.Unit,
+			nil,
+			opts.ConstLabels,
+		)
+	}
+}
+
+// NewCachedCollector returns a Collector wrapping the provided Collector. Upon
+// collection, it collects the metrics of the wrapped Collector and keeps a
+// snapshot of them (i.e. the state of the metrics at the time of the
+// collection). The snapshot is served for all collections within the provided
+// ttl. Concurrent collections while no valid snapshot is available result in
+// only one collection of the wrapped Collector, whose result is then served to
+// all of them.
+//
+// This is useful for Collectors that are expensive to collect (like those
+// running SQL queries or executing external commands), in particular if the
+// target is scraped by multiple Prometheus servers.
+//
+// The returned Collector implements ContextCollector. The context of the
+// collection triggering the collection of the wrapped Collector is passed on.
+// If that context is canceled during the collection, the (incomplete) result is
+// served to the concurrent collections waiting for it but not cached.
+func NewCachedCollector(c Collector, ttl time.Duration, opts ...CachedCollectorOption) Collector {
+	cc := &cachedCollector{
+		collector: c,
+		ttl:       ttl,
+		now:       time.Now,
+	}
+	for _, o := range opts {
+		o(cc)
+	}
+	return cc
+}
+
+type cachedCollector struct {
+	collector Collector
+	ttl       time.Duration
+	ageDesc   *Desc
+
+	now func() time.Time // To mock out time.Now() for testing.
+
+	mtx          sync.Mutex // Protects the fields below.
+	snapshot     []Metric
+	snapshotTime time.Time
+	inFlight     *cachedCollection
+}
+
+// cachedCollection is a collection of the wrapped Collector in progress.
+type cachedCollection struct {
+	done     chan struct{} // Closed once metrics and time are set.
+	metrics  []Metric
+	snapshot time.Time
+}
+
+// Describe implements Collector.
+func (c *cachedCollector) Describe(ch chan<- *Desc) {
+	c.collector.Describe(ch)
+	if c.ageDesc != nil {
+		ch <- c.ageDesc
+	}
+}
+
+// Collect implements Collector.
+func (c *cachedCollector) Collect(ch chan<- Metric) {
+	c.CollectWithContext(context.Background(), ch)
+}
+
+// CollectWithContext implements ContextCollector.
+func (c *cachedCollector) CollectWithContext(ctx context.Context, ch chan<- Metric) {
+	metrics, snapshotTime := c.get(ctx)
+	for _, m := range metrics {
+		ch <- m
+	}
+	if c.ageDesc != nil && !snapshotTime.IsZero() {
+		ch <- MustNewConstMetric(c.ageDesc, GaugeValue, c.now().Sub(snapshotTime).Seconds())
+	}
+}
+
+// get returns the current snapshot and the time it was taken. If the snapshot
+// has expired, it either waits for the collection in flight or starts a new
+// one.
+func (c *cachedCollector) get(ctx context.Context) ([]Metric, time.Time) {
+	c.mtx.Lock()
+	if !c.snapshotTime.IsZero() && c.now().Sub(c.snapshotTime) < c.ttl {
+		defer c.mtx.Unlock()
+		return c.snapshot, c.snapshotTime
+	}
+	if inFlight := c.inFlight; inFlight != nil {
+		c.mtx.Unlock()
+		select {
+		case <-inFlight.done:
+			return inFlight.metrics, inFlight.snapshot
+		case <-ctx.Done():
+			return nil, time.Time{}
+		}
+	}
+	inFlight := &cachedCollection{done: make(chan struct{})}
+	c.inFlight = inFlight
+	c.mtx.Unlock()
+
+	inFlight.snapshot = c.now()
+	inFlight.metrics = c.collectSnapshot(ctx)
+	close(inFlight.done)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.inFlight = nil
+	if ctx.Err() == nil {
+		c.snapshot, c.snapshotTime = inFlight.metrics, inFlight.snapshot
+	}
+	return inFlight.metrics, inFlight.snapshot
+}
+
+// collectSnapshot collects the wrapped Collector and freezes the state of the
+// collected metrics.
+func (c *cachedCollector) collectSnapshot(ctx context.Context) []Metric {
+	var (
+		metrics []Metric
+		mc      = make(chan Metric, capMetricChan)
+	)
+	go func() {
+		collectWithContext(ctx, c.collector, mc)
+		close(mc)
+	}()
+	for m := range mc {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			metrics = append(metrics, NewInvalidMetric(m.Desc(), err))
+			continue
+		}
+		metrics = append(metrics, &snapshotMetric{desc: m.Desc(), pb: pb})
+	}
+	return metrics
+}
+
+// snapshotMetric is a Metric frozen at the time of its collection.
+type snapshotMetric struct {
+	desc *Desc
+	pb   *dto.Metric
+}
+
+func (m *snapshotMetric) Desc() *Desc {
+	return m.desc
+}
+
+func (m *snapshotMetric) Write(out *dto.Metric) error {
+	out.Reset()
+	proto.Merge(out, m.pb)
+	return nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// expensiveCollector counts its collections and increments its gauge upon each
+// collection. If block is not nil, each collection waits for it to be closed.
+type expensiveCollector struct {
+	gauge       Gauge
+	collections int32
+	block       chan struct{}
+}
+
+func (c *expensiveCollector) Describe(ch chan<- *Desc) {
+	c.gauge.Describe(ch)
+}
+
+func (c *expensiveCollector) Collect(ch chan<- Metric) {
+	if c.block != nil {
+		<-c.block
+	}
+	atomic.AddInt32(&c.collections, 1)
+	c.gauge.Inc()
+	c.gauge.Collect(ch)
+}
+
+func TestCachedCollector(t *testing.T) {
+	now := time.Now()
+	inner := &expensiveCollector{gauge: NewGauge(GaugeOpts{Name: "expensive", Help: "help"})}
+	c := NewCachedCollector(inner, time.Minute, WithSnapshotAge(GaugeOpts{
+		Name: "expensive_snapshot_age_seconds",
+		Help: "help",
+		Unit: "seconds",
+	}))
+	c.(*cachedCollector).now = func() time.Time { return now }
+
+	reg := NewPedanticRegistry()
+	reg.MustRegister(c)
+
+	gather := func() (value, age float64) {
+		t.Helper()
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(mfs) != 2 {
+			t.Fatalf("got %d metric families, want 2", len(mfs))
+		}
+		return mfs[0].GetMetric()[0].GetGauge().GetValue(), mfs[1].GetMetric()[0].GetGauge().GetValue()
+	}
+
+	if value, age := gather(); value != 1 || age != 0 {
+		t.Errorf("got value %v and age %v, want 1 and 0", value, age)
+	}
+	// Changes of the inner metric must not leak into the snapshot.
+	inner.gauge.Set(100)
+	now = now.Add(30 * time.Second)
+	if value, age := gather(); value != 1 || age != 30 {
+		t.Errorf("got value %v and age %v, want 1 and 30", value, age)
+	}
+	now = now.Add(30 * time.Second)
+	if value, age := gather(); value != 101 || age != 0 {
+		t.Errorf("got value %v and age %v, want 101 and 0", value, age)
+	}
+	if got, want := atomic.LoadInt32(&inner.collections), int32(2); got != want {
+		t.Errorf("got %d collections, want %d", got, want)
+	}
+	if got, want := reg.Units()["expensive_snapshot_age_seconds"], "seconds"; got != want {
+		t.Errorf("got unit %q, want %q", got, want)
+	}
+}
+
+func TestCachedCollectorConcurrent(t *testing.T) {
+	inner := &expensiveCollector{
+		gauge: NewGauge(GaugeOpts{Name: "expensive", Help: "help"}),
+		block: make(chan struct{}),
+	}
+	c := NewCachedCollector(inner, time.Hour)
+
+	const n = 10
+	var (
+		wg     sync.WaitGroup
+		values = make(chan float64, n)
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, m := range collectMetrics(c) {
+				pb := &dto.Metric{}
+				if err := m.Write(pb); err != nil {
+					t.Error(err)
+				}
+				values <- pb.GetGauge().GetValue()
+			}
+		}()
+	}
+	// Give the goroutines a chance to pile up behind the first collection.
+	time.Sleep(10 * time.Millisecond)
+	close(inner.block)
+	wg.Wait()
+	close(values)
+
+	if got, want := atomic.LoadInt32(&inner.collections), int32(1); got != want {
+		t.Errorf("got %d collections, want %d", got, want)
+	}
+	for v := range values {
+		if v != 1 {
+			t.Errorf("got value %v, want 1", v)
+		}
+	}
+}
@@ -13,6 +13,8 @@
 
 package prometheus
 
+import "context"
+
 // Collector is the interface implemented by anything that can be used by
 // Prometheus to collect metrics. A Collector has to be registered for
 // collection. See Registerer.Register.
@@ -62,6 +64,33 @@ type Collector interface {
 	Collect(chan<- Metric)
 }
 
+// ContextCollector is a Collector that can make use of the context of the
+// collection, e.g. to abort expensive operations like database queries or RPCs
+// once the collection is canceled or its deadline is exceeded, or to read
+// request-scoped values (see promhttp.RequestFromContext for an example).
+//
+// The Registry detects Collectors implementing ContextCollector and calls their
+// CollectWithContext method instead of Collect, passing on the context provided
+// to GatherWithContext (including the deadline set with SetCollectorTimeout).
+type ContextCollector interface {
+	Collector
+	// CollectWithContext works like Collect, but gets passed the context of
+	// the collection. Once the context is done, the implementation should
+	// return as quickly as possible. Metrics sent after the context is done
+	// are most likely discarded.
+	CollectWithContext(ctx context.Context, ch chan<- Metric)
+}
+
+// collectWithContext calls the CollectWithContext method of the provided
+// Collector if it implements ContextCollector, or its Collect method otherwise.
+func collectWithContext(ctx context.Context, c Collector, ch chan<- Metric) {
+	if cc, ok := c.(ContextCollector); ok {
+		cc.CollectWithContext(ctx, ch)
+		return
+	}
+	c.Collect(ch)
+}
+
 // DescribeByCollect is a helper to implement the Describe method of a custom
 // Collector. It collects the metrics from the provided Collector and sends
 // their descriptors to the provided channel.
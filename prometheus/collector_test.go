@@ -13,7 +13,14 @@
 
 package prometheus
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
 
 type collectorDescribedByCollect struct {
 	cnt Counter
@@ -59,3 +66,98 @@ func TestDescribeByCollect(t *testing.T) {
 		t.Error("gathering failed:", err)
 	}
 }
+
+type contextKey struct{}
+
+// contextCollector collects one gauge with the value found in the context
+// under contextKey. If block is true, it blocks until the context is done.
+type contextCollector struct {
+	desc  *Desc
+	block bool
+}
+
+func (c contextCollector) Describe(ch chan<- *Desc) {
+	ch <- c.desc
+}
+
+func (c contextCollector) Collect(ch chan<- Metric) {
+	c.CollectWithContext(context.Background(), ch)
+}
+
+func (c contextCollector) CollectWithContext(ctx context.Context, ch chan<- Metric) {
+	if c.block {
+		<-ctx.Done()
+		return
+	}
+	v, _ := ctx.Value(contextKey{}).(float64)
+	ch <- MustNewConstMetric(c.desc, GaugeValue, v)
+}
+
+func TestContextCollector(t *testing.T) {
+	inner := NewRegistry()
+	inner.MustRegister(contextCollector{desc: NewDesc("value_from_context", "help", nil, nil)})
+	reg := NewRegistry()
+	reg.MustRegister(inner)
+	WrapRegistererWithPrefix("wrapped_", reg).MustRegister(
+		contextCollector{desc: NewDesc("value_from_context", "help", nil, nil)},
+	)
+
+	ctx := context.WithValue(context.Background(), contextKey{}, 42.)
+	for name, g := range map[string]ContextGatherer{
+		"registry":  reg,
+		"gatherers": Gatherers{reg},
+		"filter":    FilterGatherer(reg, MetricFilter{}).(ContextGatherer),
+	} {
+		mfs, err := g.GatherWithContext(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(mfs) != 2 {
+			t.Fatalf("%s: got %d metric families, want 2", name, len(mfs))
+		}
+		for _, mf := range mfs {
+			if got, want := mf.GetMetric()[0].GetGauge().GetValue(), 42.; got != want {
+				t.Errorf("%s: %s: got value %v, want %v", name, mf.GetName(), got, want)
+			}
+		}
+	}
+
+	mfs, _, err := ToTransactionalGatherer(reg).(ContextTransactionalGatherer).GatherWithContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mfs[0].GetMetric()[0].GetGauge().GetValue(), 42.; got != want {
+		t.Errorf("got value %v, want %v", got, want)
+	}
+}
+
+func TestContextCollectorTimeout(t *testing.T) {
+	reg := NewRegistry()
+	if err := reg.SetCollectorTimeout(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	reg.MustRegister(contextCollector{desc: NewDesc("blocking", "help", nil, nil), block: true})
+
+	done := make(chan struct{})
+	var (
+		mfs []*dto.MetricFamily
+		err error
+	)
+	go func() {
+		mfs, err = reg.Gather()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("gathering did not honor the collector timeout")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "blocking" {
+			t.Error("metric of timed out collector was returned")
+		}
+	}
+}
@@ -16,6 +16,8 @@ package prometheus
 import (
 	"errors"
 	"math"
+	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -69,12 +71,24 @@ type CounterVecOpts struct {
 	// of labels. Each label value will be constrained with the optional Contraint
 	// function, if provided.
 	VariableLabels ConstrainableLabels
+
+	// CardinalityLimit caps the number of children the vector may hold
+	// and defines what happens to new label value combinations once the
+	// cap is reached. The zero value imposes no limit.
+	CardinalityLimit CardinalityLimit
+	// TTL, if positive, is the duration after which children that have
+	// been neither accessed nor updated are deleted from the vector. See
+	// MetricVec.SetTTL for details.
+	TTL time.Duration
+	// If Sharded is true, each child of the vector is a sharded Counter as
+	// created by NewShardedCounter. See there for details.
+	Sharded bool
 }
 
 // NewCounter creates a new Counter based on the provided CounterOpts.
 //
-// The returned implementation also implements ExemplarAdder. It is safe to
-// perform the corresponding type assertion.
+// The returned implementation also implements ExemplarAdder and
+// CreatedTimestamper. It is safe to perform the corresponding type assertions.
 //
 // The returned implementation tracks the counter value in two separate
 // variables, a float64 and a uint64. The latter is used to track calls of the
@@ -84,15 +98,48 @@ type CounterVecOpts struct {
 // Both internal tracking values are added up in the Write method. This has to
 // be taken into account when it comes to precision and overflow behavior.
 func NewCounter(opts CounterOpts) Counter {
-	desc := NewDesc(
+	desc := NewDescWithUnit(
+		BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+		opts.Help,
+		opts.Unit,
+		nil,
+		opts.ConstLabels,
+	)
+	return newCounter(desc, desc.constLabelPairs, false)
+}
+
+// NewShardedCounter works like NewCounter, but the returned implementation
+// additionally spreads both tracking values over one shard per CPU (as
+// reported by runtime.GOMAXPROCS), each on its own cache line, and sums up the
+// shards in the Write method. It is meant for Counters that are incremented
+// concurrently on many CPUs in very hot execution paths, where the shared
+// tracking values of a regular Counter can become a point of contention. The
+// price is a larger memory footprint, a slower Write, and some constant
+// overhead for each increment, which makes uncontended increments slower. Use
+// the BenchmarkParallelCounter benchmarks with the -cpu flag on the target
+// hardware to find out whether sharding pays off.
+func NewShardedCounter(opts CounterOpts) Counter {
+	desc := NewDescWithUnit(
 		BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
 		opts.Help,
+		opts.Unit,
 		nil,
 		opts.ConstLabels,
 	)
-	result := &counter{desc: desc, labelPairs: desc.constLabelPairs, now: time.Now}
-	result.init(result) // Init self-collection.
-	return result
+	return newCounter(desc, desc.constLabelPairs, true)
+}
+
+// newCounter creates a Counter, which is a shardedCounter if sharded is true
+// and a counter otherwise.
+func newCounter(desc *Desc, labelPairs []*dto.LabelPair, sharded bool) Counter {
+	c := &counter{desc: desc, labelPairs: labelPairs, createdTs: time.Now(), now: time.Now}
+	if sharded {
+		result := &shardedCounter{counter: c, shards: make([]counterShard, runtime.GOMAXPROCS(0))}
+		result.init(result) // Init self-collection.
+		return result
+	}
+	c.init(c) // Init self-collection.
+	return c
 }
 
 type counter struct {
@@ -108,6 +155,7 @@ type counter struct {
 
 	labelPairs []*dto.LabelPair
 	exemplar   atomic.Value // Containing nil or a *dto.Exemplar.
+	createdTs  time.Time
 
 	now func() time.Time // To mock out time.Now() for testing.
 }
@@ -116,6 +164,11 @@ func (c *counter) Desc() *Desc {
 	return c.desc
 }
 
+// CreatedTimestamp implements CreatedTimestamper.
+func (c *counter) CreatedTimestamp() time.Time {
+	return c.createdTs
+}
+
 func (c *counter) Add(v float64) {
 	if v < 0 {
 		panic(errors.New("counter cannot decrease in value"))
@@ -160,7 +213,7 @@ func (c *counter) Write(out *dto.Metric) error {
 	}
 	val := c.get()
 
-	return populateMetric(CounterValue, val, c.labelPairs, exemplar, out)
+	return populateMetric(CounterValue, val, c.labelPairs, exemplar, c.createdTs, out)
 }
 
 func (c *counter) updateExemplar(v float64, l Labels) {
@@ -174,6 +227,97 @@ func (c *counter) updateExemplar(v float64, l Labels) {
 	c.exemplar.Store(e)
 }
 
+// shardedCounter is a Counter that spreads its value over several shards. It
+// is created by NewShardedCounter and by V2.NewCounterVec if Sharded is set in
+// the CounterVecOpts. The embedded counter only provides the
+// Desc, the label pairs, the exemplar, and the created timestamp. Its value
+// remains unused.
+type shardedCounter struct {
+	*counter
+
+	shards []counterShard
+}
+
+// counterShard is one shard of a shardedCounter. It works like the value of a
+// counter (see there) and is padded to fill a whole cache line.
+type counterShard struct {
+	valBits uint64
+	valInt  uint64
+	_       [48]byte
+}
+
+// counterShardIndexes is a pool of shard indexes. As a sync.Pool keeps a cache
+// per P, this hands out the same index to all goroutines running on the same P
+// (most of the time), without any contention between Ps. New indexes are
+// allocated round-robin.
+var (
+	counterShardIndexes = sync.Pool{New: func() interface{} {
+		idx := atomic.AddUint32(&nextCounterShardIndex, 1)
+		return &idx
+	}}
+	nextCounterShardIndex uint32
+)
+
+func (c *shardedCounter) shard() *counterShard {
+	idx := counterShardIndexes.Get().(*uint32)
+	shard := &c.shards[*idx%uint32(len(c.shards))]
+	counterShardIndexes.Put(idx)
+	return shard
+}
+
+func (c *shardedCounter) Add(v float64) {
+	if v < 0 {
+		panic(errors.New("counter cannot decrease in value"))
+	}
+	shard := c.shard()
+
+	ival := uint64(v)
+	if float64(ival) == v {
+		atomic.AddUint64(&shard.valInt, ival)
+		return
+	}
+
+	for {
+		oldBits := atomic.LoadUint64(&shard.valBits)
+		newBits := math.Float64bits(math.Float64frombits(oldBits) + v)
+		if atomic.CompareAndSwapUint64(&shard.valBits, oldBits, newBits) {
+			return
+		}
+	}
+}
+
+func (c *shardedCounter) AddWithExemplar(v float64, e Labels) {
+	c.Add(v)
+	c.updateExemplar(v, e)
+}
+
+func (c *shardedCounter) Inc() {
+	atomic.AddUint64(&c.shard().valInt, 1)
+}
+
+func (c *shardedCounter) get() float64 {
+	var (
+		fval float64
+		ival uint64
+	)
+	for i := range c.shards {
+		fval += math.Float64frombits(atomic.LoadUint64(&c.shards[i].valBits))
+		ival += atomic.LoadUint64(&c.shards[i].valInt)
+	}
+	return fval + float64(ival)
+}
+
+func (c *shardedCounter) Write(out *dto.Metric) error {
+	// Read the Exemplar first and the value second, see counter.Write.
+	var exemplar *dto.Exemplar
+	if e := c.exemplar.Load(); e != nil {
+		exemplar = e.(*dto.Exemplar)
+	}
+	val := c.get()
+
+	return populateMetric(CounterValue, val, c.labelPairs, exemplar, c.createdTs, out)
+}
+
 // CounterVec is a Collector that bundles a set of Counters that all share the
 // same Desc, but have different values for their variable labels. This is used
 // if you want to count the same thing partitioned by various dimensions
@@ -194,22 +338,22 @@ func NewCounterVec(opts CounterOpts, labelNames []string) *CounterVec {
 
 // NewCounterVec creates a new CounterVec based on the provided CounterVecOpts.
 func (v2) NewCounterVec(opts CounterVecOpts) *CounterVec {
-	desc := V2.NewDesc(
+	desc := V2.NewDescWithUnit(
 		BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
 		opts.Help,
+		opts.Unit,
 		opts.VariableLabels,
 		opts.ConstLabels,
 	)
-	return &CounterVec{
-		MetricVec: NewMetricVec(desc, func(lvs ...string) Metric {
-			if len(lvs) != len(desc.variableLabels) {
-				panic(makeInconsistentCardinalityError(desc.fqName, desc.variableLabels.labelNames(), lvs))
-			}
-			result := &counter{desc: desc, labelPairs: MakeLabelPairs(desc, lvs), now: time.Now}
-			result.init(result) // Init self-collection.
-			return result
-		}),
-	}
+	vec := NewMetricVec(desc, func(lvs ...string) Metric {
+		if len(lvs) != len(desc.variableLabels) {
+			panic(makeInconsistentCardinalityError(desc.fqName, desc.variableLabels.labelNames(), lvs))
+		}
+		return newCounter(desc, MakeLabelPairs(desc, lvs), opts.Sharded)
+	})
+	vec.SetCardinalityLimit(opts.CardinalityLimit)
+	vec.SetTTL(opts.TTL)
+	return &CounterVec{vec}
 }
 
 // GetMetricWithLabelValues returns the Counter for the given slice of label
@@ -339,9 +483,10 @@ type CounterFunc interface {
 //
 // Check out the ExampleGaugeFunc examples for the similar GaugeFunc.
 func NewCounterFunc(opts CounterOpts, function func() float64) CounterFunc {
-	return newValueFunc(NewDesc(
+	return newValueFunc(NewDescWithUnit(
 		BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
 		opts.Help,
+		opts.Unit,
 		nil,
 		opts.ConstLabels,
 	), CounterValue, function)
@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"math"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -61,8 +62,80 @@ func TestCounterAdd(t *testing.T) {
 	m := &dto.Metric{}
 	counter.Write(m)
 
-	if expected, got := `label:<name:"a" value:"1" > label:<name:"b" value:"2" > counter:<value:67.42 > `, m.String(); expected != got {
-		t.Errorf("expected %q, got %q", expected, got)
+	expected := &dto.Metric{
+		Label: []*dto.LabelPair{
+			{Name: proto.String("a"), Value: proto.String("1")},
+			{Name: proto.String("b"), Value: proto.String("2")},
+		},
+		Counter: &dto.Counter{
+			Value:            proto.Float64(67.42),
+			CreatedTimestamp: timestamppb.New(counter.createdTs),
+		},
+	}
+	if !proto.Equal(expected, m) {
+		t.Errorf("expected %q, got %q", expected, m)
+	}
+}
+
+func isSharded(c Counter) bool {
+	_, ok := c.(*shardedCounter)
+	return ok
+}
+
+func TestShardedCounter(t *testing.T) {
+	if c := NewShardedCounter(CounterOpts{Name: "test", Help: "test help"}); !isSharded(c) {
+		t.Fatalf("expected a *shardedCounter, got %T", c)
+	}
+
+	c := V2.NewCounterVec(CounterVecOpts{
+		CounterOpts:    CounterOpts{Name: "test", Help: "test help"},
+		VariableLabels: UnconstrainedLabels{"a"},
+		Sharded:        true,
+	}).WithLabelValues("1")
+	if !isSharded(c) {
+		t.Fatalf("expected a *shardedCounter, got %T", c)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Inc()
+				c.Add(2)
+				c.Add(0.5)
+			}
+		}()
+	}
+	wg.Wait()
+	c.(ExemplarAdder).AddWithExemplar(1, Labels{"foo": "bar"})
+
+	m := &dto.Metric{}
+	if err := c.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 35001.0, m.GetCounter().GetValue(); expected != got {
+		t.Errorf("Expected %f, got %f.", expected, got)
+	}
+	if m.GetCounter().GetExemplar() == nil {
+		t.Error("Expected an exemplar.")
+	}
+	if _, ok := c.(CreatedTimestamper); !ok {
+		t.Error("Expected a CreatedTimestamper.")
+	}
+
+	decrease := func() (err error) {
+		defer func() {
+			if e := recover(); e != nil {
+				err = e.(error)
+			}
+		}()
+		c.Add(-1)
+		return nil
+	}
+	if expected, got := "counter cannot decrease in value", decrease(); got == nil || expected != got.Error() {
+		t.Errorf("Expected error %q, got %v.", expected, got)
 	}
 }
 
@@ -164,8 +237,14 @@ func TestCounterAddInf(t *testing.T) {
 	m := &dto.Metric{}
 	counter.Write(m)
 
-	if expected, got := `counter:<value:inf > `, m.String(); expected != got {
-		t.Errorf("expected %q, got %q", expected, got)
+	expected := &dto.Metric{
+		Counter: &dto.Counter{
+			Value:            proto.Float64(math.Inf(1)),
+			CreatedTimestamp: timestamppb.New(counter.createdTs),
+		},
+	}
+	if !proto.Equal(expected, m) {
+		t.Errorf("expected %q, got %q", expected, m)
 	}
 }
 
@@ -188,8 +267,14 @@ func TestCounterAddLarge(t *testing.T) {
 	m := &dto.Metric{}
 	counter.Write(m)
 
-	if expected, got := fmt.Sprintf("counter:<value:%0.16e > ", large), m.String(); expected != got {
-		t.Errorf("expected %q, got %q", expected, got)
+	expected := &dto.Metric{
+		Counter: &dto.Counter{
+			Value:            proto.Float64(large),
+			CreatedTimestamp: timestamppb.New(counter.createdTs),
+		},
+	}
+	if !proto.Equal(expected, m) {
+		t.Errorf("expected %q, got %q", expected, m)
 	}
 }
 
@@ -210,8 +295,14 @@ func TestCounterAddSmall(t *testing.T) {
 	m := &dto.Metric{}
 	counter.Write(m)
 
-	if expected, got := fmt.Sprintf("counter:<value:%0.0e > ", small), m.String(); expected != got {
-		t.Errorf("expected %q, got %q", expected, got)
+	expected := &dto.Metric{
+		Counter: &dto.Counter{
+			Value:            proto.Float64(small),
+			CreatedTimestamp: timestamppb.New(counter.createdTs),
+		},
+	}
+	if !proto.Equal(expected, m) {
+		t.Errorf("expected %q, got %q", expected, m)
 	}
 }
 
@@ -34,9 +34,10 @@ import (
 //
 // Descriptors registered with the same registry have to fulfill certain
 // consistency and uniqueness criteria if they share the same fully-qualified
-// name: They must have the same help string and the same label names (aka label
-// dimensions) in each, constLabels and variableLabels, but they must differ in
-// the values of the constLabels.
+// name: They must have the same help string, the same unit, the same label
+// names (aka label dimensions) in each, constLabels and variableLabels, and
+// must all belong to Infos, to StateSets, or to neither of them, but they must
+// differ in the values of the constLabels.
 //
 // Descriptors that share the same fully-qualified names and the same label
 // values of their constLabels are considered equal.
@@ -47,6 +48,13 @@ type Desc struct {
 	fqName string
 	// help provides some helpful information about this metric.
 	help string
+	// unit is the unit of this metric. It is empty if no unit is set.
+	unit string
+	// openMetricsType is the OpenMetrics type of this metric if it cannot
+	// be told from the metrics written by its Write method, i.e. "info" or
+	// "stateset" (written as gauges) or "gaugehistogram" (written as
+	// histograms). It is empty otherwise.
+	openMetricsType string
 	// constLabelPairs contains precalculated DTO label pairs based on
 	// the constant labels.
 	constLabelPairs []*dto.LabelPair
@@ -57,9 +65,9 @@ type Desc struct {
 	// must be unique among all registered descriptors and can therefore be
 	// used as an identifier of the descriptor.
 	id uint64
-	// dimHash is a hash of the label names (preset and variable) and the
-	// Help string. Each Desc with the same fqName must have the same
-	// dimHash.
+	// dimHash is a hash of the label names (preset and variable), the
+	// Help string, and the unit. Each Desc with the same fqName must have
+	// the same dimHash.
 	dimHash uint64
 	// err is an error that occurred during construction. It is reported on
 	// registration time.
@@ -90,15 +98,42 @@ func NewDesc(fqName, help string, variableLabels []string, constLabels Labels) *
 // For constLabels, the label values are constant. Therefore, they are fully
 // specified in the Desc. See the Collector example for a usage pattern.
 func (v2) NewDesc(fqName, help string, variableLabels ConstrainableLabels, constLabels Labels) *Desc {
+	return newDesc(fqName, help, "", variableLabels, constLabels)
+}
+
+// NewDescWithUnit works like NewDesc but additionally sets the unit of the
+// metric, e.g. "seconds" or "bytes". As required by OpenMetrics, fqName must
+// end with the unit (in case of a counter optionally followed by "_total").
+// Otherwise, the error is recorded in the Desc and reported on registration
+// time. An empty unit is equivalent to no unit.
+func NewDescWithUnit(fqName, help, unit string, variableLabels []string, constLabels Labels) *Desc {
+	return V2.NewDescWithUnit(fqName, help, unit, UnconstrainedLabels(variableLabels), constLabels)
+}
+
+// NewDescWithUnit works like NewDesc but additionally sets the unit of the
+// metric, e.g. "seconds" or "bytes". As required by OpenMetrics, fqName must
+// end with the unit (in case of a counter optionally followed by "_total").
+// Otherwise, the error is recorded in the Desc and reported on registration
+// time. An empty unit is equivalent to no unit.
+func (v2) NewDescWithUnit(fqName, help, unit string, variableLabels ConstrainableLabels, constLabels Labels) *Desc {
+	return newDesc(fqName, help, unit, variableLabels, constLabels)
+}
+
+func newDesc(fqName, help, unit string, variableLabels ConstrainableLabels, constLabels Labels) *Desc {
 	d := &Desc{
 		fqName:         fqName,
 		help:           help,
+		unit:           unit,
 		variableLabels: variableLabels.constrainedLabels(),
 	}
 	if !model.IsValidMetricName(model.LabelValue(fqName)) {
 		d.err = fmt.Errorf("%q is not a valid metric name", fqName)
 		return d
 	}
+	if err := validateUnit(fqName, unit); err != nil {
+		d.err = err
+		return d
+	}
 	// labelValues contains the label values of const labels (in order of
 	// their sorted label names) plus the fqName (at position 0).
 	labelValues := make([]string, 1, len(constLabels)+1)
@@ -149,11 +184,15 @@ func (v2) NewDesc(fqName, help string, variableLabels ConstrainableLabels, const
 	d.id = xxh.Sum64()
 	// Sort labelNames so that order doesn't matter for the hash.
 	sort.Strings(labelNames)
-	// Now hash together (in this order) the help string and the sorted
-	// label names.
+	// Now hash together (in this order) the help string, the unit, and
+	// the sorted label names.
 	xxh.Reset()
 	xxh.WriteString(help)
 	xxh.Write(separatorByteSlice)
+	if unit != "" {
+		xxh.WriteString(unit)
+		xxh.Write(separatorByteSlice)
+	}
 	for _, labelName := range labelNames {
 		xxh.WriteString(labelName)
 		xxh.Write(separatorByteSlice)
@@ -181,6 +220,21 @@ func NewInvalidDesc(err error) *Desc {
 	}
 }
 
+// validateUnit checks that unit is a valid unit for a metric named fqName. An
+// empty unit is always valid.
+func validateUnit(fqName, unit string) error {
+	if unit == "" {
+		return nil
+	}
+	if !model.IsValidMetricName(model.LabelValue(unit)) {
+		return fmt.Errorf("%q is not a valid unit for metric %q", unit, fqName)
+	}
+	if !strings.HasSuffix(strings.TrimSuffix(fqName, "_total"), "_"+unit) {
+		return fmt.Errorf("metric name %q does not end with its unit %q", fqName, unit)
+	}
+	return nil
+}
+
 func (d *Desc) String() string {
 	lpStrings := make([]string, 0, len(d.constLabelPairs))
 	for _, lp := range d.constLabelPairs {
@@ -189,6 +243,16 @@ func (d *Desc) String() string {
 			fmt.Sprintf("%s=%q", lp.GetName(), lp.GetValue()),
 		)
 	}
+	if d.unit != "" {
+		return fmt.Sprintf(
+			"Desc{fqName: %q, help: %q, unit: %q, constLabels: {%s}, variableLabels: %v}",
+			d.fqName,
+			d.help,
+			d.unit,
+			strings.Join(lpStrings, ","),
+			d.variableLabels,
+		)
+	}
 	return fmt.Sprintf(
 		"Desc{fqName: %q, help: %q, constLabels: {%s}, variableLabels: %v}",
 		d.fqName,
@@ -28,3 +28,28 @@ func TestNewDescInvalidLabelValues(t *testing.T) {
 		t.Errorf("NewDesc: expected error because: %s", desc.err)
 	}
 }
+
+func TestNewDescWithUnit(t *testing.T) {
+	scenarios := []struct {
+		fqName, unit string
+		wantErr      bool
+	}{
+		{fqName: "request_duration_seconds", unit: "seconds"},
+		{fqName: "sent_bytes_total", unit: "bytes"},
+		{fqName: "request_duration", unit: ""},
+		{fqName: "request_duration", unit: "seconds", wantErr: true},
+		{fqName: "request_duration_seconds_count", unit: "seconds", wantErr: true},
+		{fqName: "free_byte space", unit: "byte space", wantErr: true},
+	}
+	for _, s := range scenarios {
+		desc := NewDescWithUnit(s.fqName, "help", s.unit, nil, nil)
+		if gotErr := desc.err != nil; gotErr != s.wantErr {
+			t.Errorf("%s with unit %q: got error %v, want error: %t", s.fqName, s.unit, desc.err, s.wantErr)
+		}
+	}
+
+	// The unit is part of the dimensions of a Desc.
+	if NewDescWithUnit("a_seconds", "help", "seconds", nil, nil).dimHash == NewDesc("a_seconds", "help", nil, nil).dimHash {
+		t.Error("descriptors with and without unit have the same dimHash")
+	}
+}
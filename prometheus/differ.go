@@ -0,0 +1,231 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus/internal"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/proto"
+)
+
+// ChangeType is the type of a Change detected by a Differ.
+type ChangeType int
+
+// Possible values for ChangeType.
+const (
+	// SeriesAdded marks a series not present in the previous snapshot.
+	SeriesAdded ChangeType = iota
+	// SeriesRemoved marks a series not present in the current snapshot
+	// anymore.
+	SeriesRemoved
+	// CounterIncreased marks a counter (or the count of a histogram or
+	// summary) that has increased since the previous snapshot.
+	CounterIncreased
+	// CounterReset marks a counter (or the count of a histogram or
+	// summary) that has decreased since the previous snapshot, which
+	// implies a reset.
+	CounterReset
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case SeriesAdded:
+		return "added"
+	case SeriesRemoved:
+		return "removed"
+	case CounterIncreased:
+		return "increased"
+	case CounterReset:
+		return "reset"
+	}
+	return fmt.Sprintf("ChangeType(%d)", int(t))
+}
+
+// Change describes the change of one series between two snapshots taken by a
+// Differ.
+type Change struct {
+	Type ChangeType
+	// Name and MetricType are the name and the type of the metric family
+	// of the series.
+	Name       string
+	MetricType dto.MetricType
+	// Labels are the labels of the series.
+	Labels Labels
+	// Previous is the series in the previous snapshot. It is nil for
+	// SeriesAdded.
+	Previous *dto.Metric
+	// Current is the series in the current snapshot. It is nil for
+	// SeriesRemoved.
+	Current *dto.Metric
+	// Delta is the increase of the counter (or the count of a histogram or
+	// summary) since the previous snapshot. After a reset, it is the value
+	// after the reset. It is zero for SeriesAdded and SeriesRemoved.
+	Delta float64
+}
+
+// Differ gathers snapshots from a Gatherer and reports the changes between
+// consecutive snapshots. It is useful for debugging and to build exporters of
+// deltas. Create instances with NewDiffer.
+//
+// Note that gauges, gauge histograms, and untyped metrics only result in
+// SeriesAdded and SeriesRemoved changes, while counters, histograms, and
+// summaries also result in CounterIncreased and CounterReset changes.
+type Differ struct {
+	g Gatherer
+
+	mtx      sync.Mutex // Protects previous.
+	previous map[string]diffSeries
+}
+
+// diffSeries is a series kept in the snapshot of a Differ.
+type diffSeries struct {
+	name       string
+	metricType dto.MetricType
+	metric     *dto.Metric
+}
+
+// NewDiffer returns a Differ that gathers its snapshots from the provided
+// Gatherer.
+func NewDiffer(g Gatherer) *Differ {
+	return &Differ{g: g}
+}
+
+// Diff gathers a new snapshot and returns the changes compared to the previous
+// snapshot. The first call of Diff reports all series as SeriesAdded. The
+// returned changes are sorted by metric family name, and within the same
+// metric family, changes of series still present come first, followed by
+// removed series, each in the usual order of the exposition formats.
+//
+// If gathering fails, the error is returned together with no changes, and the
+// previous snapshot is kept (to not report series as removed just because they
+// could not be gathered).
+func (d *Differ) Diff() ([]Change, error) {
+	mfs, err := d.g.Gather()
+	if err != nil {
+		return nil, err
+	}
+	mfsByName := make(map[string]*dto.MetricFamily, len(mfs))
+	for _, mf := range mfs {
+		mfsByName[mf.GetName()] = mf
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	var (
+		changes []Change
+		current = make(map[string]diffSeries, len(d.previous))
+	)
+	for _, mf := range internal.NormalizeMetricFamilies(mfsByName) {
+		for _, m := range mf.Metric {
+			s := diffSeries{name: mf.GetName(), metricType: mf.GetType(), metric: m}
+			key := seriesKey(s.name, m)
+			current[key] = s
+			prev, ok := d.previous[key]
+			if !ok {
+				changes = append(changes, s.change(SeriesAdded, nil, m, 0))
+				continue
+			}
+			delete(d.previous, key)
+			prevValue, ok1 := cumulativeValue(prev.metricType, prev.metric)
+			currValue, ok2 := cumulativeValue(s.metricType, m)
+			switch {
+			case !ok1 || !ok2 || currValue == prevValue:
+			case currValue > prevValue:
+				changes = append(changes, s.change(CounterIncreased, prev.metric, m, currValue-prevValue))
+			default:
+				changes = append(changes, s.change(CounterReset, prev.metric, m, currValue))
+			}
+		}
+	}
+
+	// All series left in d.previous have been removed.
+	removedByName := map[string]*dto.MetricFamily{}
+	for _, s := range d.previous {
+		mf, ok := removedByName[s.name]
+		if !ok {
+			mf = &dto.MetricFamily{Name: proto.String(s.name)}
+			removedByName[s.name] = mf
+		}
+		mf.Metric = append(mf.Metric, s.metric)
+	}
+	for _, mf := range internal.NormalizeMetricFamilies(removedByName) {
+		for _, m := range mf.Metric {
+			s := d.previous[seriesKey(mf.GetName(), m)]
+			changes = append(changes, s.change(SeriesRemoved, m, nil, 0))
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+
+	d.previous = current
+	return changes, nil
+}
+
+func (s diffSeries) change(t ChangeType, previous, current *dto.Metric, delta float64) Change {
+	labels := make(Labels, len(s.metric.GetLabel()))
+	for _, lp := range s.metric.GetLabel() {
+		labels[lp.GetName()] = lp.GetValue()
+	}
+	return Change{
+		Type:       t,
+		Name:       s.name,
+		MetricType: s.metricType,
+		Labels:     labels,
+		Previous:   previous,
+		Current:    current,
+		Delta:      delta,
+	}
+}
+
+// seriesKey returns a string identifying the series of the provided metric
+// family name and metric, independent of the order of the labels.
+func seriesKey(name string, m *dto.Metric) string {
+	lps := make([]*dto.LabelPair, len(m.GetLabel()))
+	copy(lps, m.GetLabel())
+	sort.Sort(internal.LabelPairSorter(lps))
+
+	var b strings.Builder
+	b.WriteString(name)
+	for _, lp := range lps {
+		b.WriteByte(model.SeparatorByte)
+		b.WriteString(lp.GetName())
+		b.WriteByte(model.SeparatorByte)
+		b.WriteString(lp.GetValue())
+	}
+	return b.String()
+}
+
+// cumulativeValue returns the value of a counter or the sample count of a
+// histogram or summary. The second return value is false for all other
+// metrics (including gauge histograms).
+func cumulativeValue(t dto.MetricType, m *dto.Metric) (float64, bool) {
+	switch {
+	case m.Counter != nil:
+		return m.Counter.GetValue(), true
+	case m.Histogram != nil && t != dto.MetricType_GAUGE_HISTOGRAM:
+		return float64(m.Histogram.GetSampleCount()), true
+	case m.Summary != nil:
+		return float64(m.Summary.GetSampleCount()), true
+	}
+	return 0, false
+}
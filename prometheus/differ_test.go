@@ -0,0 +1,144 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// formatChanges returns a compact, comparable representation of changes.
+func formatChanges(changes []Change) string {
+	var lines []string
+	for _, c := range changes {
+		lines = append(lines, fmt.Sprintf("%s %s %v %g", c.Type, c.Name, c.Labels, c.Delta))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestDiffer(t *testing.T) {
+	reg := NewRegistry()
+	counter := NewCounterVec(CounterOpts{Name: "requests_total", Help: "help"}, []string{"code"})
+	gauge := NewGauge(GaugeOpts{Name: "temperature", Help: "help"})
+	histogram := NewHistogram(HistogramOpts{Name: "latency", Help: "help"})
+	reg.MustRegister(counter, gauge, histogram)
+
+	counter.WithLabelValues("200").Add(3)
+	counter.WithLabelValues("500").Inc()
+
+	d := NewDiffer(reg)
+	diff := func(want string) {
+		t.Helper()
+		changes, err := d.Diff()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formatChanges(changes); got != want {
+			t.Errorf("got changes\n%s\nwant\n%s", got, want)
+		}
+	}
+
+	diff(`added latency map[] 0
+added requests_total map[code:200] 0
+added requests_total map[code:500] 0
+added temperature map[] 0`)
+
+	// No changes at all.
+	diff("")
+
+	counter.WithLabelValues("200").Add(2)
+	counter.WithLabelValues("404").Inc()
+	gauge.Set(42)
+	histogram.Observe(1)
+	histogram.Observe(2)
+	diff(`increased latency map[] 2
+increased requests_total map[code:200] 2
+added requests_total map[code:404] 0`)
+
+	counter.DeleteLabelValues("500")
+	counter.DeleteLabelValues("200")
+	counter.WithLabelValues("200").Inc()
+	diff(`reset requests_total map[code:200] 1
+removed requests_total map[code:500] 0`)
+}
+
+func TestDifferRemovedFamilies(t *testing.T) {
+	reg := NewRegistry()
+	counter := NewCounter(CounterOpts{Name: "requests_total", Help: "help"})
+	gauge := NewGauge(GaugeOpts{Name: "temperature", Help: "help"})
+	info := NewGaugeVec(GaugeOpts{Name: "build_info", Help: "help"}, []string{"version"})
+	info.WithLabelValues("1.0").Set(1)
+	reg.MustRegister(counter, gauge, info)
+
+	d := NewDiffer(reg)
+	if _, err := d.Diff(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each removed series must be reported with the name of its own family.
+	reg.Unregister(counter)
+	reg.Unregister(gauge)
+	reg.Unregister(info)
+	changes, err := d.Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `removed build_info map[version:1.0] 0
+removed requests_total map[] 0
+removed temperature map[] 0`
+	if got := formatChanges(changes); got != want {
+		t.Errorf("got changes\n%s\nwant\n%s", got, want)
+	}
+}
+
+type failingGatherer struct {
+	mfs []*dto.MetricFamily
+	err error
+}
+
+func (g *failingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	return g.mfs, g.err
+}
+
+func TestDifferKeepsSnapshotOnError(t *testing.T) {
+	name := "up"
+	g := &failingGatherer{mfs: []*dto.MetricFamily{{
+		Name:   &name,
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{}}},
+	}}}
+	d := NewDiffer(g)
+	if changes, err := d.Diff(); err != nil || len(changes) != 1 {
+		t.Fatalf("got %d changes and error %v, want 1 change and no error", len(changes), err)
+	}
+
+	g.mfs, g.err = nil, errors.New("gathering failed")
+	if changes, err := d.Diff(); err == nil || len(changes) != 0 {
+		t.Fatalf("got %d changes and error %v, want no changes and an error", len(changes), err)
+	}
+
+	// The series must not be reported as removed and re-added.
+	g.mfs, g.err = []*dto.MetricFamily{{
+		Name:   &name,
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{}}},
+	}}, nil
+	if changes, err := d.Diff(); err != nil || len(changes) != 0 {
+		t.Fatalf("got changes %q and error %v, want neither", formatChanges(changes), err)
+	}
+}
@@ -294,9 +294,9 @@ func ExampleRegister() {
 
 	// Output:
 	// taskCounter registered.
-	// taskCounterVec not registered: a previously registered descriptor with the same fully-qualified name as Desc{fqName: "worker_pool_completed_tasks_total", help: "Total number of tasks completed.", constLabels: {}, variableLabels: [{worker_id <nil>}]} has different label names or a different help string
+	// taskCounterVec not registered: a previously registered descriptor with the same fully-qualified name as Desc{fqName: "worker_pool_completed_tasks_total", help: "Total number of tasks completed.", constLabels: {}, variableLabels: [{worker_id <nil>}]} has different label names, a different help string, or a different unit
 	// taskCounter unregistered.
-	// taskCounterVec not registered: a previously registered descriptor with the same fully-qualified name as Desc{fqName: "worker_pool_completed_tasks_total", help: "Total number of tasks completed.", constLabels: {}, variableLabels: [{worker_id <nil>}]} has different label names or a different help string
+	// taskCounterVec not registered: a previously registered descriptor with the same fully-qualified name as Desc{fqName: "worker_pool_completed_tasks_total", help: "Total number of tasks completed.", constLabels: {}, variableLabels: [{worker_id <nil>}]} has different label names, a different help string, or a different unit
 	// taskCounterVec registered.
 	// Worker initialization failed: inconsistent label cardinality: expected 1 label values but got 2 in []string{"42", "spurious arg"}
 	// notMyCounter is nil.
@@ -321,6 +321,8 @@ func ExampleSummary() {
 	// internally).
 	metric := &dto.Metric{}
 	temps.Write(metric)
+	// The created timestamp differs between runs, so it is removed here.
+	metric.Summary.CreatedTimestamp = nil
 	fmt.Println(proto.MarshalTextString(metric))
 
 	// Output:
@@ -371,6 +373,10 @@ func ExampleSummaryVec() {
 	if err != nil || len(metricFamilies) != 1 {
 		panic("unexpected behavior of custom test registry")
 	}
+	// The created timestamps differ between runs, so they are removed here.
+	for _, m := range metricFamilies[0].Metric {
+		m.Summary.CreatedTimestamp = nil
+	}
 	fmt.Println(proto.MarshalTextString(metricFamilies[0]))
 
 	// Output:
@@ -512,6 +518,8 @@ func ExampleHistogram() {
 	// internally).
 	metric := &dto.Metric{}
 	temps.Write(metric)
+	// The created timestamp differs between runs, so it is removed here.
+	metric.Histogram.CreatedTimestamp = nil
 	fmt.Println(proto.MarshalTextString(metric))
 
 	// Output:
@@ -800,7 +808,7 @@ temperature_kelvin 4.5
 
 	gathering, err = gatherers.Gather()
 	if err != nil {
-		fmt.Println(err)
+		fmt.Println(normalizeSpaces(err.Error()))
 	}
 	// Note that still as many metrics as possible are returned:
 	out.Reset()
@@ -822,7 +830,7 @@ temperature_kelvin 4.5
 	// temperature_kelvin{location="outside"} 273.14
 	// temperature_kelvin{location="somewhere else"} 4.5
 	// ----------
-	// collected metric "temperature_kelvin" { label:<name:"location" value:"outside" > gauge:<value:265.3 > } was collected before with the same name and label values
+	// collected metric "temperature_kelvin" { label:{name:"location" value:"outside"} gauge:{value:265.3}} was collected before with the same name and label values
 	// # HELP humidity_percent Humidity in %.
 	// # TYPE humidity_percent gauge
 	// humidity_percent{location="inside"} 33.2
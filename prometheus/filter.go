@@ -0,0 +1,284 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// MetricNameLabel is the pseudo label name a LabelMatcher can use to match
+// the name of a metric family.
+const MetricNameLabel = "__name__"
+
+// MatchType is the type of a LabelMatcher.
+type MatchType int
+
+// Possible MatchTypes, with the same semantics as the label matchers in
+// Prometheus series selectors.
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+func (t MatchType) String() string {
+	switch t {
+	case MatchEqual:
+		return "="
+	case MatchNotEqual:
+		return "!="
+	case MatchRegexp:
+		return "=~"
+	case MatchNotRegexp:
+		return "!~"
+	}
+	return fmt.Sprintf("MatchType(%d)", int(t))
+}
+
+// LabelMatcher matches the value of one label of a metric. A metric without
+// the label is treated as having the label set to the empty string. The label
+// named MetricNameLabel matches the name of the metric family.
+//
+// Use NewLabelMatcher to create LabelMatcher instances.
+type LabelMatcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+
+	re *regexp.Regexp
+}
+
+// NewLabelMatcher returns a LabelMatcher of the provided type. For the regular
+// expression types, the value is compiled as a regular expression, which is
+// anchored at both ends (as usual for Prometheus series selectors). An error
+// is returned if the type is unknown or the regular expression is invalid.
+func NewLabelMatcher(t MatchType, name, value string) (*LabelMatcher, error) {
+	m := &LabelMatcher{Type: t, Name: name, Value: value}
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression in matcher for label %q: %w", name, err)
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("unknown match type %v for label %q", t, name)
+	}
+	return m, nil
+}
+
+// Matches returns whether the matcher matches the provided label value.
+func (m *LabelMatcher) Matches(v string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	case MatchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+	return false
+}
+
+func (m *LabelMatcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Name, m.Type, m.Value)
+}
+
+// MetricFilter selects a subset of gathered metrics. A metric is selected if
+// the name of its metric family is one of Names, if the name of its metric
+// family matches any of NameRegexps, or if it matches all LabelMatchers of any
+// of the Selectors. A MetricFilter without any Names, NameRegexps, and
+// Selectors selects all metrics.
+//
+// Note that names are always matched against the name of the metric family,
+// i.e. without any "_bucket", "_sum", or "_count" suffixes added in the
+// exposition of histograms and summaries.
+type MetricFilter struct {
+	Names       []string
+	NameRegexps []*regexp.Regexp
+	Selectors   [][]*LabelMatcher
+}
+
+func (f MetricFilter) isEmpty() bool {
+	return len(f.Names) == 0 && len(f.NameRegexps) == 0 && len(f.Selectors) == 0
+}
+
+// Filter returns the metric families in mfs reduced to the metrics selected by
+// the filter. Metric families without any selected metrics are omitted. The
+// provided metric families are not modified. Filtered metric families are
+// returned as shallow copies of the original ones, sharing their metrics.
+func (f MetricFilter) Filter(mfs []*dto.MetricFamily) []*dto.MetricFamily {
+	if f.isEmpty() {
+		return mfs
+	}
+	result := make([]*dto.MetricFamily, 0, len(mfs))
+	for _, mf := range mfs {
+		if mf = f.filterFamily(mf); mf != nil {
+			result = append(result, mf)
+		}
+	}
+	return result
+}
+
+// filterFamily returns the provided metric family reduced to the metrics
+// selected by the (non-empty) filter, or nil if no metric is selected. See
+// Filter for details.
+func (f MetricFilter) filterFamily(mf *dto.MetricFamily) *dto.MetricFamily {
+	if f.selectsName(mf.GetName()) {
+		return mf
+	}
+	if len(f.Selectors) == 0 {
+		return nil
+	}
+	var metrics []*dto.Metric
+	for _, m := range mf.Metric {
+		if f.selectsMetric(mf.GetName(), m) {
+			metrics = append(metrics, m)
+		}
+	}
+	switch len(metrics) {
+	case 0:
+		return nil
+	case len(mf.Metric):
+		return mf
+	default:
+		return &dto.MetricFamily{
+			Name:   mf.Name,
+			Help:   mf.Help,
+			Type:   mf.Type,
+			Metric: metrics,
+		}
+	}
+}
+
+func (f MetricFilter) selectsName(name string) bool {
+	for _, n := range f.Names {
+		if n == name {
+			return true
+		}
+	}
+	for _, re := range f.NameRegexps {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f MetricFilter) selectsMetric(name string, m *dto.Metric) bool {
+	for _, selector := range f.Selectors {
+		if matchesAll(selector, name, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAll(matchers []*LabelMatcher, name string, m *dto.Metric) bool {
+	for _, matcher := range matchers {
+		v := ""
+		if matcher.Name == MetricNameLabel {
+			v = name
+		} else {
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == matcher.Name {
+					v = lp.GetValue()
+					break
+				}
+			}
+		}
+		if !matcher.Matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterGatherer returns a Gatherer that gathers from the provided Gatherer
+// and only returns the metrics selected by the provided MetricFilter. Errors
+// returned by the provided Gatherer are passed on unchanged. The returned
+// Gatherer implements ContextGatherer, UnitProvider, TypeProvider,
+// GenerationProvider, and StreamingGatherer, passing on the respective
+// information or calls to the provided Gatherer if it implements the
+// interface in question.
+func FilterGatherer(g Gatherer, f MetricFilter) Gatherer {
+	return &filteringGatherer{g: g, f: f}
+}
+
+type filteringGatherer struct {
+	g Gatherer
+	f MetricFilter
+}
+
+// Gather implements Gatherer.
+func (fg *filteringGatherer) Gather() ([]*dto.MetricFamily, error) {
+	return fg.GatherWithContext(context.Background())
+}
+
+// GatherWithContext implements ContextGatherer.
+func (fg *filteringGatherer) GatherWithContext(ctx context.Context) ([]*dto.MetricFamily, error) {
+	mfs, err := gatherWithContext(ctx, fg.g)
+	return fg.f.Filter(mfs), err
+}
+
+// Generation implements GenerationProvider by passing on the generation of the
+// wrapped Gatherer, if it implements GenerationProvider. The filter never
+// changes and therefore doesn't affect the generation.
+func (fg *filteringGatherer) Generation() uint64 {
+	return generationOf(fg.g)
+}
+
+// GatherStream implements StreamingGatherer by passing on the call to the
+// wrapped Gatherer if it implements StreamingGatherer and filtering each
+// MetricFamily handed over. Otherwise, all MetricFamilies are gathered and
+// filtered at once and then handed over one by one.
+func (fg *filteringGatherer) GatherStream(ctx context.Context, f func(*dto.MetricFamily) error) error {
+	sg, ok := fg.g.(StreamingGatherer)
+	if !ok {
+		mfs, err := fg.GatherWithContext(ctx)
+		for _, mf := range mfs {
+			if err := f(mf); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+	if fg.f.isEmpty() {
+		return sg.GatherStream(ctx, f)
+	}
+	return sg.GatherStream(ctx, func(mf *dto.MetricFamily) error {
+		if mf = fg.f.filterFamily(mf); mf == nil {
+			return nil
+		}
+		return f(mf)
+	})
+}
+
+// Units implements UnitProvider.
+func (fg *filteringGatherer) Units() map[string]string {
+	return unitsOf(fg.g)
+}
+
+// OpenMetricsTypes implements TypeProvider.
+func (fg *filteringGatherer) OpenMetricsTypes() map[string]string {
+	return openMetricsTypesOf(fg.g)
+}
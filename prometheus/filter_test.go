@@ -0,0 +1,179 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFilterGatherer(t *testing.T) {
+	reg := NewRegistry()
+	cnt := NewCounterVec(CounterOpts{Name: "requests_total", Help: "help"}, []string{"code", "method"})
+	cnt.WithLabelValues("200", "GET").Inc()
+	cnt.WithLabelValues("500", "GET").Inc()
+	cnt.WithLabelValues("500", "POST").Inc()
+	reg.MustRegister(
+		cnt,
+		NewGauge(GaugeOpts{Name: "go_temperature", Help: "help"}),
+		NewGauge(GaugeOpts{Name: "go_pressure", Help: "help"}),
+	)
+
+	mustMatcher := func(mt MatchType, name, value string) *LabelMatcher {
+		m, err := NewLabelMatcher(mt, name, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	for _, tc := range []struct {
+		name   string
+		filter MetricFilter
+		want   map[string]int // Number of metrics by family name.
+	}{
+		{
+			name:   "empty filter",
+			filter: MetricFilter{},
+			want:   map[string]int{"requests_total": 3, "go_temperature": 1, "go_pressure": 1},
+		},
+		{
+			name:   "names",
+			filter: MetricFilter{Names: []string{"go_pressure", "requests_total", "unknown"}},
+			want:   map[string]int{"requests_total": 3, "go_pressure": 1},
+		},
+		{
+			name:   "name regexps",
+			filter: MetricFilter{NameRegexps: []*regexp.Regexp{regexp.MustCompile("^go_")}},
+			want:   map[string]int{"go_temperature": 1, "go_pressure": 1},
+		},
+		{
+			name: "selectors",
+			filter: MetricFilter{Selectors: [][]*LabelMatcher{
+				{
+					mustMatcher(MatchEqual, MetricNameLabel, "requests_total"),
+					mustMatcher(MatchRegexp, "code", "5.."),
+					mustMatcher(MatchNotEqual, "method", "POST"),
+				},
+				{mustMatcher(MatchNotRegexp, MetricNameLabel, "go_.*|requests_total")},
+			}},
+			want: map[string]int{"requests_total": 1},
+		},
+		{
+			name: "missing label matches empty value",
+			filter: MetricFilter{Selectors: [][]*LabelMatcher{
+				{mustMatcher(MatchEqual, "code", "")},
+			}},
+			want: map[string]int{"go_temperature": 1, "go_pressure": 1},
+		},
+		{
+			name: "union",
+			filter: MetricFilter{
+				Names:     []string{"go_pressure"},
+				Selectors: [][]*LabelMatcher{{mustMatcher(MatchEqual, "method", "POST")}},
+			},
+			want: map[string]int{"requests_total": 1, "go_pressure": 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mfs, err := FilterGatherer(reg, tc.filter).Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]int{}
+			for _, mf := range mfs {
+				got[mf.GetName()] = len(mf.GetMetric())
+			}
+			if len(got) != len(tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			for name, n := range tc.want {
+				if got[name] != n {
+					t.Errorf("got %v, want %v", got, tc.want)
+				}
+			}
+
+			// Streaming must yield the same result.
+			var streamed []*dto.MetricFamily
+			err = FilterGatherer(reg, tc.filter).(StreamingGatherer).GatherStream(
+				context.Background(),
+				func(mf *dto.MetricFamily) error {
+					streamed = append(streamed, mf)
+					return nil
+				},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(streamed) != len(mfs) {
+				t.Fatalf("got %d streamed metric families, want %d", len(streamed), len(mfs))
+			}
+			for i := range mfs {
+				if !proto.Equal(streamed[i], mfs[i]) {
+					t.Errorf("got streamed metric family %v, want %v", streamed[i], mfs[i])
+				}
+			}
+		})
+	}
+
+	// The generation of the wrapped Registry is passed on.
+	fg := FilterGatherer(reg, MetricFilter{}).(GenerationProvider)
+	before := fg.Generation()
+	reg.MustRegister(NewGauge(GaugeOpts{Name: "go_humidity", Help: "help"}))
+	if fg.Generation() == before {
+		t.Error("generation did not change after registering a collector")
+	}
+}
+
+func TestFilterDoesNotModifyInput(t *testing.T) {
+	mf := &dto.MetricFamily{
+		Name: proto.String("test"),
+		Type: dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{
+			{Label: []*dto.LabelPair{{Name: proto.String("a"), Value: proto.String("1")}}, Gauge: &dto.Gauge{}},
+			{Label: []*dto.LabelPair{{Name: proto.String("a"), Value: proto.String("2")}}, Gauge: &dto.Gauge{}},
+		},
+	}
+	m, err := NewLabelMatcher(MatchEqual, "a", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := MetricFilter{Selectors: [][]*LabelMatcher{{m}}}.Filter([]*dto.MetricFamily{mf})
+	if len(got) != 1 || len(got[0].Metric) != 1 || got[0].Metric[0] != mf.Metric[1] {
+		t.Errorf("unexpected filter result %v", got)
+	}
+	if len(mf.Metric) != 2 {
+		t.Errorf("input metric family was modified: %v", mf)
+	}
+}
+
+func TestNewLabelMatcher(t *testing.T) {
+	if _, err := NewLabelMatcher(MatchRegexp, "a", "("); err == nil {
+		t.Error("expected error for invalid regexp")
+	}
+	if _, err := NewLabelMatcher(MatchType(42), "a", "b"); err == nil {
+		t.Error("expected error for unknown match type")
+	}
+	m, err := NewLabelMatcher(MatchRegexp, "a", "b|c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Matches("c") || m.Matches("bc") {
+		t.Error("regexp matcher is not anchored")
+	}
+}
@@ -65,6 +65,15 @@ type GaugeVecOpts struct {
 	// of labels. Each label value will be constrained with the optional Contraint
 	// function, if provided.
 	VariableLabels ConstrainableLabels
+
+	// CardinalityLimit caps the number of children the vector may hold
+	// and defines what happens to new label value combinations once the
+	// cap is reached. The zero value imposes no limit.
+	CardinalityLimit CardinalityLimit
+	// TTL, if positive, is the duration after which children that have
+	// been neither accessed nor updated are deleted from the vector. See
+	// MetricVec.SetTTL for details.
+	TTL time.Duration
 }
 
 // NewGauge creates a new Gauge based on the provided GaugeOpts.
@@ -76,9 +85,10 @@ type GaugeVecOpts struct {
 // scenarios for Gauges and Counters, where the former tends to be Set-heavy and
 // the latter Inc-heavy.
 func NewGauge(opts GaugeOpts) Gauge {
-	desc := NewDesc(
+	desc := NewDescWithUnit(
 		BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
 		opts.Help,
+		opts.Unit,
 		nil,
 		opts.ConstLabels,
 	)
@@ -135,7 +145,7 @@ func (g *gauge) Sub(val float64) {
 
 func (g *gauge) Write(out *dto.Metric) error {
 	val := math.Float64frombits(atomic.LoadUint64(&g.valBits))
-	return populateMetric(GaugeValue, val, g.labelPairs, nil, out)
+	return populateMetric(GaugeValue, val, g.labelPairs, nil, time.Time{}, out)
 }
 
 // GaugeVec is a Collector that bundles a set of Gauges that all share the same
@@ -158,22 +168,24 @@ func NewGaugeVec(opts GaugeOpts, labelNames []string) *GaugeVec {
 
 // NewGaugeVec creates a new GaugeVec based on the provided GaugeVecOpts.
 func (v2) NewGaugeVec(opts GaugeVecOpts) *GaugeVec {
-	desc := V2.NewDesc(
+	desc := V2.NewDescWithUnit(
 		BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
 		opts.Help,
+		opts.Unit,
 		opts.VariableLabels,
 		opts.ConstLabels,
 	)
-	return &GaugeVec{
-		MetricVec: NewMetricVec(desc, func(lvs ...string) Metric {
-			if len(lvs) != len(desc.variableLabels) {
-				panic(makeInconsistentCardinalityError(desc.fqName, desc.variableLabels.labelNames(), lvs))
-			}
-			result := &gauge{desc: desc, labelPairs: MakeLabelPairs(desc, lvs)}
-			result.init(result) // Init self-collection.
-			return result
-		}),
-	}
+	vec := NewMetricVec(desc, func(lvs ...string) Metric {
+		if len(lvs) != len(desc.variableLabels) {
+			panic(makeInconsistentCardinalityError(desc.fqName, desc.variableLabels.labelNames(), lvs))
+		}
+		result := &gauge{desc: desc, labelPairs: MakeLabelPairs(desc, lvs)}
+		result.init(result) // Init self-collection.
+		return result
+	})
+	vec.SetCardinalityLimit(opts.CardinalityLimit)
+	vec.SetTTL(opts.TTL)
+	return &GaugeVec{vec}
 }
 
 // GetMetricWithLabelValues returns the Gauge for the given slice of label
@@ -302,9 +314,10 @@ type GaugeFunc interface {
 // value of 1. Example:
 // https://github.com/prometheus/common/blob/8558a5b7db3c84fa38b4766966059a7bd5bfa2ee/version/info.go#L36-L56
 func NewGaugeFunc(opts GaugeOpts, function func() float64) GaugeFunc {
-	return newValueFunc(NewDesc(
+	return newValueFunc(NewDescWithUnit(
 		BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
 		opts.Help,
+		opts.Unit,
 		nil,
 		opts.ConstLabels,
 	), GaugeValue, function)
@@ -0,0 +1,426 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync/atomic"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// GaugeHistogram is a Metric that represents the current distribution of a set
+// of values in buckets, like the ages of the items currently in a queue. Unlike
+// the bucket counts of a Histogram, the bucket counts of a GaugeHistogram can
+// arbitrarily go up and down. This is the GaugeHistogram type of OpenMetrics.
+//
+// In the OpenMetrics text format, a GaugeHistogram is exposed with its own
+// type and with _gcount and _gsum series (see the promhttp package). The
+// classic Prometheus text format has no gauge histograms, so they are exposed
+// as regular histograms there.
+//
+// To create GaugeHistogram instances, use NewGaugeHistogram.
+type GaugeHistogram interface {
+	Metric
+	Collector
+
+	// Set sets the number of values in the bucket with the provided upper
+	// bound. Bucket counts are not cumulative, i.e. they do not include
+	// the values in buckets with lower upper bounds. Use math.Inf(+1) for
+	// the implicit +Inf bucket. Set panics if there is no bucket with the
+	// provided upper bound.
+	Set(upperBound float64, count uint64)
+	// Add adds the provided count to the bucket with the provided upper
+	// bound. See Set for details.
+	Add(upperBound float64, count uint64)
+	// Sub subtracts the provided count from the bucket with the provided
+	// upper bound. It panics if the bucket count would become negative.
+	// See Set for details.
+	Sub(upperBound float64, count uint64)
+
+	// SetSum sets the sum of all values in the GaugeHistogram.
+	SetSum(float64)
+	// AddSum adds the provided value to the sum of all values. (The value
+	// can be negative, resulting in a decrease of the sum.)
+	AddSum(float64)
+}
+
+// GaugeHistogramOpts bundles the options for creating a GaugeHistogram
+// metric. It is mandatory to set Name to a non-empty string. All other fields
+// are optional and can safely be left at their zero value, although it is
+// strongly encouraged to set a Help string.
+type GaugeHistogramOpts struct {
+	// Namespace, Subsystem, Name, Help, Unit, and ConstLabels have the
+	// same meaning as in Opts. See there for details.
+	Namespace string
+	Subsystem string
+	Name      string
+	Help      string
+	Unit      string
+
+	ConstLabels Labels
+
+	// Buckets defines the buckets into which values are counted. Each
+	// element in the slice is the upper inclusive bound of a bucket. The
+	// values must be sorted in strictly increasing order. There is no need
+	// to add a highest bucket with +Inf bound, it will be added
+	// implicitly. If Buckets is left as nil or set to a slice of length
+	// zero, it is replaced by default buckets (DefBuckets).
+	Buckets []float64
+}
+
+// GaugeHistogramVecOpts bundles the options to create a GaugeHistogramVec
+// metric. It is mandatory to set GaugeHistogramOpts, see there for mandatory
+// fields. VariableLabels is optional and can safely be left to its default
+// value.
+type GaugeHistogramVecOpts struct {
+	GaugeHistogramOpts
+
+	// VariableLabels are used to partition the metric vector by the given set
+	// of labels. Each label value will be constrained with the optional Contraint
+	// function, if provided.
+	VariableLabels ConstrainableLabels
+}
+
+// NewGaugeHistogram creates a new GaugeHistogram based on the provided
+// GaugeHistogramOpts. It panics if the buckets in GaugeHistogramOpts are not
+// in strictly increasing order.
+func NewGaugeHistogram(opts GaugeHistogramOpts) GaugeHistogram {
+	desc := NewDescWithUnit(
+		BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+		opts.Help,
+		opts.Unit,
+		nil,
+		opts.ConstLabels,
+	)
+	desc.openMetricsType = "gaugehistogram"
+	return newGaugeHistogram(desc, opts)
+}
+
+func newGaugeHistogram(desc *Desc, opts GaugeHistogramOpts, labelValues ...string) GaugeHistogram {
+	if len(desc.variableLabels) != len(labelValues) {
+		panic(makeInconsistentCardinalityError(desc.fqName, desc.variableLabels.labelNames(), labelValues))
+	}
+	for _, n := range desc.variableLabels {
+		if n.Name == bucketLabel {
+			panic(errBucketLabelNotAllowed)
+		}
+	}
+	for _, lp := range desc.constLabelPairs {
+		if lp.GetName() == bucketLabel {
+			panic(errBucketLabelNotAllowed)
+		}
+	}
+
+	upperBounds := opts.Buckets
+	if len(upperBounds) == 0 {
+		upperBounds = DefBuckets
+	}
+	for i, upperBound := range upperBounds {
+		if i < len(upperBounds)-1 {
+			if upperBound >= upperBounds[i+1] {
+				panic(fmt.Errorf(
+					"gauge histogram buckets must be in increasing order: %f >= %f",
+					upperBound, upperBounds[i+1],
+				))
+			}
+		} else if math.IsInf(upperBound, +1) {
+			// The +Inf bucket is implicit. Remove it here.
+			upperBounds = upperBounds[:i]
+		}
+	}
+
+	h := &gaugeHistogram{
+		desc:        desc,
+		upperBounds: upperBounds,
+		counts:      make([]uint64, len(upperBounds)+1),
+		labelPairs:  MakeLabelPairs(desc, labelValues),
+	}
+	h.init(h) // Init self-collection.
+	return h
+}
+
+type gaugeHistogram struct {
+	// sumBits contains the bits of the float64 representing the sum of all
+	// values. It has to go first in the struct to guarantee alignment for
+	// atomic operations.  http://golang.org/pkg/sync/atomic/#pkg-note-BUG
+	sumBits uint64
+
+	selfCollector
+
+	desc        *Desc
+	upperBounds []float64 // Without the +Inf bucket.
+	// counts are the non-cumulative bucket counts, with the count of the
+	// +Inf bucket last. Accessed atomically.
+	counts     []uint64
+	labelPairs []*dto.LabelPair
+}
+
+func (h *gaugeHistogram) Desc() *Desc {
+	return h.desc
+}
+
+func (h *gaugeHistogram) Set(upperBound float64, count uint64) {
+	atomic.StoreUint64(&h.counts[h.bucketIndex(upperBound)], count)
+}
+
+func (h *gaugeHistogram) Add(upperBound float64, count uint64) {
+	atomic.AddUint64(&h.counts[h.bucketIndex(upperBound)], count)
+}
+
+func (h *gaugeHistogram) Sub(upperBound float64, count uint64) {
+	bucket := &h.counts[h.bucketIndex(upperBound)]
+	for {
+		old := atomic.LoadUint64(bucket)
+		if count > old {
+			panic(fmt.Errorf(
+				"cannot subtract %d from count %d of bucket %g of gauge histogram %q",
+				count, old, upperBound, h.desc.fqName,
+			))
+		}
+		if atomic.CompareAndSwapUint64(bucket, old, old-count) {
+			return
+		}
+	}
+}
+
+func (h *gaugeHistogram) SetSum(v float64) {
+	atomic.StoreUint64(&h.sumBits, math.Float64bits(v))
+}
+
+func (h *gaugeHistogram) AddSum(v float64) {
+	for {
+		oldBits := atomic.LoadUint64(&h.sumBits)
+		newBits := math.Float64bits(math.Float64frombits(oldBits) + v)
+		if atomic.CompareAndSwapUint64(&h.sumBits, oldBits, newBits) {
+			return
+		}
+	}
+}
+
+// bucketIndex returns the index in h.counts of the bucket with the provided
+// upper bound. It panics if there is no such bucket.
+func (h *gaugeHistogram) bucketIndex(upperBound float64) int {
+	if math.IsInf(upperBound, +1) {
+		return len(h.upperBounds)
+	}
+	i := sort.SearchFloat64s(h.upperBounds, upperBound)
+	if i == len(h.upperBounds) || h.upperBounds[i] != upperBound {
+		panic(fmt.Errorf("gauge histogram %q has no bucket with upper bound %g", h.desc.fqName, upperBound))
+	}
+	return i
+}
+
+func (h *gaugeHistogram) Write(out *dto.Metric) error {
+	// Note that concurrent updates of different buckets might be only
+	// partially reflected in the written metric, just as with a set of
+	// individual gauges.
+	his := &dto.Histogram{
+		SampleSum: proto.Float64(math.Float64frombits(atomic.LoadUint64(&h.sumBits))),
+		Bucket:    make([]*dto.Bucket, len(h.upperBounds)),
+	}
+	var cumCount uint64
+	for i, upperBound := range h.upperBounds {
+		cumCount += atomic.LoadUint64(&h.counts[i])
+		his.Bucket[i] = &dto.Bucket{
+			CumulativeCount: proto.Uint64(cumCount),
+			UpperBound:      proto.Float64(upperBound),
+		}
+	}
+	cumCount += atomic.LoadUint64(&h.counts[len(h.upperBounds)])
+	his.SampleCount = proto.Uint64(cumCount)
+
+	out.Histogram = his
+	out.Label = h.labelPairs
+	return nil
+}
+
+// GaugeHistogramVec is a Collector that bundles a set of GaugeHistograms that
+// all share the same Desc, but have different values for their variable
+// labels. This is used if you want to track the same distribution partitioned
+// by various dimensions (e.g. the ages of the items in each of several queues).
+// Create instances with NewGaugeHistogramVec.
+type GaugeHistogramVec struct {
+	*MetricVec
+}
+
+// NewGaugeHistogramVec creates a new GaugeHistogramVec based on the provided
+// GaugeHistogramOpts and partitioned by the given label names.
+func NewGaugeHistogramVec(opts GaugeHistogramOpts, labelNames []string) *GaugeHistogramVec {
+	return V2.NewGaugeHistogramVec(GaugeHistogramVecOpts{
+		GaugeHistogramOpts: opts,
+		VariableLabels:     UnconstrainedLabels(labelNames),
+	})
+}
+
+// NewGaugeHistogramVec creates a new GaugeHistogramVec based on the provided
+// GaugeHistogramVecOpts.
+func (v2) NewGaugeHistogramVec(opts GaugeHistogramVecOpts) *GaugeHistogramVec {
+	desc := V2.NewDescWithUnit(
+		BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+		opts.Help,
+		opts.Unit,
+		opts.VariableLabels,
+		opts.ConstLabels,
+	)
+	desc.openMetricsType = "gaugehistogram"
+	return &GaugeHistogramVec{
+		MetricVec: NewMetricVec(desc, func(lvs ...string) Metric {
+			return newGaugeHistogram(desc, opts.GaugeHistogramOpts, lvs...)
+		}),
+	}
+}
+
+// GetMetricWithLabelValues returns the GaugeHistogram for the given slice of
+// label values (same order as the variable labels in Desc). If that combination
+// of label values is accessed for the first time, a new GaugeHistogram is
+// created.
+//
+// Implications of creating a GaugeHistogram without using it and keeping the
+// GaugeHistogram for later use are the same as for
+// GaugeVec.GetMetricWithLabelValues.
+//
+// An error is returned if the number of label values is not the same as the
+// number of variable labels in Desc (minus any curried labels).
+func (v *GaugeHistogramVec) GetMetricWithLabelValues(lvs ...string) (GaugeHistogram, error) {
+	metric, err := v.MetricVec.GetMetricWithLabelValues(lvs...)
+	if metric != nil {
+		return metric.(GaugeHistogram), err
+	}
+	return nil, err
+}
+
+// GetMetricWith returns the GaugeHistogram for the given Labels map (the label
+// names must match those of the variable labels in Desc). If that label map is
+// accessed for the first time, a new GaugeHistogram is created.
+//
+// An error is returned if the number and names of the Labels are inconsistent
+// with those of the variable labels in Desc (minus any curried labels).
+func (v *GaugeHistogramVec) GetMetricWith(labels Labels) (GaugeHistogram, error) {
+	metric, err := v.MetricVec.GetMetricWith(labels)
+	if metric != nil {
+		return metric.(GaugeHistogram), err
+	}
+	return nil, err
+}
+
+// WithLabelValues works as GetMetricWithLabelValues, but panics where
+// GetMetricWithLabelValues would have returned an error. Not returning an
+// error allows shortcuts like
+//
+//	myVec.WithLabelValues("emails").Set(60, 42)
+func (v *GaugeHistogramVec) WithLabelValues(lvs ...string) GaugeHistogram {
+	h, err := v.GetMetricWithLabelValues(lvs...)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// With works as GetMetricWith, but panics where GetMetricWithLabels would have
+// returned an error. Not returning an error allows shortcuts like
+//
+//	myVec.With(prometheus.Labels{"queue": "emails"}).Set(60, 42)
+func (v *GaugeHistogramVec) With(labels Labels) GaugeHistogram {
+	h, err := v.GetMetricWith(labels)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// CurryWith returns a vector curried with the provided labels, i.e. the
+// returned vector has those labels pre-set for all labeled operations performed
+// on it. See GaugeVec.CurryWith for details.
+func (v *GaugeHistogramVec) CurryWith(labels Labels) (*GaugeHistogramVec, error) {
+	vec, err := v.MetricVec.CurryWith(labels)
+	if vec != nil {
+		return &GaugeHistogramVec{vec}, err
+	}
+	return nil, err
+}
+
+// MustCurryWith works as CurryWith but panics where CurryWith would have
+// returned an error.
+func (v *GaugeHistogramVec) MustCurryWith(labels Labels) *GaugeHistogramVec {
+	vec, err := v.CurryWith(labels)
+	if err != nil {
+		panic(err)
+	}
+	return vec
+}
+
+type constGaugeHistogram struct {
+	h    *constHistogram
+	desc *Desc // Marked as a gauge histogram.
+}
+
+func (h *constGaugeHistogram) Desc() *Desc {
+	return h.desc
+}
+
+func (h *constGaugeHistogram) Write(out *dto.Metric) error {
+	return h.h.Write(out)
+}
+
+// NewConstGaugeHistogram returns a metric representing a gauge histogram with
+// fixed values for the count, sum, and bucket counts. It is the GaugeHistogram
+// equivalent of NewConstHistogram, see there for details about the parameters.
+//
+// NewConstGaugeHistogram returns an error if the length of labelValues is not
+// consistent with the variable labels in Desc or if Desc is invalid.
+func NewConstGaugeHistogram(
+	desc *Desc,
+	count uint64,
+	sum float64,
+	buckets map[float64]uint64,
+	labelValues ...string,
+) (Metric, error) {
+	m, err := NewConstHistogram(desc, count, sum, buckets, labelValues...)
+	if err != nil {
+		return nil, err
+	}
+	return &constGaugeHistogram{h: m.(*constHistogram), desc: gaugeHistogramDesc(desc)}, nil
+}
+
+// gaugeHistogramDesc returns the provided Desc if it is marked as the Desc of
+// a gauge histogram, or a marked copy of it otherwise. (The provided Desc is
+// not modified as it might be in concurrent use.)
+func gaugeHistogramDesc(desc *Desc) *Desc {
+	if desc.openMetricsType == "gaugehistogram" {
+		return desc
+	}
+	marked := *desc
+	marked.openMetricsType = "gaugehistogram"
+	return &marked
+}
+
+// MustNewConstGaugeHistogram is a version of NewConstGaugeHistogram that panics
+// where NewConstGaugeHistogram would have returned an error.
+func MustNewConstGaugeHistogram(
+	desc *Desc,
+	count uint64,
+	sum float64,
+	buckets map[float64]uint64,
+	labelValues ...string,
+) Metric {
+	m, err := NewConstGaugeHistogram(desc, count, sum, buckets, labelValues...)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
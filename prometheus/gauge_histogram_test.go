@@ -0,0 +1,126 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestGaugeHistogram(t *testing.T) {
+	h := NewGaugeHistogram(GaugeHistogramOpts{
+		Name:    "queue_age_seconds",
+		Help:    "Age of the queued items.",
+		Buckets: []float64{1, 10},
+	})
+	h.Set(1, 5)
+	h.Add(10, 3)
+	h.Add(math.Inf(+1), 2)
+	h.Sub(1, 2)
+	h.SetSum(100)
+	h.AddSum(-20)
+
+	m := &dto.Metric{}
+	if err := h.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	his := m.GetHistogram()
+	if got, want := his.GetSampleCount(), uint64(8); got != want {
+		t.Errorf("got count %d, want %d", got, want)
+	}
+	if got, want := his.GetSampleSum(), 80.0; got != want {
+		t.Errorf("got sum %g, want %g", got, want)
+	}
+	for i, want := range []uint64{3, 6} {
+		if got := his.GetBucket()[i].GetCumulativeCount(); got != want {
+			t.Errorf("bucket %d: got cumulative count %d, want %d", i, got, want)
+		}
+	}
+
+	assertPanics(t, func() { h.Set(5, 1) }, "unknown bucket")
+	assertPanics(t, func() { h.Sub(1, 4) }, "negative bucket count")
+	assertPanics(t, func() {
+		NewGaugeHistogram(GaugeHistogramOpts{Name: "x", Buckets: []float64{2, 1}})
+	}, "decreasing buckets")
+}
+
+// constCollector is a Collector collecting the provided metrics.
+type constCollector []Metric
+
+func (c constCollector) Describe(ch chan<- *Desc) { DescribeByCollect(c, ch) }
+
+func (c constCollector) Collect(ch chan<- Metric) {
+	for _, m := range c {
+		ch <- m
+	}
+}
+
+// userWrappedMetric wraps a Metric like user code might do.
+type userWrappedMetric struct {
+	Metric
+}
+
+func TestGaugeHistogramGather(t *testing.T) {
+	reg := NewPedanticRegistry()
+	vec := NewGaugeHistogramVec(GaugeHistogramOpts{
+		Name:    "queue_age_seconds",
+		Help:    "Age of the queued items.",
+		Buckets: []float64{1},
+	}, []string{"queue"})
+	vec.WithLabelValues("emails").Set(1, 2)
+	desc := NewDesc("pool_size_bytes", "Size of pooled objects.", nil, nil)
+	reg.MustRegister(vec, constCollector{
+		NewMetricWithTimestamp(time.Unix(1, 0), MustNewConstGaugeHistogram(desc, 3, 42, map[float64]uint64{64: 1})),
+	})
+	WrapRegistererWithPrefix("wrapped_", reg).MustRegister(constCollector{
+		MustNewConstGaugeHistogram(desc, 3, 42, map[float64]uint64{64: 1}),
+	})
+	// Wrappers not known to this package must keep the type, too.
+	WrapRegistererWithPrefix("user_", reg).MustRegister(constCollector{
+		userWrappedMetric{MustNewConstGaugeHistogram(desc, 3, 42, map[float64]uint64{64: 1})},
+	})
+	WrapRegistererWithPrefix("cached_", reg).MustRegister(NewCachedCollector(NewGaugeHistogram(GaugeHistogramOpts{
+		Name:    "queue_age_seconds",
+		Help:    "Age of the queued items.",
+		Buckets: []float64{1},
+	}), time.Minute))
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) != 5 {
+		t.Fatalf("got %d metric families, want 5", len(mfs))
+	}
+	for _, mf := range mfs {
+		if got, want := mf.GetType(), dto.MetricType_GAUGE_HISTOGRAM; got != want {
+			t.Errorf("%s: got type %s, want %s", mf.GetName(), got, want)
+		}
+	}
+
+	// A regular histogram with the same name must be rejected.
+	other := NewRegistry()
+	other.MustRegister(constCollector{
+		MustNewConstHistogram(
+			NewDesc("queue_age_seconds", "Age of the queued items.", []string{"queue"}, nil),
+			1, 1, nil, "other",
+		),
+	})
+	if _, err := (Gatherers{reg, other}).Gather(); err == nil {
+		t.Error("mixing histograms and gauge histograms succeeded unexpectedly")
+	}
+}
@@ -253,6 +253,20 @@ type Histogram interface {
 	Observe(float64)
 }
 
+// HistogramReader is implemented by Histograms that allow reading their current
+// state in-process, e.g. to base adaptive logic like load shedding on a
+// quantile of the observations, without going through the Write method.
+type HistogramReader interface {
+	// Snapshot returns the state of the histogram at the time of the
+	// call. It is safe to call concurrently with observations.
+	Snapshot() HistogramSnapshot
+	// WindowSnapshot returns the state of the observations within the
+	// sliding window configured via the WindowMaxAge field of
+	// HistogramOpts. If no window is configured, it returns the same as
+	// Snapshot.
+	WindowSnapshot() HistogramSnapshot
+}
+
 // bucketLabel is used for the label that defines the upper bound of a
 // bucket of a histogram ("le" -> "less or equal").
 const bucketLabel = "le"
@@ -270,6 +284,25 @@ var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
 // which is a bucket boundary at all possible resolutions.
 const DefNativeHistogramZeroThreshold = 2.938735877055719e-39
 
+// DefNativeHistogramExemplarWindow is the default value for
+// NativeHistogramExemplarWindow in the HistogramOpts.
+const DefNativeHistogramExemplarWindow = time.Minute
+
+// ExemplarStrategy determines which exemplars are kept in the exemplar
+// reservoir of a Histogram, see the NativeHistogramMaxExemplars field in
+// HistogramOpts.
+type ExemplarStrategy int
+
+// Possible values for ExemplarStrategy.
+const (
+	// ExemplarsLatest keeps the most recent exemplars.
+	ExemplarsLatest ExemplarStrategy = iota
+	// ExemplarsMaxValue keeps the exemplar with the highest value within
+	// each time window (as configured by NativeHistogramExemplarWindow),
+	// for the most recent time windows.
+	ExemplarsMaxValue
+)
+
 // NativeHistogramZeroThresholdZero can be used as NativeHistogramZeroThreshold
 // in the HistogramOpts to create a zero bucket of width zero, i.e. a zero
 // bucket that only receives observations of precisely zero.
@@ -371,6 +404,14 @@ type HistogramOpts struct {
 	// string.
 	Help string
 
+	// Unit is the unit of this Histogram, e.g. "seconds" or "bytes". It is
+	// optional. If set, the fully-qualified name must end with the unit
+	// (in case of a counter followed by "_total"), as required by
+	// OpenMetrics. The unit is exposed in the OpenMetrics text format.
+	//
+	// Metrics with the same fully-qualified name must have the same Unit.
+	Unit string
+
 	// ConstLabels are used to attach fixed labels to this metric. Metrics
 	// with the same fully-qualified name must have the same label names in
 	// their ConstLabels.
@@ -466,6 +507,45 @@ type HistogramOpts struct {
 	NativeHistogramMaxBucketNumber  uint32
 	NativeHistogramMinResetDuration time.Duration
 	NativeHistogramMaxZeroThreshold float64
+
+	// If NativeHistogramMaxExemplars is positive, the Histogram keeps up to
+	// that many exemplars in an exemplar reservoir, independent of any
+	// buckets. Which exemplars are kept is determined by
+	// NativeHistogramExemplarStrategy. This is in particular useful for
+	// native histograms without regular buckets, which otherwise only keep
+	// one exemplar. The reservoir can be read in-process via
+	// HistogramReader, and all its exemplars are exposed in the exemplars
+	// field of the histogram in the protobuf exposition format. (Regular
+	// buckets keep their most recent exemplar as usual.)
+	NativeHistogramMaxExemplars int
+	// NativeHistogramExemplarStrategy determines which exemplars the
+	// exemplar reservoir keeps, see ExemplarStrategy. The default is
+	// ExemplarsLatest.
+	NativeHistogramExemplarStrategy ExemplarStrategy
+	// NativeHistogramExemplarWindow is the duration of the time windows
+	// used with the ExemplarsMaxValue strategy. If it is left at zero,
+	// DefNativeHistogramExemplarWindow is used.
+	NativeHistogramExemplarWindow time.Duration
+
+	// If WindowMaxAge is positive, the Histogram additionally tracks the
+	// observations of the recent past in a sliding window, similar to the
+	// MaxAge of a Summary. The observations within the window can be read
+	// in-process via the WindowSnapshot method of HistogramReader. The
+	// exposed histogram is not affected and stays cumulative, so that it
+	// can still be aggregated and used with rate() as usual.
+	//
+	// Tracking the window requires a (shared) lock during each
+	// observation, which adds to the overhead of the Observe method. The
+	// native buckets in the window always use the resolution and zero
+	// threshold initially configured above, i.e. the bucket count
+	// limitation strategy does not apply to them.
+	WindowMaxAge time.Duration
+	// WindowAgeBuckets is the number of buckets the window is divided
+	// into. Observations are removed from the window one bucket at a time,
+	// so that the window covers between WindowMaxAge*(WindowAgeBuckets-1)/
+	// WindowAgeBuckets and WindowMaxAge. The default value is
+	// DefAgeBuckets.
+	WindowAgeBuckets uint32
 }
 
 // HistogramVecOpts bundles the options to create a HistogramVec metric.
@@ -478,19 +558,30 @@ type HistogramVecOpts struct {
 	// of labels. Each label value will be constrained with the optional Contraint
 	// function, if provided.
 	VariableLabels ConstrainableLabels
+
+	// CardinalityLimit caps the number of children the vector may hold
+	// and defines what happens to new label value combinations once the
+	// cap is reached. The zero value imposes no limit.
+	CardinalityLimit CardinalityLimit
+	// TTL, if positive, is the duration after which children that have
+	// been neither accessed nor updated are deleted from the vector. See
+	// MetricVec.SetTTL for details.
+	TTL time.Duration
 }
 
 // NewHistogram creates a new Histogram based on the provided HistogramOpts. It
 // panics if the buckets in HistogramOpts are not in strictly increasing order.
 //
-// The returned implementation also implements ExemplarObserver. It is safe to
-// perform the corresponding type assertion. Exemplars are tracked separately
+// The returned implementation also implements ExemplarObserver,
+// BatchObserver, HistogramReader, and CreatedTimestamper. It is safe to
+// perform the corresponding type assertions. Exemplars are tracked separately
 // for each bucket.
 func NewHistogram(opts HistogramOpts) Histogram {
 	return newHistogram(
-		NewDesc(
+		NewDescWithUnit(
 			BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
 			opts.Help,
+			opts.Unit,
 			nil,
 			opts.ConstLabels,
 		),
@@ -562,6 +653,33 @@ func newHistogram(desc *Desc, opts HistogramOpts, labelValues ...string) Histogr
 	atomic.StoreUint64(&h.counts[1].nativeHistogramZeroThresholdBits, math.Float64bits(h.nativeHistogramZeroThreshold))
 	atomic.StoreInt32(&h.counts[1].nativeHistogramSchema, h.nativeHistogramSchema)
 	h.exemplars = make([]atomic.Value, len(h.upperBounds)+1)
+	if opts.WindowMaxAge < 0 {
+		panic(fmt.Errorf("illegal window max age WindowMaxAge=%v", opts.WindowMaxAge))
+	}
+	if opts.WindowMaxAge > 0 {
+		if opts.WindowAgeBuckets == 0 {
+			opts.WindowAgeBuckets = DefAgeBuckets
+		}
+		h.window = h.newWindow(opts.WindowMaxAge, opts.WindowAgeBuckets)
+	}
+	if opts.NativeHistogramMaxExemplars > 0 {
+		switch opts.NativeHistogramExemplarStrategy {
+		case ExemplarsLatest, ExemplarsMaxValue:
+		default:
+			panic(fmt.Errorf("unknown exemplar strategy %d", opts.NativeHistogramExemplarStrategy))
+		}
+		if opts.NativeHistogramExemplarWindow < 0 {
+			panic(fmt.Errorf("illegal exemplar window NativeHistogramExemplarWindow=%v", opts.NativeHistogramExemplarWindow))
+		}
+		if opts.NativeHistogramExemplarWindow == 0 {
+			opts.NativeHistogramExemplarWindow = DefNativeHistogramExemplarWindow
+		}
+		h.exemplarReservoir = &exemplarReservoir{
+			maxExemplars: opts.NativeHistogramMaxExemplars,
+			strategy:     opts.NativeHistogramExemplarStrategy,
+			window:       opts.NativeHistogramExemplarWindow,
+		}
+	}
 
 	h.init(h) // Init self-collection.
 	return h
@@ -606,60 +724,101 @@ type histogramCounts struct {
 	nativeHistogramBucketsPositive, nativeHistogramBucketsNegative sync.Map
 }
 
-// observe manages the parts of observe that only affects
+// observeN manages the parts of observeN that only affects
 // histogramCounts. doSparse is true if sparse buckets should be done,
-// too.
-func (hc *histogramCounts) observe(v float64, bucket int, doSparse bool) {
+// too. The observation is added n times.
+func (hc *histogramCounts) observeN(v float64, bucket int, doSparse bool, n uint64) {
 	if bucket < len(hc.buckets) {
-		atomic.AddUint64(&hc.buckets[bucket], 1)
-	}
-	atomicAddFloat(&hc.sumBits, v)
-	if doSparse && !math.IsNaN(v) {
-		var (
-			key                  int
-			schema               = atomic.LoadInt32(&hc.nativeHistogramSchema)
-			zeroThreshold        = math.Float64frombits(atomic.LoadUint64(&hc.nativeHistogramZeroThresholdBits))
-			bucketCreated, isInf bool
-		)
-		if math.IsInf(v, 0) {
-			// Pretend v is MaxFloat64 but later increment key by one.
-			if math.IsInf(v, +1) {
-				v = math.MaxFloat64
-			} else {
-				v = -math.MaxFloat64
-			}
-			isInf = true
+		atomic.AddUint64(&hc.buckets[bucket], n)
+	}
+	if n == 1 {
+		atomicAddFloat(&hc.sumBits, v)
+	} else {
+		atomicAddFloat(&hc.sumBits, v*float64(n))
+	}
+	if doSparse {
+		hc.observeSparse(v, n)
+	}
+	// Increment count last as we take it as a signal that the observation
+	// is complete.
+	atomic.AddUint64(&hc.count, n)
+}
+
+// observeMany works like observeN with n=1 for each of the provided values, with
+// buckets[i] being the bucket of values[i]. The regular buckets, the sum, and
+// the count are only updated once.
+func (hc *histogramCounts) observeMany(values []float64, buckets []int, doSparse bool) {
+	var (
+		sum    float64
+		counts = make([]uint64, len(hc.buckets))
+	)
+	for i, v := range values {
+		if buckets[i] < len(counts) {
+			counts[buckets[i]]++
 		}
-		frac, exp := math.Frexp(math.Abs(v))
-		if schema > 0 {
-			bounds := nativeHistogramBounds[schema]
-			key = sort.SearchFloat64s(bounds, frac) + (exp-1)*len(bounds)
-		} else {
-			key = exp
-			if frac == 0.5 {
-				key--
-			}
-			div := 1 << -schema
-			key = (key + div - 1) / div
+		sum += v
+		if doSparse {
+			hc.observeSparse(v, 1)
 		}
-		if isInf {
-			key++
+	}
+	for i, c := range counts {
+		if c > 0 {
+			atomic.AddUint64(&hc.buckets[i], c)
 		}
-		switch {
-		case v > zeroThreshold:
-			bucketCreated = addToBucket(&hc.nativeHistogramBucketsPositive, key, 1)
-		case v < -zeroThreshold:
-			bucketCreated = addToBucket(&hc.nativeHistogramBucketsNegative, key, 1)
-		default:
-			atomic.AddUint64(&hc.nativeHistogramZeroBucket, 1)
+	}
+	atomicAddFloat(&hc.sumBits, sum)
+	// Increment count last as we take it as a signal that the observations
+	// are complete.
+	atomic.AddUint64(&hc.count, uint64(len(values)))
+}
+
+// observeSparse adds n to the sparse bucket (or the zero bucket) for v. NaN
+// observations are ignored.
+func (hc *histogramCounts) observeSparse(v float64, n uint64) {
+	if math.IsNaN(v) {
+		return
+	}
+	var (
+		key                  int
+		schema               = atomic.LoadInt32(&hc.nativeHistogramSchema)
+		zeroThreshold        = math.Float64frombits(atomic.LoadUint64(&hc.nativeHistogramZeroThresholdBits))
+		bucketCreated, isInf bool
+	)
+	if math.IsInf(v, 0) {
+		// Pretend v is MaxFloat64 but later increment key by one.
+		if math.IsInf(v, +1) {
+			v = math.MaxFloat64
+		} else {
+			v = -math.MaxFloat64
 		}
-		if bucketCreated {
-			atomic.AddUint32(&hc.nativeHistogramBucketsNumber, 1)
+		isInf = true
+	}
+	frac, exp := math.Frexp(math.Abs(v))
+	if schema > 0 {
+		bounds := nativeHistogramBounds[schema]
+		key = sort.SearchFloat64s(bounds, frac) + (exp-1)*len(bounds)
+	} else {
+		key = exp
+		if frac == 0.5 {
+			key--
 		}
+		div := 1 << -schema
+		key = (key + div - 1) / div
+	}
+	if isInf {
+		key++
+	}
+	switch {
+	case v > zeroThreshold:
+		bucketCreated = addToBucket(&hc.nativeHistogramBucketsPositive, key, int64(n))
+	case v < -zeroThreshold:
+		bucketCreated = addToBucket(&hc.nativeHistogramBucketsNegative, key, int64(n))
+	default:
+		atomic.AddUint64(&hc.nativeHistogramZeroBucket, n)
+	}
+	if bucketCreated {
+		atomic.AddUint32(&hc.nativeHistogramBucketsNumber, 1)
 	}
-	// Increment count last as we take it as a signal that the observation
-	// is complete.
-	atomic.AddUint64(&hc.count, 1)
 }
 
 type histogram struct {
@@ -701,7 +860,10 @@ type histogram struct {
 	nativeHistogramMaxZeroThreshold float64
 	nativeHistogramMaxBuckets       uint32
 	nativeHistogramMinResetDuration time.Duration
-	lastResetTime                   time.Time // Protected by mtx.
+	lastResetTime                   time.Time // Protected by mtx. Also the created timestamp.
+
+	window            *histogramWindow   // nil if no window is configured.
+	exemplarReservoir *exemplarReservoir // nil if no reservoir is configured.
 
 	now func() time.Time // To mock out time.Now() for testing.
 }
@@ -720,6 +882,39 @@ func (h *histogram) ObserveWithExemplar(v float64, e Labels) {
 	h.updateExemplar(v, i, e)
 }
 
+// ObserveN implements BatchObserver.
+func (h *histogram) ObserveN(v float64, n uint64) {
+	if n == 0 {
+		return
+	}
+	h.observeN(v, h.findBucket(v), n)
+}
+
+// ObserveMany implements BatchObserver. The regular buckets are searched for
+// all values first, and then the counts are updated in one go.
+func (h *histogram) ObserveMany(values []float64) {
+	if len(values) == 0 {
+		return
+	}
+	buckets := make([]int, len(values))
+	for i, v := range values {
+		buckets[i] = h.findBucket(v)
+	}
+	doSparse := h.nativeHistogramSchema > math.MinInt32
+	n := atomic.AddUint64(&h.countAndHotIdx, uint64(len(values)))
+	hotCounts := h.counts[n>>63]
+	hotCounts.observeMany(values, buckets, doSparse)
+	last := len(values) - 1
+	if doSparse && !math.IsNaN(values[last]) {
+		h.limitBuckets(hotCounts, values[last], buckets[last], 1)
+	}
+	if h.window != nil {
+		h.observeWindow(func(counts *histogramCounts) {
+			counts.observeMany(values, buckets, doSparse)
+		})
+	}
+}
+
 func (h *histogram) Write(out *dto.Metric) error {
 	// For simplicity, we protect this whole method by a mutex. It is not in
 	// the hot path, i.e. Observe is called much more often than Write. The
@@ -742,9 +937,10 @@ func (h *histogram) Write(out *dto.Metric) error {
 	waitForCooldown(count, coldCounts)
 
 	his := &dto.Histogram{
-		Bucket:      make([]*dto.Bucket, len(h.upperBounds)),
-		SampleCount: proto.Uint64(count),
-		SampleSum:   proto.Float64(math.Float64frombits(atomic.LoadUint64(&coldCounts.sumBits))),
+		Bucket:           make([]*dto.Bucket, len(h.upperBounds)),
+		SampleCount:      proto.Uint64(count),
+		SampleSum:        proto.Float64(math.Float64frombits(atomic.LoadUint64(&coldCounts.sumBits))),
+		CreatedTimestamp: createdTimestampProto(h.lastResetTime),
 	}
 	out.Histogram = his
 	out.Label = h.labelPairs
@@ -761,14 +957,21 @@ func (h *histogram) Write(out *dto.Metric) error {
 		}
 	}
 	// If there is an exemplar for the +Inf bucket, we have to add that bucket explicitly.
+	var infExemplar *dto.Exemplar
 	if e := h.exemplars[len(h.upperBounds)].Load(); e != nil {
+		infExemplar = e.(*dto.Exemplar)
+	}
+	if infExemplar != nil {
 		b := &dto.Bucket{
 			CumulativeCount: proto.Uint64(count),
 			UpperBound:      proto.Float64(math.Inf(1)),
-			Exemplar:        e.(*dto.Exemplar),
+			Exemplar:        infExemplar,
 		}
 		his.Bucket = append(his.Bucket, b)
 	}
+	if h.exemplarReservoir != nil {
+		his.Exemplars = h.exemplarReservoir.get()
+	}
 	if h.nativeHistogramSchema > math.MinInt32 {
 		his.ZeroThreshold = proto.Float64(math.Float64frombits(atomic.LoadUint64(&coldCounts.nativeHistogramZeroThresholdBits)))
 		his.Schema = proto.Int32(atomic.LoadInt32(&coldCounts.nativeHistogramSchema))
@@ -787,6 +990,345 @@ func (h *histogram) Write(out *dto.Metric) error {
 	return nil
 }
 
+// CreatedTimestamp implements CreatedTimestamper. A histogram is considered
+// created anew whenever it is reset by the bucket count limitation strategy
+// for native histograms.
+func (h *histogram) CreatedTimestamp() time.Time {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return h.lastResetTime
+}
+
+// Snapshot implements HistogramReader. It uses the same hot–cold swap as the
+// Write method to get a consistent view of the counts.
+func (h *histogram) Snapshot() HistogramSnapshot {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	n := atomic.AddUint64(&h.countAndHotIdx, 1<<63)
+	count := n & ((1 << 63) - 1)
+	hotCounts := h.counts[n>>63]
+	coldCounts := h.counts[(^n)>>63]
+
+	waitForCooldown(count, coldCounts)
+
+	s := HistogramSnapshot{
+		Count:       count,
+		Sum:         math.Float64frombits(atomic.LoadUint64(&coldCounts.sumBits)),
+		upperBounds: h.upperBounds,
+		buckets:     make([]uint64, len(h.upperBounds)+1),
+		schema:      h.nativeHistogramSchema,
+	}
+	if h.exemplarReservoir != nil {
+		s.Exemplars = h.exemplarReservoir.get()
+	}
+	var bucketCount uint64
+	for i := range h.upperBounds {
+		s.buckets[i] = atomic.LoadUint64(&coldCounts.buckets[i])
+		bucketCount += s.buckets[i]
+	}
+	s.buckets[len(h.upperBounds)] = count - bucketCount
+	if h.nativeHistogramSchema > math.MinInt32 {
+		s.schema = atomic.LoadInt32(&coldCounts.nativeHistogramSchema)
+		s.zeroThreshold = math.Float64frombits(atomic.LoadUint64(&coldCounts.nativeHistogramZeroThresholdBits))
+		s.zeroCount = atomic.LoadUint64(&coldCounts.nativeHistogramZeroBucket)
+		s.negative = readNativeBuckets(&coldCounts.nativeHistogramBucketsNegative)
+		s.positive = readNativeBuckets(&coldCounts.nativeHistogramBucketsPositive)
+
+		coldCounts.nativeHistogramBucketsPositive.Range(addAndReset(&hotCounts.nativeHistogramBucketsPositive, &hotCounts.nativeHistogramBucketsNumber))
+		coldCounts.nativeHistogramBucketsNegative.Range(addAndReset(&hotCounts.nativeHistogramBucketsNegative, &hotCounts.nativeHistogramBucketsNumber))
+	}
+	addAndResetCounts(hotCounts, coldCounts)
+	return s
+}
+
+// HistogramSnapshot is the state of a Histogram at a point in time, as returned
+// by HistogramReader.Snapshot.
+type HistogramSnapshot struct {
+	// Count and Sum are the count and the sum of all observations.
+	Count uint64
+	Sum   float64
+	// Exemplars are the exemplars in the exemplar reservoir (see the
+	// NativeHistogramMaxExemplars field in HistogramOpts), oldest first. It
+	// is nil if no reservoir is configured.
+	Exemplars []*dto.Exemplar
+
+	upperBounds []float64 // Upper bounds of the regular buckets, without +Inf.
+	buckets     []uint64  // Non-cumulative counts, including the +Inf bucket.
+
+	// Only used for native histograms, i.e. if schema > math.MinInt32.
+	schema             int32
+	zeroThreshold      float64
+	zeroCount          uint64
+	negative, positive []nativeBucket // Sorted by key.
+}
+
+// nativeBucket is a populated sparse bucket of a native histogram.
+type nativeBucket struct {
+	key   int
+	count int64
+}
+
+// Quantile returns an estimation of the q-quantile (0 ≤ q ≤ 1) of the
+// observations in the snapshot. If the histogram has native buckets, they are
+// used for the estimation (as they usually have a much higher resolution).
+// Otherwise, the regular buckets are used.
+//
+// The estimation follows the same approach as the histogram_quantile function
+// in PromQL: Observations are assumed to be evenly distributed within a
+// regular bucket, so that the result is linearly interpolated. Within a native
+// bucket, the result is interpolated exponentially, matching the exponential
+// bucket boundaries. If the quantile falls into the +Inf bucket of the regular
+// buckets, the upper bound of the highest regular bucket is returned.
+//
+// Quantile returns NaN if there are no observations or q is NaN, -Inf for
+// q < 0, and +Inf for q > 1.
+func (s HistogramSnapshot) Quantile(q float64) float64 {
+	switch {
+	case math.IsNaN(q):
+		return math.NaN()
+	case q < 0:
+		return math.Inf(-1)
+	case q > 1:
+		return math.Inf(+1)
+	}
+	if s.schema > math.MinInt32 {
+		return s.nativeQuantile(q)
+	}
+	return s.regularQuantile(q)
+}
+
+func (s HistogramSnapshot) regularQuantile(q float64) float64 {
+	if len(s.upperBounds) == 0 {
+		return math.NaN()
+	}
+	var total uint64
+	for _, c := range s.buckets {
+		total += c
+	}
+	if total == 0 {
+		return math.NaN()
+	}
+	var (
+		rank = q * float64(total)
+		cum  uint64
+	)
+	for i, c := range s.buckets {
+		cum += c
+		if c == 0 || float64(cum) < rank {
+			continue
+		}
+		if i == len(s.upperBounds) {
+			// The +Inf bucket.
+			return s.upperBounds[i-1]
+		}
+		lower, upper := 0., s.upperBounds[i]
+		if i > 0 {
+			lower = s.upperBounds[i-1]
+		} else if upper <= 0 {
+			// The lower bound of the first bucket is unknown.
+			return upper
+		}
+		return lower + (upper-lower)*(rank-float64(cum-c))/float64(c)
+	}
+	return s.upperBounds[len(s.upperBounds)-1] // Unreachable.
+}
+
+func (s HistogramSnapshot) nativeQuantile(q float64) float64 {
+	total := s.zeroCount
+	for _, b := range s.negative {
+		total += uint64(b.count)
+	}
+	for _, b := range s.positive {
+		total += uint64(b.count)
+	}
+	if total == 0 {
+		return math.NaN()
+	}
+	var (
+		rank = q * float64(total)
+		cum  uint64
+	)
+	// fraction returns the position of the rank within a bucket with the
+	// provided count, from 0 at its lower to 1 at its upper end.
+	fraction := func(count uint64) float64 {
+		return (rank - float64(cum-count)) / float64(count)
+	}
+	// Negative buckets in descending order of their key, i.e. ascending
+	// order of their boundaries.
+	for i := len(s.negative) - 1; i >= 0; i-- {
+		b := s.negative[i]
+		cum += uint64(b.count)
+		if b.count == 0 || float64(cum) < rank {
+			continue
+		}
+		absLower, absUpper := getLe(b.key-1, s.schema), getLe(b.key, s.schema)
+		if math.IsInf(absUpper, +1) {
+			return math.Inf(-1)
+		}
+		return -absUpper * math.Pow(absLower/absUpper, fraction(uint64(b.count)))
+	}
+	cum += s.zeroCount
+	if s.zeroCount > 0 && float64(cum) >= rank {
+		// Like PromQL, assume the zero bucket to only extend into the
+		// negative or positive range if there are negative or positive
+		// buckets, respectively.
+		lower, upper := -s.zeroThreshold, s.zeroThreshold
+		if len(s.negative) == 0 {
+			lower = 0
+		}
+		if len(s.positive) == 0 && len(s.negative) > 0 {
+			upper = 0
+		}
+		return lower + (upper-lower)*fraction(s.zeroCount)
+	}
+	for _, b := range s.positive {
+		cum += uint64(b.count)
+		if b.count == 0 || float64(cum) < rank {
+			continue
+		}
+		lower, upper := getLe(b.key-1, s.schema), getLe(b.key, s.schema)
+		if math.IsInf(upper, +1) {
+			return upper
+		}
+		return lower * math.Pow(upper/lower, fraction(uint64(b.count)))
+	}
+	return math.NaN() // Unreachable.
+}
+
+// readNativeBuckets returns the populated buckets in the provided sync.Maps of
+// sparse buckets, sorted by their key. Buckets with the same key in different
+// sync.Maps are added up.
+func readNativeBuckets(bucketMaps ...*sync.Map) []nativeBucket {
+	counts := map[int]int64{}
+	for _, buckets := range bucketMaps {
+		buckets.Range(func(k, v interface{}) bool {
+			if count := atomic.LoadInt64(v.(*int64)); count != 0 {
+				counts[k.(int)] += count
+			}
+			return true
+		})
+	}
+	result := make([]nativeBucket, 0, len(counts))
+	for key, count := range counts {
+		result = append(result, nativeBucket{key: key, count: count})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].key < result[j].key })
+	return result
+}
+
+// histogramWindow tracks the observations of a histogram within a sliding
+// window. The window is divided into age buckets, each of which is a
+// histogramCounts receiving the observations for a duration of bucketDuration.
+// The age buckets form a ring. Once the head bucket has expired, the oldest
+// bucket is reset and becomes the new head bucket.
+type histogramWindow struct {
+	// Observations into the head bucket only require mtx to be read
+	// locked, as the histogramCounts are updated atomically. Rotation and
+	// reading the window require mtx to be locked.
+	mtx            sync.RWMutex
+	buckets        []*histogramCounts
+	bucketDuration time.Duration
+	headIdx        int
+	headExpTime    time.Time
+}
+
+func (h *histogram) newWindow(maxAge time.Duration, ageBuckets uint32) *histogramWindow {
+	w := &histogramWindow{
+		buckets:        make([]*histogramCounts, ageBuckets),
+		bucketDuration: maxAge / time.Duration(ageBuckets),
+	}
+	for i := range w.buckets {
+		w.buckets[i] = &histogramCounts{buckets: make([]uint64, len(h.upperBounds))}
+		h.resetCounts(w.buckets[i])
+	}
+	w.headExpTime = h.now().Add(w.bucketDuration)
+	return w
+}
+
+// observeWindow adds observations to the head bucket of the window by calling
+// the provided function with it.
+func (h *histogram) observeWindow(observe func(*histogramCounts)) {
+	w := h.window
+	now := h.now()
+	w.mtx.RLock()
+	if !now.Before(w.headExpTime) {
+		w.mtx.RUnlock()
+		w.mtx.Lock()
+		h.maybeRotateWindow(now)
+		w.mtx.Unlock()
+		w.mtx.RLock()
+	}
+	observe(w.buckets[w.headIdx])
+	w.mtx.RUnlock()
+}
+
+// maybeRotateWindow resets expired age buckets of the window. It needs
+// h.window.mtx locked.
+func (h *histogram) maybeRotateWindow(now time.Time) {
+	w := h.window
+	for i := 0; !now.Before(w.headExpTime); i++ {
+		if i == len(w.buckets) {
+			// All buckets have expired. No need to iterate through
+			// all the durations that have passed.
+			w.headExpTime = now.Add(w.bucketDuration)
+			return
+		}
+		w.headIdx = (w.headIdx + 1) % len(w.buckets)
+		h.resetCounts(w.buckets[w.headIdx])
+		w.headExpTime = w.headExpTime.Add(w.bucketDuration)
+	}
+}
+
+// WindowSnapshot implements HistogramReader.
+func (h *histogram) WindowSnapshot() HistogramSnapshot {
+	if h.window == nil {
+		return h.Snapshot()
+	}
+	w := h.window
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	h.maybeRotateWindow(h.now())
+
+	s := HistogramSnapshot{
+		upperBounds:   h.upperBounds,
+		buckets:       make([]uint64, len(h.upperBounds)+1),
+		schema:        h.nativeHistogramSchema,
+		zeroThreshold: h.nativeHistogramZeroThreshold,
+	}
+	var (
+		bucketCount        uint64
+		negative, positive []*sync.Map
+	)
+	for _, counts := range w.buckets {
+		s.Count += atomic.LoadUint64(&counts.count)
+		s.Sum += math.Float64frombits(atomic.LoadUint64(&counts.sumBits))
+		for i := range h.upperBounds {
+			c := atomic.LoadUint64(&counts.buckets[i])
+			s.buckets[i] += c
+			bucketCount += c
+		}
+		s.zeroCount += atomic.LoadUint64(&counts.nativeHistogramZeroBucket)
+		negative = append(negative, &counts.nativeHistogramBucketsNegative)
+		positive = append(positive, &counts.nativeHistogramBucketsPositive)
+	}
+	s.buckets[len(h.upperBounds)] = s.Count - bucketCount
+	if h.nativeHistogramSchema > math.MinInt32 {
+		s.negative = readNativeBuckets(negative...)
+		s.positive = readNativeBuckets(positive...)
+	}
+	if h.exemplarReservoir != nil {
+		// Only include exemplars within the window.
+		windowStart := w.headExpTime.Add(-w.bucketDuration * time.Duration(len(w.buckets)))
+		for _, e := range h.exemplarReservoir.get() {
+			if !e.GetTimestamp().AsTime().Before(windowStart) {
+				s.Exemplars = append(s.Exemplars, e)
+			}
+		}
+	}
+	return s
+}
+
 // findBucket returns the index of the bucket for the provided value, or
 // len(h.upperBounds) for the +Inf bucket.
 func (h *histogram) findBucket(v float64) int {
@@ -804,16 +1346,26 @@ func (h *histogram) findBucket(v float64) int {
 
 // observe is the implementation for Observe without the findBucket part.
 func (h *histogram) observe(v float64, bucket int) {
+	h.observeN(v, bucket, 1)
+}
+
+// observeN works like observe but adds the observation n times.
+func (h *histogram) observeN(v float64, bucket int, count uint64) {
 	// Do not add to sparse buckets for NaN observations.
 	doSparse := h.nativeHistogramSchema > math.MinInt32 && !math.IsNaN(v)
 	// We increment h.countAndHotIdx so that the counter in the lower
 	// 63 bits gets incremented. At the same time, we get the new value
 	// back, which we can use to find the currently-hot counts.
-	n := atomic.AddUint64(&h.countAndHotIdx, 1)
+	n := atomic.AddUint64(&h.countAndHotIdx, count)
 	hotCounts := h.counts[n>>63]
-	hotCounts.observe(v, bucket, doSparse)
+	hotCounts.observeN(v, bucket, doSparse, count)
 	if doSparse {
-		h.limitBuckets(hotCounts, v, bucket)
+		h.limitBuckets(hotCounts, v, bucket, count)
+	}
+	if h.window != nil {
+		h.observeWindow(func(counts *histogramCounts) {
+			counts.observeN(v, bucket, doSparse, count)
+		})
 	}
 }
 
@@ -822,7 +1374,10 @@ func (h *histogram) observe(v float64, bucket int) {
 // number can go higher (if even the lowest resolution isn't enough to reduce
 // the number sufficiently, or if the provided counts aren't fully updated yet
 // by a concurrently happening Write call).
-func (h *histogram) limitBuckets(counts *histogramCounts, value float64, bucket int) {
+//
+// value and bucket describe the latest observation, which is repeated count
+// times if the histogram gets reset.
+func (h *histogram) limitBuckets(counts *histogramCounts, value float64, bucket int, count uint64) {
 	if h.nativeHistogramMaxBuckets == 0 {
 		return // No limit configured.
 	}
@@ -845,7 +1400,7 @@ func (h *histogram) limitBuckets(counts *histogramCounts, value float64, bucket
 		return // Bucket limit not exceeded after all.
 	}
 	// Try the various strategies in order.
-	if h.maybeReset(hotCounts, coldCounts, coldIdx, value, bucket) {
+	if h.maybeReset(hotCounts, coldCounts, coldIdx, value, bucket, count) {
 		return
 	}
 	if h.maybeWidenZeroBucket(hotCounts, coldCounts) {
@@ -857,7 +1412,7 @@ func (h *histogram) limitBuckets(counts *histogramCounts, value float64, bucket
 // maybeReset resests the whole histogram if at least h.nativeHistogramMinResetDuration
 // has been passed. It returns true if the histogram has been reset. The caller
 // must have locked h.mtx.
-func (h *histogram) maybeReset(hot, cold *histogramCounts, coldIdx uint64, value float64, bucket int) bool {
+func (h *histogram) maybeReset(hot, cold *histogramCounts, coldIdx uint64, value float64, bucket int, repeat uint64) bool {
 	// We are using the possibly mocked h.now() rather than
 	// time.Since(h.lastResetTime) to enable testing.
 	if h.nativeHistogramMinResetDuration == 0 || h.now().Sub(h.lastResetTime) < h.nativeHistogramMinResetDuration {
@@ -866,9 +1421,9 @@ func (h *histogram) maybeReset(hot, cold *histogramCounts, coldIdx uint64, value
 	// Completely reset coldCounts.
 	h.resetCounts(cold)
 	// Repeat the latest observation to not lose it completely.
-	cold.observe(value, bucket, true)
+	cold.observeN(value, bucket, true, repeat)
 	// Make coldCounts the new hot counts while ressetting countAndHotIdx.
-	n := atomic.SwapUint64(&h.countAndHotIdx, (coldIdx<<63)+1)
+	n := atomic.SwapUint64(&h.countAndHotIdx, (coldIdx<<63)+repeat)
 	count := n & ((1 << 63) - 1)
 	waitForCooldown(count, hot)
 	// Finally, reset the formerly hot counts, too.
@@ -982,10 +1537,7 @@ func (h *histogram) doubleBucketWidth(hot, cold *histogramCounts) {
 			key := k.(int)
 			bucket := v.(*int64)
 			// Adjust key to match the bucket to merge into.
-			if key > 0 {
-				key++
-			}
-			key /= 2
+			key = downscaleKey(key, 1)
 			// Add to corresponding hot bucket.
 			if addToBucket(hotBuckets, key, atomic.LoadInt64(bucket)) {
 				atomic.AddUint32(&hot.nativeHistogramBucketsNumber, 1)
@@ -1027,6 +1579,55 @@ func (h *histogram) updateExemplar(v float64, bucket int, l Labels) {
 		panic(err)
 	}
 	h.exemplars[bucket].Store(e)
+	if h.exemplarReservoir != nil {
+		h.exemplarReservoir.add(e)
+	}
+}
+
+// exemplarReservoir keeps the exemplars of a histogram according to an
+// ExemplarStrategy.
+type exemplarReservoir struct {
+	maxExemplars int
+	strategy     ExemplarStrategy
+	window       time.Duration
+
+	mtx         sync.Mutex
+	exemplars   []*dto.Exemplar // Oldest first.
+	windowStart time.Time       // Start of the current window for ExemplarsMaxValue.
+}
+
+func (r *exemplarReservoir) add(e *dto.Exemplar) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.strategy == ExemplarsMaxValue {
+		ts := e.GetTimestamp().AsTime()
+		if n := len(r.exemplars); n > 0 && ts.Sub(r.windowStart) < r.window {
+			if e.GetValue() > r.exemplars[n-1].GetValue() {
+				r.exemplars[n-1] = e
+			}
+			return
+		}
+		r.windowStart = ts
+	}
+	if len(r.exemplars) == r.maxExemplars {
+		copy(r.exemplars, r.exemplars[1:])
+		r.exemplars = r.exemplars[:len(r.exemplars)-1]
+	}
+	r.exemplars = append(r.exemplars, e)
+}
+
+// get returns a copy of the kept exemplars, oldest first.
+func (r *exemplarReservoir) get() []*dto.Exemplar {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if len(r.exemplars) == 0 {
+		return nil
+	}
+	result := make([]*dto.Exemplar, len(r.exemplars))
+	copy(result, r.exemplars)
+	return result
 }
 
 // HistogramVec is a Collector that bundles a set of Histograms that all share the
@@ -1049,17 +1650,19 @@ func NewHistogramVec(opts HistogramOpts, labelNames []string) *HistogramVec {
 
 // NewHistogramVec creates a new HistogramVec based on the provided HistogramVecOpts.
 func (v2) NewHistogramVec(opts HistogramVecOpts) *HistogramVec {
-	desc := V2.NewDesc(
+	desc := V2.NewDescWithUnit(
 		BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
 		opts.Help,
+		opts.Unit,
 		opts.VariableLabels,
 		opts.ConstLabels,
 	)
-	return &HistogramVec{
-		MetricVec: NewMetricVec(desc, func(lvs ...string) Metric {
-			return newHistogram(desc, opts.HistogramOpts, lvs...)
-		}),
-	}
+	vec := NewMetricVec(desc, func(lvs ...string) Metric {
+		return newHistogram(desc, opts.HistogramOpts, lvs...)
+	})
+	vec.SetCardinalityLimit(opts.CardinalityLimit)
+	vec.SetTTL(opts.TTL)
+	return &HistogramVec{vec}
 }
 
 // GetMetricWithLabelValues returns the Histogram for the given slice of label
@@ -1176,12 +1779,18 @@ type constHistogram struct {
 	sum        float64
 	buckets    map[float64]uint64
 	labelPairs []*dto.LabelPair
+	createdTs  time.Time
 }
 
 func (h *constHistogram) Desc() *Desc {
 	return h.desc
 }
 
+// CreatedTimestamp implements CreatedTimestamper.
+func (h *constHistogram) CreatedTimestamp() time.Time {
+	return h.createdTs
+}
+
 func (h *constHistogram) Write(out *dto.Metric) error {
 	his := &dto.Histogram{}
 
@@ -1189,6 +1798,7 @@ func (h *constHistogram) Write(out *dto.Metric) error {
 
 	his.SampleCount = proto.Uint64(h.count)
 	his.SampleSum = proto.Float64(h.sum)
+	his.CreatedTimestamp = createdTimestampProto(h.createdTs)
 	for upperBound, count := range h.buckets {
 		buckets = append(buckets, &dto.Bucket{
 			CumulativeCount: proto.Uint64(count),
@@ -1258,6 +1868,162 @@ func MustNewConstHistogram(
 	return m
 }
 
+// NewConstHistogramWithCreatedTimestamp does the same thing as
+// NewConstHistogram, but the returned Metric also implements
+// CreatedTimestamper, returning the provided created timestamp ct.
+func NewConstHistogramWithCreatedTimestamp(
+	desc *Desc,
+	count uint64,
+	sum float64,
+	buckets map[float64]uint64,
+	ct time.Time,
+	labelValues ...string,
+) (Metric, error) {
+	m, err := NewConstHistogram(desc, count, sum, buckets, labelValues...)
+	if err != nil {
+		return nil, err
+	}
+	m.(*constHistogram).createdTs = ct
+	return m, nil
+}
+
+// MustNewConstHistogramWithCreatedTimestamp is a version of
+// NewConstHistogramWithCreatedTimestamp that panics where
+// NewConstHistogramWithCreatedTimestamp would have returned an error.
+func MustNewConstHistogramWithCreatedTimestamp(
+	desc *Desc,
+	count uint64,
+	sum float64,
+	buckets map[float64]uint64,
+	ct time.Time,
+	labelValues ...string,
+) Metric {
+	m, err := NewConstHistogramWithCreatedTimestamp(desc, count, sum, buckets, ct, labelValues...)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+type constNativeHistogram struct {
+	desc       *Desc
+	histogram  *dto.Histogram
+	labelPairs []*dto.LabelPair
+}
+
+func (h *constNativeHistogram) Desc() *Desc {
+	return h.desc
+}
+
+func (h *constNativeHistogram) Write(out *dto.Metric) error {
+	out.Histogram = h.histogram
+	out.Label = h.labelPairs
+	return nil
+}
+
+// NewConstNativeHistogram returns a metric representing a Prometheus native
+// histogram with fixed values for the count, sum, and bucket counts. It is the
+// native histogram equivalent of NewConstHistogram and is useful to re-expose
+// exponential histograms from other systems in custom Collectors.
+//
+// positiveBuckets and negativeBuckets map bucket indices to the
+// (non-cumulative) counts of the buckets for positive and negative values,
+// respectively. The index i of a positive bucket denotes the bucket with the
+// upper bound (2^(2^-schema))^i (inclusive) and the lower bound
+// (2^(2^-schema))^(i-1) (exclusive). Negative buckets are mirrored
+// accordingly. Empty buckets may be omitted. zeroBucket is the count of
+// observations with an absolute value less than or equal to zeroThreshold.
+//
+// NewConstNativeHistogram returns an error if the length of labelValues is not
+// consistent with the variable labels in Desc, if Desc is invalid, if schema is
+// not between -4 and 8, if zeroThreshold is negative or NaN, or if count is
+// less than the sum of all bucket counts (it may be more to account for NaN
+// observations, which are not counted in any bucket).
+func NewConstNativeHistogram(
+	desc *Desc,
+	count uint64,
+	sum float64,
+	positiveBuckets, negativeBuckets map[int]uint64,
+	zeroBucket uint64,
+	schema int32,
+	zeroThreshold float64,
+	labelValues ...string,
+) (Metric, error) {
+	if desc.err != nil {
+		return nil, desc.err
+	}
+	if err := validateLabelValues(labelValues, len(desc.variableLabels)); err != nil {
+		return nil, err
+	}
+	if schema < -4 || schema > 8 {
+		return nil, fmt.Errorf("invalid native histogram schema %d, must be between -4 and 8", schema)
+	}
+	if !(zeroThreshold >= 0) {
+		return nil, fmt.Errorf("invalid native histogram zero threshold %g", zeroThreshold)
+	}
+	bucketCount := zeroBucket
+	for _, c := range positiveBuckets {
+		bucketCount += c
+	}
+	for _, c := range negativeBuckets {
+		bucketCount += c
+	}
+	if count < bucketCount {
+		return nil, fmt.Errorf(
+			"native histogram count %d is less than the sum of its bucket counts %d",
+			count, bucketCount,
+		)
+	}
+
+	his := &dto.Histogram{
+		SampleCount:   proto.Uint64(count),
+		SampleSum:     proto.Float64(sum),
+		Schema:        proto.Int32(schema),
+		ZeroThreshold: proto.Float64(zeroThreshold),
+		ZeroCount:     proto.Uint64(zeroBucket),
+	}
+	his.PositiveSpan, his.PositiveDelta = makeBucketsFromMap(positiveBuckets)
+	his.NegativeSpan, his.NegativeDelta = makeBucketsFromMap(negativeBuckets)
+	return &constNativeHistogram{
+		desc:       desc,
+		histogram:  his,
+		labelPairs: MakeLabelPairs(desc, labelValues),
+	}, nil
+}
+
+// MustNewConstNativeHistogram is a version of NewConstNativeHistogram that
+// panics where NewConstNativeHistogram would have returned an error.
+func MustNewConstNativeHistogram(
+	desc *Desc,
+	count uint64,
+	sum float64,
+	positiveBuckets, negativeBuckets map[int]uint64,
+	zeroBucket uint64,
+	schema int32,
+	zeroThreshold float64,
+	labelValues ...string,
+) Metric {
+	m, err := NewConstNativeHistogram(
+		desc, count, sum, positiveBuckets, negativeBuckets,
+		zeroBucket, schema, zeroThreshold, labelValues...,
+	)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// makeBucketsFromMap works like makeBuckets but for a map of bucket indices to
+// counts.
+func makeBucketsFromMap(buckets map[int]uint64) ([]*dto.BucketSpan, []int64) {
+	ii := make([]int, 0, len(buckets))
+	for i := range buckets {
+		ii = append(ii, i)
+	}
+	sort.Ints(ii)
+	return makeSpansAndDeltas(ii, func(i int) int64 { return int64(buckets[i]) })
+}
+
 type buckSort []*dto.Bucket
 
 func (s buckSort) Len() int {
@@ -1301,6 +2067,16 @@ func makeBuckets(buckets *sync.Map) ([]*dto.BucketSpan, []int64) {
 	})
 	sort.Ints(ii)
 
+	return makeSpansAndDeltas(ii, func(i int) int64 {
+		v, _ := buckets.Load(i)
+		return atomic.LoadInt64(v.(*int64))
+	})
+}
+
+// makeSpansAndDeltas returns the spans and deltas of the native histogram
+// buckets with the provided sorted indices. The count of each bucket is
+// returned by the provided count function.
+func makeSpansAndDeltas(ii []int, count func(i int) int64) ([]*dto.BucketSpan, []int64) {
 	if len(ii) == 0 {
 		return nil, nil
 	}
@@ -1319,8 +2095,6 @@ func makeBuckets(buckets *sync.Map) ([]*dto.BucketSpan, []int64) {
 	}
 
 	for n, i := range ii {
-		v, _ := buckets.Load(i)
-		count := atomic.LoadInt64(v.(*int64))
 		// Multiple spans with only small gaps in between are probably
 		// encoded more efficiently as one larger span with a few empty
 		// buckets. Needs some research to find the sweet spot. For now,
@@ -1342,7 +2116,7 @@ func makeBuckets(buckets *sync.Map) ([]*dto.BucketSpan, []int64) {
 				appendDelta(0)
 			}
 		}
-		appendDelta(count)
+		appendDelta(count(i))
 		nextI = i + 1
 	}
 	return spans, deltas
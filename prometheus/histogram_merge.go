@@ -0,0 +1,376 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// MergeHistograms returns a histogram data transmission object containing the
+// sum of the provided histograms, as written by the Write method of a
+// Histogram. This is useful to merge histograms that have been tracked
+// separately (e.g. by the shards of a batch job) into one histogram, which can
+// be exposed with NewConstHistogramFromDTO. The provided histograms are not
+// modified.
+//
+// The regular buckets of all histograms must have the same upper bounds. If
+// the histograms have native buckets, all of them must have native buckets.
+// Native histograms with different schemas are merged at the lowest of the
+// schemas, with the buckets of the other histograms merged into wider buckets
+// (the same way a Histogram reduces its resolution when it exceeds
+// NativeHistogramMaxBucketNumber). Similarly, the merged histogram has the
+// largest of the zero thresholds, and buckets of the other histograms that
+// fall within it are merged into the zero bucket. If a bucket straddles the
+// largest zero threshold, the zero threshold is raised to the upper bound of
+// that bucket.
+//
+// Of the exemplars of the regular buckets, the one with the latest timestamp
+// is kept. The merged histogram has the earliest of the created timestamps.
+// Histograms with float counts are not supported.
+func MergeHistograms(histograms ...*dto.Histogram) (*dto.Histogram, error) {
+	if len(histograms) == 0 {
+		return nil, errors.New("no histograms to merge")
+	}
+	result, err := newHistogramDTOs(histograms...)
+	if err != nil {
+		return nil, err
+	}
+	for i, h := range result.histograms {
+		result.count += h.GetSampleCount()
+		result.sum += h.GetSampleSum()
+		if ct := h.GetCreatedTimestamp(); ct != nil {
+			if old := result.createdTs; old == nil || ct.AsTime().Before(old.AsTime()) {
+				result.createdTs = ct
+			}
+		}
+		for j, b := range h.GetBucket() {
+			result.buckets[j] += b.GetCumulativeCount()
+			if e := b.GetExemplar(); e != nil {
+				if old := result.exemplars[j]; old == nil || !e.GetTimestamp().AsTime().Before(old.GetTimestamp().AsTime()) {
+					result.exemplars[j] = e
+				}
+			}
+		}
+		if result.native {
+			result.zeroCount += int64(result.zeroCounts[i])
+			for k, c := range result.positive[i] {
+				result.positiveSum[k] += c
+			}
+			for k, c := range result.negative[i] {
+				result.negativeSum[k] += c
+			}
+		}
+	}
+	return result.toDTO(), nil
+}
+
+// SubtractHistograms returns a histogram data transmission object containing
+// the difference of the provided histograms (minuend minus subtrahend), e.g.
+// the increase of a Histogram between two of its snapshots. The requirements
+// for the provided histograms and the treatment of native histograms with
+// different schemas or zero thresholds are the same as for MergeHistograms.
+// The exemplars of the minuend are kept.
+//
+// SubtractHistograms returns an error if any count of the subtrahend is larger
+// than the corresponding count of the minuend, which usually means that the
+// histogram has been reset in between.
+func SubtractHistograms(minuend, subtrahend *dto.Histogram) (*dto.Histogram, error) {
+	result, err := newHistogramDTOs(minuend, subtrahend)
+	if err != nil {
+		return nil, err
+	}
+	if minuend.GetSampleCount() < subtrahend.GetSampleCount() {
+		return nil, fmt.Errorf(
+			"sample count of subtrahend (%d) is larger than that of the minuend (%d)",
+			subtrahend.GetSampleCount(), minuend.GetSampleCount(),
+		)
+	}
+	result.count = minuend.GetSampleCount() - subtrahend.GetSampleCount()
+	result.sum = minuend.GetSampleSum() - subtrahend.GetSampleSum()
+	for j, b := range minuend.GetBucket() {
+		m, s := b.GetCumulativeCount(), subtrahend.GetBucket()[j].GetCumulativeCount()
+		if m < s {
+			return nil, fmt.Errorf(
+				"count of bucket %g of subtrahend (%d) is larger than that of the minuend (%d)",
+				b.GetUpperBound(), s, m,
+			)
+		}
+		result.buckets[j] = m - s
+		result.exemplars[j] = b.GetExemplar()
+	}
+	if result.native {
+		result.zeroCount = int64(result.zeroCounts[0]) - int64(result.zeroCounts[1])
+		subtract := func(dst, minuend, subtrahend map[int]int64) {
+			for k, c := range minuend {
+				dst[k] += c
+			}
+			for k, c := range subtrahend {
+				dst[k] -= c
+			}
+		}
+		subtract(result.positiveSum, result.positive[0], result.positive[1])
+		subtract(result.negativeSum, result.negative[0], result.negative[1])
+		if result.zeroCount < 0 {
+			return nil, errors.New("zero bucket count of subtrahend is larger than that of the minuend")
+		}
+		for _, buckets := range []map[int]int64{result.positiveSum, result.negativeSum} {
+			for k, c := range buckets {
+				if c < 0 {
+					return nil, fmt.Errorf("count of native bucket %d of subtrahend is larger than that of the minuend", k)
+				}
+			}
+		}
+	}
+	return result.toDTO(), nil
+}
+
+// histogramDTOs holds a set of histograms to merge or subtract, with their
+// native buckets already converted to a common schema and zero threshold, and
+// the fields for the result.
+type histogramDTOs struct {
+	histograms []*dto.Histogram
+	native     bool
+
+	// The native buckets of each histogram at the common schema and zero
+	// threshold.
+	zeroCounts         []uint64
+	positive, negative []map[int]int64
+
+	// The result.
+	count                    uint64
+	sum                      float64
+	buckets                  []uint64 // Cumulative.
+	exemplars                []*dto.Exemplar
+	createdTs                *timestamppb.Timestamp
+	schema                   int32
+	zeroThreshold            float64
+	zeroCount                int64
+	positiveSum, negativeSum map[int]int64
+}
+
+func newHistogramDTOs(histograms ...*dto.Histogram) (*histogramDTOs, error) {
+	r := &histogramDTOs{histograms: histograms}
+	first := histograms[0]
+	for i, h := range histograms {
+		if h == nil {
+			return nil, fmt.Errorf("histogram %d is nil", i)
+		}
+		if h.SampleCountFloat != nil || h.ZeroCountFloat != nil || len(h.PositiveCount) > 0 || len(h.NegativeCount) > 0 {
+			return nil, fmt.Errorf("histogram %d has float counts, which are not supported", i)
+		}
+		if len(h.GetBucket()) != len(first.GetBucket()) {
+			return nil, fmt.Errorf("histogram %d has %d buckets, but histogram 0 has %d", i, len(h.GetBucket()), len(first.GetBucket()))
+		}
+		for j, b := range h.GetBucket() {
+			if b.CumulativeCountFloat != nil {
+				return nil, fmt.Errorf("histogram %d has float counts, which are not supported", i)
+			}
+			if b.GetUpperBound() != first.GetBucket()[j].GetUpperBound() {
+				return nil, fmt.Errorf(
+					"bucket %d of histogram %d has upper bound %g, but that of histogram 0 has %g",
+					j, i, b.GetUpperBound(), first.GetBucket()[j].GetUpperBound(),
+				)
+			}
+		}
+		if (h.Schema != nil) != (first.Schema != nil) {
+			return nil, errors.New("cannot combine native histograms with histograms without native buckets")
+		}
+	}
+	r.buckets = make([]uint64, len(first.GetBucket()))
+	r.exemplars = make([]*dto.Exemplar, len(first.GetBucket()))
+	if first.Schema == nil {
+		return r, nil
+	}
+
+	r.native = true
+	r.schema = first.GetSchema()
+	for _, h := range histograms {
+		if h.GetSchema() < r.schema {
+			r.schema = h.GetSchema()
+		}
+		if h.GetZeroThreshold() > r.zeroThreshold {
+			r.zeroThreshold = h.GetZeroThreshold()
+		}
+	}
+	if r.schema < -4 || r.schema > 8 {
+		return nil, fmt.Errorf("invalid native histogram schema %d", r.schema)
+	}
+	for i, h := range histograms {
+		if h.GetSchema() > 8 {
+			return nil, fmt.Errorf("invalid native histogram schema %d of histogram %d", h.GetSchema(), i)
+		}
+		positive, err := nativeBucketsFromDTO(h.GetPositiveSpan(), h.GetPositiveDelta(), h.GetSchema()-r.schema)
+		if err != nil {
+			return nil, fmt.Errorf("positive buckets of histogram %d: %w", i, err)
+		}
+		negative, err := nativeBucketsFromDTO(h.GetNegativeSpan(), h.GetNegativeDelta(), h.GetSchema()-r.schema)
+		if err != nil {
+			return nil, fmt.Errorf("negative buckets of histogram %d: %w", i, err)
+		}
+		r.zeroCounts = append(r.zeroCounts, h.GetZeroCount())
+		r.positive = append(r.positive, positive)
+		r.negative = append(r.negative, negative)
+	}
+
+	// Raise the zero threshold until no bucket straddles it.
+	for raised := true; raised; {
+		raised = false
+		for _, buckets := range append(r.positive, r.negative...) {
+			for k := range buckets {
+				lowerBound, upperBound := getLe(k-1, r.schema), getLe(k, r.schema)
+				if lowerBound < r.zeroThreshold && upperBound > r.zeroThreshold {
+					r.zeroThreshold = upperBound
+					raised = true
+				}
+			}
+		}
+	}
+	// Move all buckets within the zero threshold into the zero bucket.
+	for i := range histograms {
+		for _, buckets := range []map[int]int64{r.positive[i], r.negative[i]} {
+			for k, c := range buckets {
+				if getLe(k, r.schema) <= r.zeroThreshold {
+					r.zeroCounts[i] += uint64(c)
+					delete(buckets, k)
+				}
+			}
+		}
+	}
+	r.positiveSum = map[int]int64{}
+	r.negativeSum = map[int]int64{}
+	return r, nil
+}
+
+// toDTO returns the result as a histogram data transmission object.
+func (r *histogramDTOs) toDTO() *dto.Histogram {
+	his := &dto.Histogram{
+		SampleCount:      proto.Uint64(r.count),
+		SampleSum:        proto.Float64(r.sum),
+		CreatedTimestamp: r.createdTs,
+	}
+	for j, b := range r.histograms[0].GetBucket() {
+		his.Bucket = append(his.Bucket, &dto.Bucket{
+			CumulativeCount: proto.Uint64(r.buckets[j]),
+			UpperBound:      proto.Float64(b.GetUpperBound()),
+			Exemplar:        r.exemplars[j],
+		})
+	}
+	if !r.native {
+		return his
+	}
+	his.Schema = proto.Int32(r.schema)
+	his.ZeroThreshold = proto.Float64(r.zeroThreshold)
+	his.ZeroCount = proto.Uint64(uint64(r.zeroCount))
+	his.PositiveSpan, his.PositiveDelta = makeBucketsFromMap(toUint64Counts(r.positiveSum))
+	his.NegativeSpan, his.NegativeDelta = makeBucketsFromMap(toUint64Counts(r.negativeSum))
+	return his
+}
+
+// nativeBucketsFromDTO decodes the provided spans and deltas into a map of
+// bucket keys to counts, with the keys downscaled by the provided number of
+// schema steps.
+func nativeBucketsFromDTO(spans []*dto.BucketSpan, deltas []int64, downscale int32) (map[int]int64, error) {
+	var (
+		buckets = map[int]int64{}
+		key     int
+		count   int64
+		n       int
+	)
+	for i, span := range spans {
+		if i == 0 {
+			key = int(span.GetOffset())
+		} else {
+			key += int(span.GetOffset())
+		}
+		for j := uint32(0); j < span.GetLength(); j++ {
+			if n >= len(deltas) {
+				return nil, errors.New("spans are longer than the deltas")
+			}
+			count += deltas[n]
+			if count < 0 {
+				return nil, fmt.Errorf("negative bucket count %d", count)
+			}
+			buckets[downscaleKey(key, downscale)] += count
+			key++
+			n++
+		}
+	}
+	if n != len(deltas) {
+		return nil, errors.New("deltas are longer than the spans")
+	}
+	return buckets, nil
+}
+
+// downscaleKey returns the key of the native bucket the bucket with the
+// provided key merges into if the schema is reduced by the provided number of
+// steps.
+func downscaleKey(key int, steps int32) int {
+	for ; steps > 0; steps-- {
+		if key > 0 {
+			key++
+		}
+		key /= 2
+	}
+	return key
+}
+
+func toUint64Counts(buckets map[int]int64) map[int]uint64 {
+	result := make(map[int]uint64, len(buckets))
+	for k, c := range buckets {
+		if c != 0 {
+			result[k] = uint64(c)
+		}
+	}
+	return result
+}
+
+// NewConstHistogramFromDTO returns a metric representing a Prometheus histogram
+// with the fixed values of the provided histogram data transmission object,
+// e.g. as returned by MergeHistograms or SubtractHistograms. The provided
+// histogram must not be modified afterwards.
+//
+// NewConstHistogramFromDTO returns an error if the length of labelValues is not
+// consistent with the variable labels in Desc, if Desc is invalid, or if the
+// provided histogram is nil.
+func NewConstHistogramFromDTO(desc *Desc, histogram *dto.Histogram, labelValues ...string) (Metric, error) {
+	if desc.err != nil {
+		return nil, desc.err
+	}
+	if err := validateLabelValues(labelValues, len(desc.variableLabels)); err != nil {
+		return nil, err
+	}
+	if histogram == nil {
+		return nil, errors.New("histogram is nil")
+	}
+	return &constNativeHistogram{
+		desc:       desc,
+		histogram:  histogram,
+		labelPairs: MakeLabelPairs(desc, labelValues),
+	}, nil
+}
+
+// MustNewConstHistogramFromDTO is a version of NewConstHistogramFromDTO that
+// panics where NewConstHistogramFromDTO would have returned an error.
+func MustNewConstHistogramFromDTO(desc *Desc, histogram *dto.Histogram, labelValues ...string) Metric {
+	m, err := NewConstHistogramFromDTO(desc, histogram, labelValues...)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
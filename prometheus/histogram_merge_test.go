@@ -0,0 +1,196 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func writeHistogram(t *testing.T, h Histogram) *dto.Histogram {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := h.(Metric).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	// Exemplars and created timestamps are not relevant here.
+	for _, b := range m.Histogram.Bucket {
+		b.Exemplar = nil
+	}
+	m.Histogram.CreatedTimestamp = nil
+	return m.Histogram
+}
+
+func TestMergeHistograms(t *testing.T) {
+	buckets := []float64{-1, 0, 1, 5}
+	observations := []float64{-3, -0.5, 0, 0.0001, 0.3, 1, 2, 4, 7, 100}
+
+	scenarios := []struct {
+		name              string
+		factors           []float64
+		zeroThresholds    []float64
+		wantFactor        float64
+		wantZeroThreshold float64
+	}{
+		{
+			name:              "same schema",
+			factors:           []float64{1.1, 1.1, 1.1},
+			zeroThresholds:    []float64{0.001, 0.001, 0.001},
+			wantFactor:        1.1,
+			wantZeroThreshold: 0.001,
+		},
+		{
+			name:              "different schemas",
+			factors:           []float64{1.1, 2, 1.5},
+			zeroThresholds:    []float64{0.001, 0.001, 0.001},
+			wantFactor:        2,
+			wantZeroThreshold: 0.001,
+		},
+		{
+			name:              "different zero thresholds",
+			factors:           []float64{2, 2, 2},
+			zeroThresholds:    []float64{0.001, 0.25, 0.001},
+			wantFactor:        2,
+			wantZeroThreshold: 0.25,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			want := NewHistogram(HistogramOpts{
+				Name:                         "want",
+				Buckets:                      buckets,
+				NativeHistogramBucketFactor:  s.wantFactor,
+				NativeHistogramZeroThreshold: s.wantZeroThreshold,
+			})
+			var shards []*dto.Histogram
+			for i, factor := range s.factors {
+				shard := NewHistogram(HistogramOpts{
+					Name:                         "shard",
+					Buckets:                      buckets,
+					NativeHistogramBucketFactor:  factor,
+					NativeHistogramZeroThreshold: s.zeroThresholds[i],
+				})
+				for j, o := range observations {
+					if j%len(s.factors) == i {
+						shard.Observe(o)
+						want.Observe(o)
+					}
+				}
+				shards = append(shards, writeHistogram(t, shard))
+			}
+
+			got, err := MergeHistograms(shards...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != writeHistogram(t, want).String() {
+				t.Errorf("got %s, want %s", got, writeHistogram(t, want))
+			}
+		})
+	}
+}
+
+func TestSubtractHistograms(t *testing.T) {
+	h := NewHistogram(HistogramOpts{
+		Name:                        "test",
+		Buckets:                     []float64{1, 5},
+		NativeHistogramBucketFactor: 1.1,
+	})
+	delta := NewHistogram(HistogramOpts{
+		Name:                        "delta",
+		Buckets:                     []float64{1, 5},
+		NativeHistogramBucketFactor: 1.1,
+	})
+	for _, o := range []float64{-2, 0, 0.5, 3, 10} {
+		h.Observe(o)
+	}
+	before := writeHistogram(t, h)
+	for _, o := range []float64{-2, 0.5, 0.75, 3, 7} {
+		h.Observe(o)
+		delta.Observe(o)
+	}
+	after := writeHistogram(t, h)
+
+	got, err := SubtractHistograms(after, before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := writeHistogram(t, delta); got.String() != want.String() {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if _, err := SubtractHistograms(before, after); err == nil {
+		t.Error("expected error when subtracting a larger histogram")
+	}
+}
+
+func TestMergeHistogramsErrors(t *testing.T) {
+	classic := func(bounds ...float64) *dto.Histogram {
+		h := NewHistogram(HistogramOpts{Name: "test", Buckets: bounds})
+		h.Observe(1)
+		return writeHistogram(t, h)
+	}
+	native := writeHistogram(t, NewHistogram(HistogramOpts{
+		Name:                        "test",
+		Buckets:                     []float64{1, 2},
+		NativeHistogramBucketFactor: 1.1,
+	}))
+
+	if _, err := MergeHistograms(); err == nil {
+		t.Error("expected error for no histograms")
+	}
+	if _, err := MergeHistograms(classic(1, 2), classic(1, 3)); err == nil {
+		t.Error("expected error for different bucket boundaries")
+	}
+	if _, err := MergeHistograms(classic(1, 2), classic(1)); err == nil {
+		t.Error("expected error for different number of buckets")
+	}
+	if _, err := MergeHistograms(classic(1, 2), native); err == nil {
+		t.Error("expected error for mixing native and classic histograms")
+	}
+	if _, err := MergeHistograms(classic(1, 2), nil); err == nil {
+		t.Error("expected error for nil histogram")
+	}
+}
+
+func TestNewConstHistogramFromDTO(t *testing.T) {
+	h := NewHistogram(HistogramOpts{Name: "test", Buckets: []float64{1, 2}})
+	h.Observe(1.5)
+	merged, err := MergeHistograms(writeHistogram(t, h), writeHistogram(t, h))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc := NewDesc("merged", "help", []string{"shard"}, nil)
+	m := MustNewConstHistogramFromDTO(desc, merged, "all")
+	out := &dto.Metric{}
+	if err := m.Write(out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.GetHistogram().GetSampleCount(), uint64(2); got != want {
+		t.Errorf("got sample count %d, want %d", got, want)
+	}
+	if got, want := out.GetLabel()[0].GetValue(), "all"; got != want {
+		t.Errorf("got label value %q, want %q", got, want)
+	}
+
+	if _, err := NewConstHistogramFromDTO(desc, merged); err == nil {
+		t.Error("expected error for missing label value")
+	}
+	if _, err := NewConstHistogramFromDTO(desc, nil, "all"); err == nil {
+		t.Error("expected error for nil histogram")
+	}
+}
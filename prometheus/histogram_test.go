@@ -28,6 +28,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/internal"
 
 	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
@@ -676,9 +677,14 @@ func TestNativeHistogram(t *testing.T) {
 			if err := his.Write(m); err != nil {
 				t.Fatal("unexpected error writing metric", err)
 			}
-			got := m.Histogram.String()
-			if s.want != got {
-				t.Errorf("want histogram %q, got %q", s.want, got)
+			// The created timestamp is not relevant here.
+			m.Histogram.CreatedTimestamp = nil
+			want := &dto.Histogram{}
+			if err := prototext.Unmarshal([]byte(s.want), want); err != nil {
+				t.Fatal("unexpected error parsing wanted histogram", err)
+			}
+			if !proto.Equal(want, m.Histogram) {
+				t.Errorf("want histogram %q, got %q", want, m.Histogram)
 			}
 		})
 	}
@@ -872,3 +878,368 @@ func TestGetLe(t *testing.T) {
 		}
 	}
 }
+
+func TestHistogramSnapshot(t *testing.T) {
+	scenarios := []struct {
+		name         string
+		opts         HistogramOpts
+		observations []float64
+		quantiles    map[float64]float64
+		tolerance    float64 // Relative.
+	}{
+		{
+			name:         "no observations",
+			opts:         HistogramOpts{Buckets: []float64{1, 2}},
+			observations: nil,
+			quantiles:    map[float64]float64{0.5: math.NaN()},
+		},
+		{
+			name:         "regular buckets",
+			opts:         HistogramOpts{Buckets: LinearBuckets(10, 10, 10)},
+			observations: []float64{5, 15, 15, 25, 25, 25, 25, 35, 200, 200},
+			quantiles: map[float64]float64{
+				-1:   math.Inf(-1),
+				0.1:  10,
+				0.2:  15,
+				0.5:  25,
+				0.7:  30,
+				0.99: 100, // +Inf bucket.
+				2:    math.Inf(+1),
+			},
+		},
+		{
+			name:         "negative first bucket",
+			opts:         HistogramOpts{Buckets: []float64{-1, 1}},
+			observations: []float64{-5, -5, 0.5},
+			quantiles:    map[float64]float64{0.5: -1, 0.9: 0.4},
+			tolerance:    1e-9,
+		},
+		{
+			name:         "native buckets",
+			opts:         HistogramOpts{NativeHistogramBucketFactor: 1.1},
+			observations: linearObservations(1, 1000),
+			quantiles: map[float64]float64{
+				0.5:  500,
+				0.9:  900,
+				0.99: 990,
+			},
+			tolerance: 0.05,
+		},
+		{
+			name:         "native buckets with negative and zero observations",
+			opts:         HistogramOpts{NativeHistogramBucketFactor: 1.1, NativeHistogramZeroThreshold: 1},
+			observations: []float64{-100, -100, 0, 0.5, 100, 100},
+			quantiles: map[float64]float64{
+				0.2: -100,
+				0.5: 0,
+				0.8: 100,
+			},
+			tolerance: 0.05,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			s.opts.Name = "name"
+			s.opts.Help = "help"
+			his := NewHistogram(s.opts)
+			var sum float64
+			for _, o := range s.observations {
+				his.Observe(o)
+				sum += o
+			}
+			snapshot := his.(HistogramReader).Snapshot()
+			if got, want := snapshot.Count, uint64(len(s.observations)); got != want {
+				t.Errorf("got count %d, want %d", got, want)
+			}
+			if got, want := snapshot.Sum, sum; got != want {
+				t.Errorf("got sum %v, want %v", got, want)
+			}
+			for q, want := range s.quantiles {
+				got := snapshot.Quantile(q)
+				switch {
+				case math.IsNaN(want):
+					if !math.IsNaN(got) {
+						t.Errorf("q=%v: got %v, want NaN", q, got)
+					}
+				case math.IsInf(want, 0) || want == 0:
+					if got != want {
+						t.Errorf("q=%v: got %v, want %v", q, got, want)
+					}
+				case math.Abs(got-want) > math.Abs(want)*s.tolerance:
+					t.Errorf("q=%v: got %v, want %v (tolerance %v)", q, got, want, s.tolerance)
+				}
+			}
+
+			// Taking a snapshot must not affect the histogram itself.
+			m := &dto.Metric{}
+			if err := his.Write(m); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := m.GetHistogram().GetSampleCount(), uint64(len(s.observations)); got != want {
+				t.Errorf("got sample count %d after snapshot, want %d", got, want)
+			}
+			if got, want := his.(HistogramReader).Snapshot(), snapshot; !reflect.DeepEqual(got, want) {
+				t.Errorf("got snapshot %+v after Write, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func linearObservations(from, to int) []float64 {
+	var result []float64
+	for i := from; i <= to; i++ {
+		result = append(result, float64(i))
+	}
+	return result
+}
+
+func TestHistogramWindow(t *testing.T) {
+	now := time.Now()
+	his := NewHistogram(HistogramOpts{
+		Name:                        "name",
+		Help:                        "help",
+		Buckets:                     []float64{1, 10, 100},
+		NativeHistogramBucketFactor: 1.1,
+		WindowMaxAge:                time.Minute,
+		WindowAgeBuckets:            3,
+	}).(*histogram)
+	his.now = func() time.Time { return now }
+	his.window.headExpTime = now.Add(his.window.bucketDuration)
+
+	expect := func(wantCount uint64, wantSum float64) {
+		t.Helper()
+		s := his.WindowSnapshot()
+		if s.Count != wantCount || s.Sum != wantSum {
+			t.Errorf("got count %d and sum %v, want %d and %v", s.Count, s.Sum, wantCount, wantSum)
+		}
+	}
+
+	his.Observe(1)
+	now = now.Add(10 * time.Second)
+	his.Observe(10)
+	expect(2, 11)
+
+	now = now.Add(15 * time.Second) // Head bucket rotated once.
+	his.Observe(100)
+	expect(3, 111)
+
+	now = now.Add(20 * time.Second) // Second rotation, nothing expired yet.
+	expect(3, 111)
+
+	now = now.Add(20 * time.Second) // Third rotation, first bucket expired.
+	expect(1, 100)
+	if got, want := his.WindowSnapshot().Quantile(0.5), 100.; math.Abs(got-want) > 0.05*want {
+		t.Errorf("got quantile %v, want approx. %v", got, want)
+	}
+
+	// The exposed histogram is still cumulative.
+	m := &dto.Metric{}
+	if err := his.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.GetHistogram().GetSampleCount(), uint64(3); got != want {
+		t.Errorf("got sample count %d, want %d", got, want)
+	}
+	if got, want := his.Snapshot().Count, uint64(3); got != want {
+		t.Errorf("got snapshot count %d, want %d", got, want)
+	}
+
+	now = now.Add(10 * time.Minute) // Everything expired.
+	expect(0, 0)
+	if got := his.WindowSnapshot().Quantile(0.5); !math.IsNaN(got) {
+		t.Errorf("got quantile %v, want NaN", got)
+	}
+	his.Observe(5)
+	expect(1, 5)
+}
+
+func TestHistogramExemplarReservoir(t *testing.T) {
+	exemplarValues := func(exemplars []*dto.Exemplar) []float64 {
+		var result []float64
+		for _, e := range exemplars {
+			result = append(result, e.GetValue())
+		}
+		return result
+	}
+
+	scenarios := []struct {
+		name         string
+		strategy     ExemplarStrategy
+		maxExemplars int
+		observations []float64
+		interval     time.Duration
+		want         []float64
+	}{
+		{
+			name:         "latest",
+			strategy:     ExemplarsLatest,
+			maxExemplars: 3,
+			observations: []float64{1, 5, 3, 2, 4},
+			interval:     time.Second,
+			want:         []float64{3, 2, 4},
+		},
+		{
+			name:         "max value within one window",
+			strategy:     ExemplarsMaxValue,
+			maxExemplars: 3,
+			observations: []float64{1, 5, 3},
+			interval:     10 * time.Second,
+			want:         []float64{5},
+		},
+		{
+			name:         "max value across windows",
+			strategy:     ExemplarsMaxValue,
+			maxExemplars: 2,
+			observations: []float64{1, 5, 3, 2, 9, 4, 1, 7},
+			interval:     25 * time.Second, // Windows start with observations 1, 2, 1.
+			want:         []float64{9, 7},
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			now := time.Now()
+			his := NewHistogram(HistogramOpts{
+				Name:                            "name",
+				Help:                            "help",
+				NativeHistogramBucketFactor:     1.1,
+				NativeHistogramMaxExemplars:     s.maxExemplars,
+				NativeHistogramExemplarStrategy: s.strategy,
+				NativeHistogramExemplarWindow:   time.Minute,
+			}).(*histogram)
+			his.now = func() time.Time { return now }
+
+			for _, o := range s.observations {
+				his.ObserveWithExemplar(o, Labels{"trace_id": "abc"})
+				now = now.Add(s.interval)
+			}
+
+			if got := exemplarValues(his.Snapshot().Exemplars); !reflect.DeepEqual(got, s.want) {
+				t.Errorf("got exemplars %v, want %v", got, s.want)
+			}
+
+			// All kept exemplars are exposed, while the +Inf bucket
+			// keeps the most recent exemplar.
+			m := &dto.Metric{}
+			if err := his.Write(m); err != nil {
+				t.Fatal(err)
+			}
+			if got := exemplarValues(m.GetHistogram().GetExemplars()); !reflect.DeepEqual(got, s.want) {
+				t.Errorf("got exposed exemplars %v, want %v", got, s.want)
+			}
+			buckets := m.GetHistogram().GetBucket()
+			if len(buckets) != 1 {
+				t.Fatalf("got %d buckets, want 1", len(buckets))
+			}
+			if got, want := buckets[0].GetExemplar().GetValue(), s.observations[len(s.observations)-1]; got != want {
+				t.Errorf("got exemplar value %v in +Inf bucket, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNewConstNativeHistogram(t *testing.T) {
+	his := NewHistogram(HistogramOpts{
+		Name:                        "test",
+		Help:                        "test help",
+		NativeHistogramBucketFactor: 1.1,
+	}).(*histogram)
+	for _, v := range []float64{-5, -4.9, 0, 1e-40, 1, 1.5, 3, 3, 3.3, 100, 1000} {
+		his.Observe(v)
+	}
+	want := &dto.Metric{}
+	if err := his.Write(want); err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-create the histogram from its snapshot.
+	s := his.Snapshot()
+	toMap := func(buckets []nativeBucket) map[int]uint64 {
+		m := map[int]uint64{}
+		for _, b := range buckets {
+			m[b.key] = uint64(b.count)
+		}
+		return m
+	}
+	m, err := NewConstNativeHistogram(
+		his.Desc(), s.Count, s.Sum, toMap(s.positive), toMap(s.negative),
+		s.zeroCount, s.schema, s.zeroThreshold,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &dto.Metric{}
+	if err := m.Write(got); err != nil {
+		t.Fatal(err)
+	}
+	want.Histogram.Bucket = nil
+	want.Histogram.CreatedTimestamp = nil
+	if got.String() != want.String() {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	desc := NewDesc("test", "test help", []string{"a"}, nil)
+	for name, f := range map[string]func() (Metric, error){
+		"invalid schema": func() (Metric, error) {
+			return NewConstNativeHistogram(desc, 1, 1, map[int]uint64{1: 1}, nil, 0, 9, 0, "a")
+		},
+		"negative zero threshold": func() (Metric, error) {
+			return NewConstNativeHistogram(desc, 1, 1, nil, nil, 1, 0, -1, "a")
+		},
+		"NaN zero threshold": func() (Metric, error) {
+			return NewConstNativeHistogram(desc, 1, 1, nil, nil, 1, 0, math.NaN(), "a")
+		},
+		"count too low": func() (Metric, error) {
+			return NewConstNativeHistogram(desc, 2, 1, map[int]uint64{1: 1}, map[int]uint64{1: 1}, 1, 0, 0, "a")
+		},
+		"missing label value": func() (Metric, error) {
+			return NewConstNativeHistogram(desc, 1, 1, map[int]uint64{1: 1}, nil, 0, 0, 0)
+		},
+	} {
+		if _, err := f(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestHistogramObserveBatch(t *testing.T) {
+	values := []float64{-2, 0, 0.25, 0.5, 1, 3, 3, 7.5, 100, math.Inf(1), math.NaN()}
+	newHistogram := func() *histogram {
+		return NewHistogram(HistogramOpts{
+			Name:                        "name",
+			Help:                        "help",
+			Buckets:                     []float64{0, 1, 5},
+			NativeHistogramBucketFactor: 1.1,
+			WindowMaxAge:                time.Minute,
+		}).(*histogram)
+	}
+	want, many, n := newHistogram(), newHistogram(), newHistogram()
+	for _, v := range values {
+		want.Observe(v)
+		for i := 0; i < 3; i++ {
+			want.Observe(v)
+		}
+		n.ObserveN(v, 3)
+	}
+	many.ObserveMany(values)
+	many.ObserveMany(nil)
+	many.ObserveMany(append(append(append([]float64{}, values...), values...), values...))
+	n.ObserveN(1, 0)
+	for _, v := range values {
+		n.Observe(v)
+	}
+
+	for name, got := range map[string]*histogram{"ObserveMany": many, "ObserveN": n} {
+		// The sum is NaN due to the NaN observation, so compare everything
+		// else.
+		wantH, gotH := writeHistogram(t, want), writeHistogram(t, got)
+		wantH.SampleSum, gotH.SampleSum = nil, nil
+		if gotH.String() != wantH.String() {
+			t.Errorf("%s: got %s, want %s", name, gotH, wantH)
+		}
+		if got, want := got.WindowSnapshot().Count, want.WindowSnapshot().Count; got != want {
+			t.Errorf("%s: got window count %d, want %d", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Info is a Metric that exposes textual information about the monitored
+// target, like build information or version numbers, as labels of a series
+// with the constant value 1. This is the Info type of OpenMetrics.
+//
+// The information is provided by the ConstLabels in InfoOpts or, in case of
+// an InfoVec, by the label values of the children. Info metrics are gathered
+// as gauges, which is how the Prometheus text and protobuf formats represent
+// them. Registry implements TypeProvider to report their actual type, so that
+// the promhttp package exposes them with the info type in the OpenMetrics text
+// format, where the name of the metric family lacks the "_info" suffix of the
+// samples.
+//
+// To create Info instances, use NewInfo.
+type Info interface {
+	Metric
+	Collector
+}
+
+// InfoOpts is an alias for Opts. See there for doc comments.
+//
+// Note that the fully-qualified name of an Info metric must end with "_info",
+// and Unit must not be set.
+type InfoOpts Opts
+
+// InfoVecOpts bundles the options to create an InfoVec metric.
+// It is mandatory to set InfoOpts, see there for mandatory fields. VariableLabels
+// is optional and can safely be left to its default value.
+type InfoVecOpts struct {
+	InfoOpts
+
+	// VariableLabels are used to partition the metric vector by the given set
+	// of labels. Each label value will be constrained with the optional Contraint
+	// function, if provided.
+	VariableLabels ConstrainableLabels
+}
+
+// NewInfo creates a new Info based on the provided InfoOpts. The information
+// is provided by opts.ConstLabels.
+func NewInfo(opts InfoOpts) Info {
+	desc := newInfoDesc(opts, UnconstrainedLabels(nil))
+	result := &info{desc: desc, labelPairs: desc.constLabelPairs}
+	result.init(result) // Init self-collection.
+	return result
+}
+
+// newInfoDesc creates the Desc for an Info or InfoVec and records an error in
+// it if the name or the unit is not valid for an Info metric.
+func newInfoDesc(opts InfoOpts, variableLabels ConstrainableLabels) *Desc {
+	fqName := BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+	desc := V2.NewDesc(fqName, opts.Help, variableLabels, opts.ConstLabels)
+	desc.openMetricsType = "info"
+	if desc.err != nil {
+		return desc
+	}
+	switch {
+	case !strings.HasSuffix(fqName, "_info"):
+		desc.err = fmt.Errorf("name %q of info metric does not end with \"_info\"", fqName)
+	case opts.Unit != "":
+		desc.err = fmt.Errorf("info metric %q must not have a unit", fqName)
+	}
+	return desc
+}
+
+type info struct {
+	selfCollector
+
+	desc       *Desc
+	labelPairs []*dto.LabelPair
+}
+
+func (i *info) Desc() *Desc {
+	return i.desc
+}
+
+func (i *info) Write(out *dto.Metric) error {
+	return populateMetric(GaugeValue, 1, i.labelPairs, nil, time.Time{}, out)
+}
+
+// InfoVec is a Collector that bundles a set of Infos that all share the same
+// Desc, but have different values for their variable labels. This is used if
+// the information is only known at runtime (e.g. the version of each connected
+// database server). Create instances with NewInfoVec.
+type InfoVec struct {
+	*MetricVec
+}
+
+// NewInfoVec creates a new InfoVec based on the provided InfoOpts and
+// partitioned by the given label names.
+func NewInfoVec(opts InfoOpts, labelNames []string) *InfoVec {
+	return V2.NewInfoVec(InfoVecOpts{
+		InfoOpts:       opts,
+		VariableLabels: UnconstrainedLabels(labelNames),
+	})
+}
+
+// NewInfoVec creates a new InfoVec based on the provided InfoVecOpts.
+func (v2) NewInfoVec(opts InfoVecOpts) *InfoVec {
+	desc := newInfoDesc(opts.InfoOpts, opts.VariableLabels)
+	return &InfoVec{
+		MetricVec: NewMetricVec(desc, func(lvs ...string) Metric {
+			if len(lvs) != len(desc.variableLabels) {
+				panic(makeInconsistentCardinalityError(desc.fqName, desc.variableLabels.labelNames(), lvs))
+			}
+			result := &info{desc: desc, labelPairs: MakeLabelPairs(desc, lvs)}
+			result.init(result) // Init self-collection.
+			return result
+		}),
+	}
+}
+
+// GetMetricWithLabelValues returns the Info for the given slice of label
+// values (same order as the variable labels in Desc). If that combination of
+// label values is accessed for the first time, a new Info is created.
+//
+// As an Info has no methods to change it, calling this method (or
+// WithLabelValues) is all that is needed to expose the information.
+//
+// An error is returned if the number of label values is not the same as the
+// number of variable labels in Desc (minus any curried labels).
+func (v *InfoVec) GetMetricWithLabelValues(lvs ...string) (Info, error) {
+	metric, err := v.MetricVec.GetMetricWithLabelValues(lvs...)
+	if metric != nil {
+		return metric.(Info), err
+	}
+	return nil, err
+}
+
+// GetMetricWith returns the Info for the given Labels map (the label names
+// must match those of the variable labels in Desc). If that label map is
+// accessed for the first time, a new Info is created.
+//
+// An error is returned if the number and names of the Labels are inconsistent
+// with those of the variable labels in Desc (minus any curried labels).
+func (v *InfoVec) GetMetricWith(labels Labels) (Info, error) {
+	metric, err := v.MetricVec.GetMetricWith(labels)
+	if metric != nil {
+		return metric.(Info), err
+	}
+	return nil, err
+}
+
+// WithLabelValues works as GetMetricWithLabelValues, but panics where
+// GetMetricWithLabelValues would have returned an error. Not returning an
+// error allows shortcuts like
+//
+//	myVec.WithLabelValues("v1.2.3", "go1.20")
+func (v *InfoVec) WithLabelValues(lvs ...string) Info {
+	i, err := v.GetMetricWithLabelValues(lvs...)
+	if err != nil {
+		panic(err)
+	}
+	return i
+}
+
+// With works as GetMetricWith, but panics where GetMetricWithLabels would have
+// returned an error. Not returning an error allows shortcuts like
+//
+//	myVec.With(prometheus.Labels{"version": "v1.2.3", "goversion": "go1.20"})
+func (v *InfoVec) With(labels Labels) Info {
+	i, err := v.GetMetricWith(labels)
+	if err != nil {
+		panic(err)
+	}
+	return i
+}
+
+// CurryWith returns a vector curried with the provided labels, i.e. the
+// returned vector has those labels pre-set for all labeled operations performed
+// on it. See GaugeVec.CurryWith for details.
+func (v *InfoVec) CurryWith(labels Labels) (*InfoVec, error) {
+	vec, err := v.MetricVec.CurryWith(labels)
+	if vec != nil {
+		return &InfoVec{vec}, err
+	}
+	return nil, err
+}
+
+// MustCurryWith works as CurryWith but panics where CurryWith would have
+// returned an error.
+func (v *InfoVec) MustCurryWith(labels Labels) *InfoVec {
+	vec, err := v.CurryWith(labels)
+	if err != nil {
+		panic(err)
+	}
+	return vec
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// gatherAsText gathers from g and encodes the result in the provided text
+// format.
+func gatherAsText(t *testing.T, g Gatherer, format expfmt.Format) string {
+	t.Helper()
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, format)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.String()
+}
+
+func TestInfo(t *testing.T) {
+	reg := NewPedanticRegistry()
+	reg.MustRegister(NewInfo(InfoOpts{
+		Name:        "build_info",
+		Help:        "Build information.",
+		ConstLabels: Labels{"version": "v1.2.3"},
+	}))
+	vec := NewInfoVec(InfoOpts{
+		Name: "backend_info",
+		Help: "Backend information.",
+	}, []string{"backend", "version"})
+	reg.MustRegister(vec)
+	vec.WithLabelValues("a", "v1")
+	vec.With(Labels{"backend": "b", "version": "v2"})
+
+	if got, want := gatherAsText(t, reg, expfmt.FmtText), `# HELP backend_info Backend information.
+# TYPE backend_info gauge
+backend_info{backend="a",version="v1"} 1
+backend_info{backend="b",version="v2"} 1
+# HELP build_info Build information.
+# TYPE build_info gauge
+build_info{version="v1.2.3"} 1
+`; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	// The info type is only known via the registry, see the promhttp
+	// package for the exposition in the OpenMetrics text format.
+	if got, want := reg.OpenMetricsTypes(), map[string]string{"backend_info": "info", "build_info": "info"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got OpenMetrics types %v, want %v", got, want)
+	}
+}
+
+func TestInfoValidation(t *testing.T) {
+	for _, opts := range []InfoOpts{
+		{Name: "build", Help: "Missing suffix."},
+		{Name: "size_bytes_info", Help: "Unit.", Unit: "bytes"},
+	} {
+		if err := NewRegistry().Register(NewInfo(opts)); err == nil {
+			t.Errorf("registering info metric with %+v succeeded unexpectedly", opts)
+		}
+	}
+}
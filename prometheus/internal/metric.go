@@ -99,3 +99,22 @@ func NormalizeMetricFamilies(metricFamiliesByName map[string]*dto.MetricFamily)
 	}
 	return result
 }
+
+// GaugeHistogramAsHistogram returns the provided MetricFamily unchanged unless
+// it is a gauge histogram. In that case, it returns a shallow copy with the
+// type set to histogram. This is needed for the text formats of the expfmt
+// package, which don't support gauge histograms. The classic Prometheus text
+// format has no representation for them anyway, and exposing them as regular
+// histograms results in the same series the Prometheus server creates when
+// ingesting gauge histograms via the protobuf format.
+func GaugeHistogramAsHistogram(mf *dto.MetricFamily) *dto.MetricFamily {
+	if mf.GetType() != dto.MetricType_GAUGE_HISTOGRAM {
+		return mf
+	}
+	return &dto.MetricFamily{
+		Name:   mf.Name,
+		Help:   mf.Help,
+		Type:   dto.MetricType_HISTOGRAM.Enum(),
+		Metric: mf.Metric,
+	}
+}
@@ -81,6 +81,14 @@ type Opts struct {
 	// string.
 	Help string
 
+	// Unit is the unit of this metric, e.g. "seconds" or "bytes". It is
+	// optional. If set, the fully-qualified name must end with the unit
+	// (in case of a counter followed by "_total"), as required by
+	// OpenMetrics. The unit is exposed in the OpenMetrics text format.
+	//
+	// Metrics with the same fully-qualified name must have the same Unit.
+	Unit string
+
 	// ConstLabels are used to attach fixed labels to this metric. Metrics
 	// with the same fully-qualified name must have the same label names in
 	// their ConstLabels.
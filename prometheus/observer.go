@@ -62,3 +62,14 @@ type ObserverVec interface {
 type ExemplarObserver interface {
 	ObserveWithExemplar(value float64, exemplar Labels)
 }
+
+// BatchObserver is implemented by Observers that offer the option of adding
+// many observations at once, which is considerably cheaper than calling Observe
+// for each of them (e.g. when replaying logs or processing batches). The
+// Histograms and Summaries created by this package implement it.
+type BatchObserver interface {
+	// ObserveMany adds each of the provided values as an observation.
+	ObserveMany(values []float64)
+	// ObserveN adds the provided value n times as an observation.
+	ObserveN(value float64, n uint64)
+}
@@ -243,6 +243,51 @@ func NewUntypedFunc(opts prometheus.UntypedOpts, function func() float64) promet
 	return With(prometheus.DefaultRegisterer).NewUntypedFunc(opts, function)
 }
 
+// NewInfo works like the function of the same name in the prometheus package
+// but it automatically registers the Info with the
+// prometheus.DefaultRegisterer. If the registration fails, NewInfo panics.
+func NewInfo(opts prometheus.InfoOpts) prometheus.Info {
+	return With(prometheus.DefaultRegisterer).NewInfo(opts)
+}
+
+// NewInfoVec works like the function of the same name in the prometheus
+// package but it automatically registers the InfoVec with the
+// prometheus.DefaultRegisterer. If the registration fails, NewInfoVec panics.
+func NewInfoVec(opts prometheus.InfoOpts, labelNames []string) *prometheus.InfoVec {
+	return With(prometheus.DefaultRegisterer).NewInfoVec(opts, labelNames)
+}
+
+// NewStateSet works like the function of the same name in the prometheus
+// package but it automatically registers the StateSet with the
+// prometheus.DefaultRegisterer. If the registration fails, NewStateSet panics.
+func NewStateSet(opts prometheus.StateSetOpts) prometheus.StateSet {
+	return With(prometheus.DefaultRegisterer).NewStateSet(opts)
+}
+
+// NewStateSetVec works like the function of the same name in the prometheus
+// package but it automatically registers the StateSetVec with the
+// prometheus.DefaultRegisterer. If the registration fails, NewStateSetVec
+// panics.
+func NewStateSetVec(opts prometheus.StateSetOpts, labelNames []string) *prometheus.StateSetVec {
+	return With(prometheus.DefaultRegisterer).NewStateSetVec(opts, labelNames)
+}
+
+// NewGaugeHistogram works like the function of the same name in the prometheus
+// package but it automatically registers the GaugeHistogram with the
+// prometheus.DefaultRegisterer. If the registration fails, NewGaugeHistogram
+// panics.
+func NewGaugeHistogram(opts prometheus.GaugeHistogramOpts) prometheus.GaugeHistogram {
+	return With(prometheus.DefaultRegisterer).NewGaugeHistogram(opts)
+}
+
+// NewGaugeHistogramVec works like the function of the same name in the
+// prometheus package but it automatically registers the GaugeHistogramVec with
+// the prometheus.DefaultRegisterer. If the registration fails,
+// NewGaugeHistogramVec panics.
+func NewGaugeHistogramVec(opts prometheus.GaugeHistogramOpts, labelNames []string) *prometheus.GaugeHistogramVec {
+	return With(prometheus.DefaultRegisterer).NewGaugeHistogramVec(opts, labelNames)
+}
+
 // Factory provides factory methods to create Collectors that are automatically
 // registered with a Registerer. Create a Factory with the With function,
 // providing a Registerer to auto-register created Collectors with. The zero
@@ -374,3 +419,68 @@ func (f Factory) NewUntypedFunc(opts prometheus.UntypedOpts, function func() flo
 	}
 	return u
 }
+
+// NewInfo works like the function of the same name in the prometheus package
+// but it automatically registers the Info with the Factory's Registerer.
+func (f Factory) NewInfo(opts prometheus.InfoOpts) prometheus.Info {
+	i := prometheus.NewInfo(opts)
+	if f.r != nil {
+		f.r.MustRegister(i)
+	}
+	return i
+}
+
+// NewInfoVec works like the function of the same name in the prometheus
+// package but it automatically registers the InfoVec with the Factory's
+// Registerer.
+func (f Factory) NewInfoVec(opts prometheus.InfoOpts, labelNames []string) *prometheus.InfoVec {
+	i := prometheus.NewInfoVec(opts, labelNames)
+	if f.r != nil {
+		f.r.MustRegister(i)
+	}
+	return i
+}
+
+// NewStateSet works like the function of the same name in the prometheus
+// package but it automatically registers the StateSet with the Factory's
+// Registerer.
+func (f Factory) NewStateSet(opts prometheus.StateSetOpts) prometheus.StateSet {
+	s := prometheus.NewStateSet(opts)
+	if f.r != nil {
+		f.r.MustRegister(s)
+	}
+	return s
+}
+
+// NewStateSetVec works like the function of the same name in the prometheus
+// package but it automatically registers the StateSetVec with the Factory's
+// Registerer.
+func (f Factory) NewStateSetVec(opts prometheus.StateSetOpts, labelNames []string) *prometheus.StateSetVec {
+	s := prometheus.NewStateSetVec(opts, labelNames)
+	if f.r != nil {
+		f.r.MustRegister(s)
+	}
+	return s
+}
+
+// NewGaugeHistogram works like the function of the same name in the prometheus
+// package but it automatically registers the GaugeHistogram with the Factory's
+// Registerer.
+func (f Factory) NewGaugeHistogram(opts prometheus.GaugeHistogramOpts) prometheus.GaugeHistogram {
+	h := prometheus.NewGaugeHistogram(opts)
+	if f.r != nil {
+		f.r.MustRegister(h)
+	}
+	return h
+}
+
+// NewGaugeHistogramVec works like the function of the same name in the
+// prometheus package but it automatically registers the GaugeHistogramVec with
+// the Factory's Registerer.
+func (f Factory) NewGaugeHistogramVec(opts prometheus.GaugeHistogramOpts, labelNames []string) *prometheus.GaugeHistogramVec {
+	h := prometheus.NewGaugeHistogramVec(opts, labelNames)
+	if f.r != nil {
+		f.r.MustRegister(h)
+	}
+	return h
+}
@@ -0,0 +1,187 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promhttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// responseCache caches complete responses of a handler, see
+// HandlerOpts.CacheDuration.
+type responseCache struct {
+	duration time.Duration
+	errorLog Logger // May be nil.
+
+	mtx     sync.Mutex
+	entries map[string]*cachedResponse
+
+	now func() time.Time // To mock out time.Now() for testing.
+}
+
+// cachedResponse is a complete response. All fields but done are only set
+// right before done is closed.
+type cachedResponse struct {
+	done       chan struct{}
+	generation uint64 // Set upon creation.
+	expires    time.Time
+	ok         bool // If false, the response must not be reused.
+	status     int
+	header     http.Header
+	body       []byte
+}
+
+func newResponseCache(duration time.Duration, errorLog Logger) *responseCache {
+	return &responseCache{
+		duration: duration,
+		errorLog: errorLog,
+		entries:  map[string]*cachedResponse{},
+		now:      time.Now,
+	}
+}
+
+// cacheKey returns the key identifying the responses to the provided request
+// that are interchangeable, i.e. those with the same negotiated format and
+// encoding and (if filtering is enabled) the same filter parameters.
+func cacheKey(req *http.Request, opts HandlerOpts, compressorPools []*compressorPool) string {
+	var format expfmt.Format
+	if opts.EnableOpenMetrics {
+		format = expfmt.NegotiateIncludingOpenMetrics(req.Header)
+	} else {
+		format = expfmt.Negotiate(req.Header)
+	}
+	key := string(format) + "\n"
+	if p := negotiateCompression(req.Header, compressorPools); p != nil {
+		key += string(p.encoding)
+	}
+	if opts.EnableFiltering {
+		query := req.URL.Query()
+		key += "\n" + url.Values{nameParam: query[nameParam], matchParam: query[matchParam]}.Encode()
+	}
+	return key
+}
+
+// serve responds to the provided request with a cached response for the
+// provided key if there is one that has not expired yet and was created for the
+// provided generation of the gatherer. If such a response is still being
+// created, serve waits for it (or returns without a response if the request is
+// canceled in the meantime, as the client has gone away). Otherwise, serve
+// calls render to create a new response, which is cached if render returns true
+// and the status code is 200.
+func (c *responseCache) serve(
+	rsp http.ResponseWriter, req *http.Request, key string, generation uint64,
+	render func(http.ResponseWriter, *http.Request) bool,
+) {
+	c.mtx.Lock()
+	if e, ok := c.entries[key]; ok && e.generation == generation && !c.expired(e) {
+		c.mtx.Unlock()
+		select {
+		case <-e.done:
+		case <-req.Context().Done():
+			return
+		}
+		if e.ok {
+			c.writeTo(rsp, e)
+			return
+		}
+		// The response turned out to be unusable. Create our own.
+		render(rsp, req)
+		return
+	}
+	c.prune()
+	e := &cachedResponse{done: make(chan struct{}), generation: generation}
+	c.entries[key] = e
+	c.mtx.Unlock()
+
+	rec := &responseRecorder{header: http.Header{}, status: http.StatusOK}
+	defer func() {
+		// Make sure waiting requests are released even if render
+		// panics. In that case, e.ok is still false.
+		e.expires = c.now().Add(c.duration)
+		close(e.done)
+		if !e.ok {
+			c.mtx.Lock()
+			if c.entries[key] == e {
+				delete(c.entries, key)
+			}
+			c.mtx.Unlock()
+		}
+	}()
+	ok := render(rec, req)
+	e.status, e.header, e.body = rec.status, rec.header, rec.body.Bytes()
+	e.ok = ok && rec.status == http.StatusOK
+	c.writeTo(rsp, e)
+}
+
+// expired returns whether the provided entry has expired. An entry that is
+// still being created has not expired. c.mtx must be locked.
+func (c *responseCache) expired(e *cachedResponse) bool {
+	select {
+	case <-e.done:
+		return !c.now().Before(e.expires)
+	default:
+		return false
+	}
+}
+
+// prune deletes all expired entries. c.mtx must be locked.
+func (c *responseCache) prune() {
+	for key, e := range c.entries {
+		if c.expired(e) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// writeTo writes the provided response to rsp and logs any error.
+func (c *responseCache) writeTo(rsp http.ResponseWriter, e *cachedResponse) {
+	header := rsp.Header()
+	for name, values := range e.header {
+		header[name] = values
+	}
+	rsp.WriteHeader(e.status)
+	if _, err := rsp.Write(e.body); err != nil && c.errorLog != nil {
+		c.errorLog.Println("error sending cached response:", err)
+	}
+}
+
+// responseRecorder is an http.ResponseWriter that records the response in
+// memory.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	wrote  bool // Whether WriteHeader has been called.
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.wrote {
+		return
+	}
+	r.status, r.wrote = status, true
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.wrote = true
+	return r.body.Write(p)
+}
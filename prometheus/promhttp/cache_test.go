@@ -0,0 +1,237 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promhttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// countingCollector counts how often it has been collected.
+type countingCollector struct {
+	desc  *prometheus.Desc
+	count uint64
+}
+
+func (c *countingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *countingCollector) Collect(ch chan<- prometheus.Metric) {
+	n := atomic.AddUint64(&c.count, 1)
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(n))
+}
+
+func TestHandlerCache(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	cc := &countingCollector{desc: prometheus.NewDesc("collections_total", "Number of collections.", nil, nil)}
+	reg.MustRegister(cc)
+	handler := HandlerFor(reg, HandlerOpts{CacheDuration: time.Hour, EnableFiltering: true})
+
+	scrape := func(acceptEncoding, query string) string {
+		request, _ := http.NewRequest("GET", "/?"+query, nil)
+		request.Header.Add("Accept", "text/plain")
+		request.Header.Add(acceptEncodingHeader, acceptEncoding)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Fatalf("got HTTP status code %d, want %d", got, want)
+		}
+		if got, want := w.Header().Get(contentEncodingHeader), acceptEncoding; got != want {
+			t.Errorf("got Content-Encoding %q, want %q", got, want)
+		}
+		return w.Body.String()
+	}
+	assertCollections := func(want uint64) {
+		t.Helper()
+		if got := atomic.LoadUint64(&cc.count); got != want {
+			t.Errorf("got %d collections, want %d", got, want)
+		}
+	}
+
+	first := scrape("", "")
+	if !strings.Contains(first, "collections_total 1") {
+		t.Errorf("body %q does not contain the first collection", first)
+	}
+	if got := scrape("", ""); got != first {
+		t.Errorf("got body %q, want cached body %q", got, first)
+	}
+	assertCollections(1)
+
+	// Different encodings and filters are cached separately.
+	scrape("gzip", "")
+	scrape("gzip", "")
+	assertCollections(2)
+	scrape("", "name[]=collections_total")
+	scrape("", "name[]=collections_total")
+	assertCollections(3)
+
+	// Registering a collector invalidates the cache.
+	reg.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "g", Help: "A gauge."}))
+	if got := scrape("", ""); !strings.Contains(got, "collections_total 4") {
+		t.Errorf("body %q does not contain a new collection", got)
+	}
+	assertCollections(4)
+}
+
+func TestResponseCache(t *testing.T) {
+	cache := newResponseCache(time.Minute, nil)
+	now := time.Unix(1000, 0)
+	cache.now = func() time.Time { return now }
+
+	var (
+		mtx     sync.Mutex
+		renders int
+		ok      = true
+		release chan struct{}
+	)
+	render := func(rsp http.ResponseWriter, _ *http.Request) bool {
+		mtx.Lock()
+		renders++
+		n, succeed, wait := renders, ok, release
+		mtx.Unlock()
+		if wait != nil {
+			<-wait
+		}
+		rsp.Header().Set("X-Render", "yes")
+		rsp.Write([]byte{byte('0' + n)})
+		return succeed
+	}
+	serve := func(generation uint64) string {
+		request, _ := http.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		cache.serve(w, request, "key", generation, render)
+		if got := w.Header().Get("X-Render"); got != "yes" {
+			t.Errorf("header not copied, got %q", got)
+		}
+		return w.Body.String()
+	}
+
+	if got, want := serve(0), "1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	now = now.Add(59 * time.Second)
+	if got, want := serve(0), "1"; got != want {
+		t.Errorf("got %q before expiry, want %q", got, want)
+	}
+	now = now.Add(time.Second)
+	if got, want := serve(0), "2"; got != want {
+		t.Errorf("got %q after expiry, want %q", got, want)
+	}
+	if got, want := serve(1), "3"; got != want {
+		t.Errorf("got %q for new generation, want %q", got, want)
+	}
+
+	// Failed renders are not cached.
+	now = now.Add(time.Hour)
+	ok = false
+	serve(1)
+	ok = true
+	if got, want := serve(1), "5"; got != want {
+		t.Errorf("got %q after failed render, want %q", got, want)
+	}
+
+	// Concurrent requests wait for the render in flight.
+	now = now.Add(time.Hour)
+	release = make(chan struct{})
+	var wg sync.WaitGroup
+	results := make([]string, 5)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0] = serve(1)
+	}()
+	for {
+		mtx.Lock()
+		n := renders
+		mtx.Unlock()
+		if n == 6 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = serve(1)
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	for i, got := range results {
+		if want := "6"; got != want {
+			t.Errorf("request %d: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+// failingResponseWriter is an http.ResponseWriter whose Write method fails.
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w *failingResponseWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestResponseCacheClientGone(t *testing.T) {
+	logBuf := &bytes.Buffer{}
+	cache := newResponseCache(time.Minute, log.New(logBuf, "", 0))
+
+	release := make(chan struct{})
+	rendering := make(chan struct{})
+	render := func(rsp http.ResponseWriter, _ *http.Request) bool {
+		close(rendering)
+		<-release
+		rsp.Write([]byte("metrics"))
+		return true
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		request, _ := http.NewRequest("GET", "/", nil)
+		cache.serve(&failingResponseWriter{httptest.NewRecorder()}, request, "key", 0, render)
+	}()
+	<-rendering
+
+	// A waiting request whose client has gone away gets no response.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request, _ := http.NewRequestWithContext(ctx, "GET", "/", nil)
+	w := httptest.NewRecorder()
+	cache.serve(w, request, "key", 0, render)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || len(w.Header()) != 0 {
+		t.Errorf("got status %d, header %v, and body %q, want nothing written", w.Code, w.Header(), w.Body.String())
+	}
+
+	close(release)
+	<-done
+	if got, want := logBuf.String(), "error sending cached response: connection reset\n"; got != want {
+		t.Errorf("got log %q, want %q", got, want)
+	}
+}
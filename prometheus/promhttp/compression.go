@@ -0,0 +1,216 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promhttp
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is a content encoding (as used in the Accept-Encoding and
+// Content-Encoding HTTP headers) the handlers created by HandlerFor can use to
+// compress responses.
+type Compression string
+
+// The content encodings supported by the handlers created by HandlerFor.
+const (
+	Gzip Compression = "gzip"
+	// Deflate is the "deflate" content encoding of HTTP, i.e. the zlib
+	// format (RFC 1950).
+	Deflate Compression = "deflate"
+	Zstd    Compression = "zstd"
+)
+
+// OfferedCompression configures a content encoding offered by a handler, see
+// HandlerOpts.OfferedCompressions.
+type OfferedCompression struct {
+	Encoding Compression
+	// Level is the compression level. Zero selects the default level of
+	// the encoding. For Gzip and Deflate, the levels of the compress/flate
+	// package are used (1 for best speed to 9 for best compression, or -2
+	// for Huffman-only compression). For Zstd, the levels of the zstd
+	// command line tool are used (1 for best speed to 22 for best
+	// compression, which are mapped to the 4 levels supported by the
+	// implementation).
+	Level int
+}
+
+// defaultOfferedCompressions are offered if HandlerOpts.OfferedCompressions is
+// empty. Gzip goes first so that clients accepting gzip and other encodings
+// with the same preference keep getting gzip.
+var defaultOfferedCompressions = []OfferedCompression{
+	{Encoding: Gzip},
+	{Encoding: Zstd},
+	{Encoding: Deflate},
+}
+
+// compressor is implemented by all compressing writers used by the handler.
+type compressor interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+// compressorPool provides pooled compressors for an offered compression.
+type compressorPool struct {
+	encoding Compression
+	pool     sync.Pool
+}
+
+// newCompressorPools returns a pool for each of the provided offered
+// compressions, in the same order. It panics if an encoding is not supported or
+// a level is invalid.
+func newCompressorPools(offered []OfferedCompression) []*compressorPool {
+	if len(offered) == 0 {
+		offered = defaultOfferedCompressions
+	}
+	pools := make([]*compressorPool, 0, len(offered))
+	for _, o := range offered {
+		newCompressor, err := compressorFactory(o)
+		if err != nil {
+			panic(err)
+		}
+		// Create one compressor right away to detect invalid levels.
+		c, err := newCompressor()
+		if err != nil {
+			panic(err)
+		}
+		p := &compressorPool{encoding: o.Encoding}
+		p.pool.New = func() interface{} {
+			c, _ := newCompressor() // Error checked above.
+			return c
+		}
+		p.pool.Put(c)
+		pools = append(pools, p)
+	}
+	return pools
+}
+
+// compressorFactory returns a function creating compressors for the provided
+// offered compression.
+func compressorFactory(o OfferedCompression) (func() (compressor, error), error) {
+	switch o.Encoding {
+	case Gzip:
+		level := o.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return func() (compressor, error) { return gzip.NewWriterLevel(nil, level) }, nil
+	case Deflate:
+		level := o.Level
+		if level == 0 {
+			level = zlib.DefaultCompression
+		}
+		return func() (compressor, error) { return zlib.NewWriterLevel(nil, level) }, nil
+	case Zstd:
+		level := zstd.SpeedDefault
+		if o.Level < 0 {
+			return nil, fmt.Errorf("invalid zstd compression level %d", o.Level)
+		}
+		if o.Level > 0 {
+			level = zstd.EncoderLevelFromZstd(o.Level)
+		}
+		return func() (compressor, error) {
+			// Compress synchronously, as each response is
+			// compressed in its own goroutine anyway.
+			return zstd.NewWriter(nil, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported compression %q", o.Encoding)
+}
+
+// get returns a compressor from the pool, reset to write to w.
+func (p *compressorPool) get(w io.Writer) compressor {
+	c := p.pool.Get().(compressor)
+	c.Reset(w)
+	return c
+}
+
+// put returns the provided compressor to the pool. It must have been closed.
+func (p *compressorPool) put(c compressor) {
+	c.Reset(nil)
+	p.pool.Put(c)
+}
+
+// negotiateCompression returns the pool of the offered compression to use for
+// a request with the provided header, or nil if the response should not be
+// compressed. Of the offered compressions accepted by the client, the one with
+// the highest q-value in the Accept-Encoding header is selected. Ties are
+// broken by the order of the offered compressions. The "*" wildcard is taken
+// into account. "identity" (i.e. no compression) is assumed to be always
+// acceptable, but it is only preferred over the selected compression if it has
+// an explicitly higher q-value.
+func negotiateCompression(header http.Header, pools []*compressorPool) *compressorPool {
+	var (
+		accepted = map[string]float64{}
+		wildcard = -1.0 // Negative if there is no wildcard.
+	)
+	for _, part := range strings.Split(header.Get(acceptEncodingHeader), ",") {
+		coding, params, _ := cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, ok := cut(param, "=")
+			if !ok || strings.ToLower(strings.TrimSpace(name)) != "q" {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				parsed = 0 // Ignore codings with invalid q-values.
+			}
+			q = parsed
+		}
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		accepted[coding] = q
+	}
+
+	var (
+		best  *compressorPool
+		bestQ float64
+	)
+	for _, p := range pools {
+		q, ok := accepted[string(p.encoding)]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = p, q
+		}
+	}
+	if q, ok := accepted["identity"]; ok && q > bestQ {
+		return nil
+	}
+	return best
+}
+
+// cut works like strings.Cut, which is not available in Go 1.17.
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
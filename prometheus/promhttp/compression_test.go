@@ -0,0 +1,152 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promhttp
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNegotiateCompression(t *testing.T) {
+	pools := newCompressorPools(nil)
+	for _, tc := range []struct {
+		acceptEncoding string
+		want           Compression // Empty for no compression.
+	}{
+		{acceptEncoding: "", want: ""},
+		{acceptEncoding: "gzip", want: Gzip},
+		{acceptEncoding: "GZIP;q=0.5", want: Gzip},
+		{acceptEncoding: "zstd", want: Zstd},
+		{acceptEncoding: "deflate, br", want: Deflate},
+		{acceptEncoding: "zstd, gzip", want: Gzip},
+		{acceptEncoding: "zstd;q=1.0, gzip;q=0.8", want: Zstd},
+		{acceptEncoding: "gzip;q=0, zstd;q=0.1", want: Zstd},
+		{acceptEncoding: "gzip;q=0", want: ""},
+		{acceptEncoding: "gzip;q=invalid", want: ""},
+		{acceptEncoding: "*", want: Gzip},
+		{acceptEncoding: "*;q=0.5, zstd", want: Zstd},
+		{acceptEncoding: "*, gzip;q=0", want: Zstd},
+		{acceptEncoding: "gzip;q=0.5, identity", want: ""},
+		{acceptEncoding: "gzip, identity;q=0.5", want: Gzip},
+		{acceptEncoding: "br", want: ""},
+	} {
+		header := http.Header{}
+		header.Set(acceptEncodingHeader, tc.acceptEncoding)
+		var got Compression
+		if p := negotiateCompression(header, pools); p != nil {
+			got = p.encoding
+		}
+		if got != tc.want {
+			t.Errorf("Accept-Encoding %q: got %q, want %q", tc.acceptEncoding, got, tc.want)
+		}
+	}
+
+	// Ties are broken by the order of the offered compressions.
+	pools = newCompressorPools([]OfferedCompression{{Encoding: Zstd}, {Encoding: Gzip}})
+	header := http.Header{}
+	header.Set(acceptEncodingHeader, "gzip, zstd, deflate")
+	if p := negotiateCompression(header, pools); p == nil || p.encoding != Zstd {
+		t.Errorf("got %v, want zstd", p)
+	}
+	header.Set(acceptEncodingHeader, "deflate")
+	if p := negotiateCompression(header, pools); p != nil {
+		t.Errorf("got %q, want no compression as deflate is not offered", p.encoding)
+	}
+}
+
+func TestHandlerCompression(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	cnt := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "the_count",
+		Help: "Ah-ah-ah! Thunder and lightning!",
+	})
+	reg.MustRegister(cnt)
+
+	decoders := map[Compression]func(io.Reader) (io.Reader, error){
+		Gzip: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		Deflate: func(r io.Reader) (io.Reader, error) {
+			return zlib.NewReader(r)
+		},
+		Zstd: func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+	want := "# HELP the_count Ah-ah-ah! Thunder and lightning!\n# TYPE the_count counter\nthe_count 0\n"
+
+	handler := HandlerFor(reg, HandlerOpts{OfferedCompressions: []OfferedCompression{
+		{Encoding: Gzip, Level: 1},
+		{Encoding: Zstd, Level: 19},
+		{Encoding: Deflate, Level: 9},
+	}})
+	for encoding, decode := range decoders {
+		// Twice to exercise the pooling.
+		for i := 0; i < 2; i++ {
+			request, _ := http.NewRequest("GET", "/", nil)
+			request.Header.Set(acceptEncodingHeader, string(encoding))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+
+			if got := w.Header().Get(contentEncodingHeader); got != string(encoding) {
+				t.Errorf("got Content-Encoding %q, want %q", got, encoding)
+			}
+			r, err := decode(w.Body)
+			if err != nil {
+				t.Fatalf("%s: %v", encoding, err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s: %v", encoding, err)
+			}
+			if string(got) != want {
+				t.Errorf("%s: got body %q, want %q", encoding, got, want)
+			}
+		}
+	}
+
+	// DisableCompression wins.
+	request, _ := http.NewRequest("GET", "/", nil)
+	request.Header.Set(acceptEncodingHeader, "gzip")
+	w := httptest.NewRecorder()
+	HandlerFor(reg, HandlerOpts{DisableCompression: true}).ServeHTTP(w, request)
+	if got := w.Header().Get(contentEncodingHeader); got != "" {
+		t.Errorf("got Content-Encoding %q, want none", got)
+	}
+	if got := w.Body.String(); got != want {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+}
+
+func TestHandlerCompressionValidation(t *testing.T) {
+	for _, offered := range []OfferedCompression{
+		{Encoding: "br"},
+		{Encoding: Gzip, Level: 42},
+		{Encoding: Deflate, Level: -3},
+		{Encoding: Zstd, Level: -1},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic for %+v", offered)
+				}
+			}()
+			HandlerFor(prometheus.NewRegistry(), HandlerOpts{OfferedCompressions: []OfferedCompression{offered}})
+		}()
+	}
+}
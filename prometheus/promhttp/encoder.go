@@ -0,0 +1,232 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promhttp
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/internal"
+)
+
+// newEncoder returns an expfmt.Encoder for the provided format. For the text
+// formats, the returned Encoder fills in what the expfmt package doesn't
+// support yet:
+//
+//   - Gauge histograms are exposed with the "gaugehistogram" type and _gcount
+//     and _gsum series in the OpenMetrics text format, and as regular
+//     histograms in the classic Prometheus text format.
+//   - If the provided TransactionalGatherer implements
+//     prometheus.UnitProvider, "# UNIT" lines are added for metric families
+//     with a unit in the OpenMetrics text format.
+//   - If the provided TransactionalGatherer implements
+//     prometheus.TypeProvider, info and state set metric families (which are
+//     gathered as gauges) are exposed with the "info" and "stateset" type in
+//     the OpenMetrics text format. The name of an info metric family is
+//     exposed without the "_info" suffix its samples have.
+//   - If createdSamples is true, created timestamps of counters, histograms,
+//     and summaries are exposed as _created samples in the OpenMetrics text
+//     format.
+func newEncoder(w io.Writer, format expfmt.Format, reg prometheus.TransactionalGatherer, createdSamples bool) expfmt.Encoder {
+	openMetrics := strings.HasPrefix(string(format), expfmt.OpenMetricsType)
+	if !openMetrics && format != expfmt.FmtText {
+		return expfmt.NewEncoder(w, format)
+	}
+	e := &textEncoder{w: w, openMetrics: openMetrics, createdSamples: createdSamples && openMetrics}
+	if up, ok := reg.(prometheus.UnitProvider); ok && openMetrics {
+		e.units = up.Units()
+	}
+	if tp, ok := reg.(prometheus.TypeProvider); ok && openMetrics {
+		e.types = tp.OpenMetricsTypes()
+	}
+	e.enc = expfmt.NewEncoder(&e.buf, format)
+	return e
+}
+
+// textEncoder encodes each metric family into a buffer, adjusts the result if
+// needed, and then writes the buffer to w.
+type textEncoder struct {
+	w              io.Writer
+	openMetrics    bool
+	createdSamples bool // Only true for OpenMetrics.
+	units          map[string]string
+	types          map[string]string // OpenMetrics types of gauges.
+	buf            bytes.Buffer
+	enc            expfmt.Encoder // Encodes into buf.
+}
+
+// Encode implements expfmt.Encoder.
+func (e *textEncoder) Encode(mf *dto.MetricFamily) error {
+	e.buf.Reset()
+	isGaugeHistogram := mf.GetType() == dto.MetricType_GAUGE_HISTOGRAM
+	if err := e.enc.Encode(internal.GaugeHistogramAsHistogram(mf)); err != nil {
+		return err
+	}
+	unit := e.units[mf.GetName()]
+	var omType string
+	if mf.GetType() == dto.MetricType_GAUGE {
+		omType = e.types[mf.GetName()]
+	}
+	created := e.createdSamples && hasCreatedTimestamps(mf)
+	if !e.openMetrics || !isGaugeHistogram && unit == "" && omType == "" && !created {
+		_, err := e.w.Write(e.buf.Bytes())
+		return err
+	}
+	// The name of an info metric family lacks the "_info" suffix.
+	familyName := mf.GetName()
+	if omType == "info" {
+		familyName = strings.TrimSuffix(familyName, "_info")
+	}
+
+	var (
+		out  bytes.Buffer
+		name string // As in the "# TYPE" line, i.e. without "_total".
+		// The _created sample of each metric is added after its sample
+		// with this name, which is the last one written for the metric.
+		lastSample string
+		i          int // Index of the metric of the next lastSample.
+	)
+	for _, line := range strings.SplitAfter(e.buf.String(), "\n") {
+		switch {
+		case familyName != mf.GetName() && strings.HasPrefix(line, "# HELP "+mf.GetName()+" "):
+			out.WriteString("# HELP " + familyName + line[len("# HELP ")+len(mf.GetName()):])
+		case name == "" && strings.HasPrefix(line, "# TYPE "):
+			fields := strings.Fields(line)
+			if len(fields) < 4 {
+				// Should never happen.
+				out.WriteString(line)
+				continue
+			}
+			name = fields[2]
+			switch {
+			case !created:
+			case fields[3] == "counter":
+				lastSample = name + "_total"
+			case fields[3] == "histogram", fields[3] == "summary":
+				lastSample = name + "_count"
+			}
+			switch {
+			case isGaugeHistogram:
+				line = "# TYPE " + name + " gaugehistogram\n"
+			case omType != "":
+				line = "# TYPE " + familyName + " " + omType + "\n"
+			}
+			out.WriteString(line)
+			if unit != "" {
+				out.WriteString("# UNIT " + name + " " + unit + "\n")
+			}
+		case isGaugeHistogram && name != "" && isSampleOf(line, name+"_count"):
+			out.WriteString(name + "_gcount" + line[len(name)+len("_count"):])
+		case isGaugeHistogram && name != "" && isSampleOf(line, name+"_sum"):
+			out.WriteString(name + "_gsum" + line[len(name)+len("_sum"):])
+		case lastSample != "" && isSampleOf(line, lastSample):
+			out.WriteString(line)
+			if i < len(mf.Metric) {
+				out.WriteString(createdSample(name, mf.Metric[i]))
+			}
+			i++
+		default:
+			out.WriteString(line)
+		}
+	}
+	_, err := e.w.Write(out.Bytes())
+	return err
+}
+
+// hasCreatedTimestamps returns whether any metric of the provided metric family
+// has a created timestamp.
+func hasCreatedTimestamps(mf *dto.MetricFamily) bool {
+	for _, m := range mf.Metric {
+		if createdTimestamp(m) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// createdTimestamp returns the created timestamp of the provided counter,
+// histogram, or summary, or nil if it has none.
+func createdTimestamp(m *dto.Metric) *timestamppb.Timestamp {
+	switch {
+	case m.Counter != nil:
+		return m.Counter.CreatedTimestamp
+	case m.Histogram != nil:
+		return m.Histogram.CreatedTimestamp
+	case m.Summary != nil:
+		return m.Summary.CreatedTimestamp
+	}
+	return nil
+}
+
+// createdSample returns the _created sample line of the provided metric in the
+// OpenMetrics text format, or "" if the metric has no created timestamp.
+func createdSample(name string, m *dto.Metric) string {
+	ts := createdTimestamp(m)
+	if ts == nil {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteString("_created")
+	for i, lp := range m.Label {
+		if i == 0 {
+			b.WriteByte('{')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(lp.GetName())
+		b.WriteString(`="`)
+		labelValueEscaper.WriteString(&b, lp.GetValue())
+		b.WriteByte('"')
+	}
+	if len(m.Label) > 0 {
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	seconds := float64(ts.GetSeconds()) + float64(ts.GetNanos())/1e9
+	b.WriteString(strconv.FormatFloat(seconds, 'f', -1, 64))
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// labelValueEscaper escapes label values as the expfmt package does.
+var labelValueEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+
+// isSampleOf returns whether the provided line of the text format is a sample
+// with the provided name.
+func isSampleOf(line, name string) bool {
+	return strings.HasPrefix(line, name) && len(line) > len(name) &&
+		(line[len(name)] == '{' || line[len(name)] == ' ')
+}
+
+// Close implements expfmt.Closer.
+func (e *textEncoder) Close() error {
+	closer, ok := e.enc.(expfmt.Closer)
+	if !ok {
+		return nil
+	}
+	e.buf.Reset()
+	if err := closer.Close(); err != nil {
+		return err
+	}
+	_, err := e.w.Write(e.buf.Bytes())
+	return err
+}
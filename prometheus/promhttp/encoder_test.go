@@ -0,0 +1,73 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promhttp
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func BenchmarkEncoder(b *testing.B) {
+	reg := prometheus.NewRegistry()
+	cnt := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "requests_total",
+		Help: "Total requests.",
+	}, []string{"path"})
+	his := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "request_duration_seconds",
+		Help: "Request duration.",
+		Unit: "seconds",
+	}, []string{"path"})
+	gge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "temperature",
+		Help: "Current temperature.",
+	}, []string{"room"})
+	reg.MustRegister(cnt, his, gge)
+	for i := 0; i < 100; i++ {
+		cnt.WithLabelValues(fmt.Sprint("/", i)).Inc()
+		his.WithLabelValues(fmt.Sprint("/", i)).Observe(0.1)
+		gge.WithLabelValues(fmt.Sprint(i)).Set(20)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bc := range []struct {
+		name           string
+		format         expfmt.Format
+		createdSamples bool
+	}{
+		{name: "text", format: expfmt.FmtText},
+		{name: "openmetrics", format: expfmt.FmtOpenMetrics},
+		{name: "openmetrics with created samples", format: expfmt.FmtOpenMetrics, createdSamples: true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				enc := newEncoder(io.Discard, bc.format, prometheus.ToTransactionalGatherer(reg), bc.createdSamples)
+				for _, mf := range mfs {
+					if err := enc.Encode(mf); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
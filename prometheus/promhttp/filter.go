@@ -0,0 +1,211 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promhttp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	nameParam  = "name[]"
+	matchParam = "match[]"
+)
+
+// metricFilterFromQuery creates a prometheus.MetricFilter from the "name[]"
+// and "match[]" parameters in the provided query. Each "name[]" parameter
+// selects a metric family by its exact name. Each "match[]" parameter is a
+// series selector as known from PromQL, e.g. `http_requests_total{code=~"5.."}`
+// or `{__name__=~"go_.*"}`.
+func metricFilterFromQuery(query url.Values) (prometheus.MetricFilter, error) {
+	f := prometheus.MetricFilter{Names: query[nameParam]}
+	for _, s := range query[matchParam] {
+		selector, err := parseSelector(s)
+		if err != nil {
+			return prometheus.MetricFilter{}, fmt.Errorf("invalid %s parameter %q: %w", matchParam, s, err)
+		}
+		f.Selectors = append(f.Selectors, selector)
+	}
+	return f, nil
+}
+
+// parseSelector parses a PromQL series selector without range or offset, like
+// `name{label="value",other=~"regexp"}`.
+func parseSelector(s string) ([]*prometheus.LabelMatcher, error) {
+	p := selectorParser{input: s}
+	var matchers []*prometheus.LabelMatcher
+
+	p.skipSpace()
+	if name := p.identifier(); name != "" {
+		if !model.IsValidMetricName(model.LabelValue(name)) {
+			return nil, fmt.Errorf("invalid metric name %q", name)
+		}
+		m, err := prometheus.NewLabelMatcher(prometheus.MatchEqual, prometheus.MetricNameLabel, name)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	p.skipSpace()
+	if p.consume("{") {
+		for {
+			p.skipSpace()
+			if p.consume("}") {
+				break
+			}
+			m, err := p.matcher()
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, m)
+			p.skipSpace()
+			if p.consume(",") {
+				continue
+			}
+			if !p.consume("}") {
+				return nil, errors.New("expected ',' or '}' after label matcher")
+			}
+			break
+		}
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos:], p.pos)
+	}
+	if len(matchers) == 0 {
+		return nil, errors.New("selector must contain a metric name or at least one label matcher")
+	}
+	return matchers, nil
+}
+
+// selectorParser is a minimal recursive descent parser for series selectors.
+type selectorParser struct {
+	input string
+	pos   int
+}
+
+func (p *selectorParser) skipSpace() {
+	for p.pos < len(p.input) && strings.ContainsRune(" \t\n\r", rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *selectorParser) consume(token string) bool {
+	if strings.HasPrefix(p.input[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+// identifier consumes and returns a metric or label name. It returns an empty
+// string if there is no identifier at the current position.
+func (p *selectorParser) identifier() string {
+	start := p.pos
+	for p.pos < len(p.input) {
+		b := p.input[p.pos]
+		if b == '_' || b == ':' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' && p.pos > start {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *selectorParser) matcher() (*prometheus.LabelMatcher, error) {
+	name := p.identifier()
+	if name == "" {
+		return nil, fmt.Errorf("expected label name at position %d", p.pos)
+	}
+	p.skipSpace()
+	var t prometheus.MatchType
+	switch {
+	case p.consume("=~"):
+		t = prometheus.MatchRegexp
+	case p.consume("!~"):
+		t = prometheus.MatchNotRegexp
+	case p.consume("!="):
+		t = prometheus.MatchNotEqual
+	case p.consume("="):
+		t = prometheus.MatchEqual
+	default:
+		return nil, fmt.Errorf("expected match operator after label name %q", name)
+	}
+	p.skipSpace()
+	value, err := p.quoted()
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for label %q: %w", name, err)
+	}
+	return prometheus.NewLabelMatcher(t, name, value)
+}
+
+// quoted consumes a double-quoted, single-quoted, or backtick-quoted string
+// and returns its unquoted value.
+func (p *selectorParser) quoted() (string, error) {
+	if p.pos >= len(p.input) {
+		return "", errors.New("expected quoted string")
+	}
+	quote := p.input[p.pos]
+	if quote != '"' && quote != '\'' && quote != '`' {
+		return "", errors.New("expected quoted string")
+	}
+	for end := p.pos + 1; end < len(p.input); end++ {
+		switch p.input[end] {
+		case '\\':
+			if quote != '`' {
+				end++
+			}
+		case quote:
+			raw := p.input[p.pos : end+1]
+			p.pos = end + 1
+			if quote == '\'' {
+				raw = singleToDoubleQuoted(raw)
+			}
+			return strconv.Unquote(raw)
+		}
+	}
+	return "", errors.New("unterminated quoted string")
+}
+
+// singleToDoubleQuoted converts a single-quoted string into a double-quoted
+// one, as strconv.Unquote only accepts a single character in single quotes.
+func singleToDoubleQuoted(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 1; i < len(s)-1; i++ {
+		switch c := s[i]; c {
+		case '\\':
+			if s[i+1] == '\'' {
+				b.WriteByte('\'')
+			} else {
+				b.WriteString(s[i : i+2])
+			}
+			i++
+		case '"':
+			b.WriteString(`\"`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -18,7 +18,8 @@
 // prometheus.DefaultGatherer. With HandlerFor, you can create a handler for a
 // custom registry or anything that implements the Gatherer interface. It also
 // allows the creation of handlers that act differently on errors or allow to
-// log errors.
+// log errors. With ProbeHandler, you can create a handler for multi-target
+// exporters that probe a target provided with each request.
 //
 // Second, the package provides tooling to instrument instances of http.Handler
 // via middleware. Middleware wrappers follow the naming scheme
@@ -32,15 +33,14 @@
 package promhttp
 
 import (
-	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
-	"sync"
 	"time"
 
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -52,12 +52,6 @@ const (
 	acceptEncodingHeader  = "Accept-Encoding"
 )
 
-var gzipPool = sync.Pool{
-	New: func() interface{} {
-		return gzip.NewWriter(nil)
-	},
-}
-
 // Handler returns an http.Handler for the prometheus.DefaultGatherer, using
 // default HandlerOpts, i.e. it reports the first error as an HTTP error, it has
 // no error logging, and it applies compression if requested by the client.
@@ -91,33 +85,27 @@ func HandlerFor(reg prometheus.Gatherer, opts HandlerOpts) http.Handler {
 // HandlerForTransactional is like HandlerFor, but it uses transactional gather, which
 // can safely change in-place returned *dto.MetricFamily before call to `Gather` and after
 // call to `done` of that `Gather`.
+//
+// If the provided TransactionalGatherer implements
+// prometheus.ContextTransactionalGatherer (which is in particular the case for
+// the TransactionalGatherer created by HandlerFor from a Gatherer implementing
+// prometheus.ContextGatherer, like prometheus.Registry), the context of each
+// HTTP request is passed on to the collection. Collectors implementing
+// prometheus.ContextCollector can then honor the cancellation of the request
+// and retrieve the request itself with RequestFromContext.
 func HandlerForTransactional(reg prometheus.TransactionalGatherer, opts HandlerOpts) http.Handler {
-	var (
-		inFlightSem chan struct{}
-		errCnt      = prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Name: "promhttp_metric_handler_errors_total",
-				Help: "Total number of internal errors encountered by the promhttp metric handler.",
-			},
-			[]string{"cause"},
-		)
-	)
-
+	var inFlightSem chan struct{}
 	if opts.MaxRequestsInFlight > 0 {
 		inFlightSem = make(chan struct{}, opts.MaxRequestsInFlight)
 	}
-	if opts.Registry != nil {
-		// Initialize all possibilities that can occur below.
-		errCnt.WithLabelValues("gathering")
-		errCnt.WithLabelValues("encoding")
-		if err := opts.Registry.Register(errCnt); err != nil {
-			are := &prometheus.AlreadyRegisteredError{}
-			if errors.As(err, are) {
-				errCnt = are.ExistingCollector.(*prometheus.CounterVec)
-			} else {
-				panic(err)
-			}
-		}
+	e := newExposer(opts)
+	serve := func(rsp http.ResponseWriter, req *http.Request) bool {
+		return e.serve(rsp, req, reg)
+	}
+
+	var cache *responseCache
+	if opts.CacheDuration > 0 {
+		cache = newResponseCache(opts.CacheDuration, opts.ErrorLog)
 	}
 
 	h := http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
@@ -132,95 +120,260 @@ func HandlerForTransactional(reg prometheus.TransactionalGatherer, opts HandlerO
 				return
 			}
 		}
-		mfs, done, err := reg.Gather()
-		defer done()
-		if err != nil {
-			if opts.ErrorLog != nil {
-				opts.ErrorLog.Println("error gathering metrics:", err)
-			}
-			errCnt.WithLabelValues("gathering").Inc()
-			switch opts.ErrorHandling {
-			case PanicOnError:
+		if timeout := handlerTimeout(req.Header, opts); timeout > 0 {
+			ctx, cancel := context.WithTimeout(req.Context(), timeout)
+			defer cancel()
+			req = req.WithContext(ctx)
+		}
+		if cache == nil {
+			serve(rsp, req)
+			return
+		}
+		var generation uint64
+		if gp, ok := reg.(prometheus.GenerationProvider); ok {
+			generation = gp.Generation()
+		}
+		cache.serve(rsp, req, cacheKey(req, opts, e.compressorPools), generation, serve)
+	})
+
+	if opts.Timeout <= 0 {
+		return h
+	}
+	return http.TimeoutHandler(h, opts.Timeout, fmt.Sprintf(
+		"Exceeded configured timeout of %v.\n",
+		opts.Timeout,
+	))
+}
+
+// handlerTimeout returns the timeout for the collection of metrics for a
+// request with the provided header, see HandlerOpts.Timeout.
+func handlerTimeout(header http.Header, opts HandlerOpts) time.Duration {
+	timeout, ok := scrapeTimeout(header, opts.ScrapeTimeoutOffset)
+	if !ok || (opts.Timeout > 0 && timeout > opts.Timeout) {
+		return opts.Timeout
+	}
+	return timeout
+}
+
+// exposer gathers metrics and writes them to HTTP responses as configured by
+// HandlerOpts.
+type exposer struct {
+	opts            HandlerOpts
+	errCnt          *prometheus.CounterVec
+	compressorPools []*compressorPool
+}
+
+func newExposer(opts HandlerOpts) *exposer {
+	errCnt := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "promhttp_metric_handler_errors_total",
+			Help: "Total number of internal errors encountered by the promhttp metric handler.",
+		},
+		[]string{"cause"},
+	)
+	var compressorPools []*compressorPool
+	if !opts.DisableCompression {
+		compressorPools = newCompressorPools(opts.OfferedCompressions)
+	}
+	if opts.Registry != nil {
+		// Initialize all possibilities that can occur below.
+		errCnt.WithLabelValues("gathering")
+		errCnt.WithLabelValues("encoding")
+		if err := opts.Registry.Register(errCnt); err != nil {
+			are := &prometheus.AlreadyRegisteredError{}
+			if errors.As(err, are) {
+				errCnt = are.ExistingCollector.(*prometheus.CounterVec)
+			} else {
 				panic(err)
-			case ContinueOnError:
-				if len(mfs) == 0 {
-					// Still report the error if no metrics have been gathered.
-					httpError(rsp, err)
-					return
-				}
-			case HTTPErrorOnError:
-				httpError(rsp, err)
-				return
 			}
 		}
+	}
+	return &exposer{opts: opts, errCnt: errCnt, compressorPools: compressorPools}
+}
 
-		var contentType expfmt.Format
-		if opts.EnableOpenMetrics {
-			contentType = expfmt.NegotiateIncludingOpenMetrics(req.Header)
-		} else {
-			contentType = expfmt.Negotiate(req.Header)
+// serve gathers the metrics from reg and writes them to rsp, encoded in the
+// negotiated format and compressed with the negotiated encoding. It returns
+// false if any error was encountered.
+func (e *exposer) serve(rsp http.ResponseWriter, req *http.Request, reg prometheus.TransactionalGatherer) bool {
+	var filter prometheus.MetricFilter
+	if e.opts.EnableFiltering {
+		var err error
+		if filter, err = metricFilterFromQuery(req.URL.Query()); err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return false
 		}
+	}
+
+	var contentType expfmt.Format
+	if e.opts.EnableOpenMetrics {
+		contentType = expfmt.NegotiateIncludingOpenMetrics(req.Header)
+	} else {
+		contentType = expfmt.Negotiate(req.Header)
+	}
+
+	var (
+		errored bool
+		enc     expfmt.Encoder // Only set once sending the body has started.
+		c       compressor
+		p       *compressorPool
+	)
+	defer func() {
+		if c != nil {
+			c.Close()
+			p.put(c)
+		}
+	}()
+	// startEncoding sets the response headers and creates enc.
+	startEncoding := func() {
 		header := rsp.Header()
 		header.Set(contentTypeHeader, string(contentType))
 
 		w := io.Writer(rsp)
-		if !opts.DisableCompression && gzipAccepted(req.Header) {
-			header.Set(contentEncodingHeader, "gzip")
-			gz := gzipPool.Get().(*gzip.Writer)
-			defer gzipPool.Put(gz)
-
-			gz.Reset(w)
-			defer gz.Close()
-
-			w = gz
+		if p = negotiateCompression(req.Header, e.compressorPools); p != nil {
+			header.Set(contentEncodingHeader, string(p.encoding))
+			c = p.get(w)
+			w = c
 		}
 
-		enc := expfmt.NewEncoder(w, contentType)
+		enc = newEncoder(w, contentType, reg, e.opts.EnableOpenMetricsTextCreatedSamples)
+	}
 
-		// handleError handles the error according to opts.ErrorHandling
-		// and returns true if we have to abort after the handling.
-		handleError := func(err error) bool {
-			if err == nil {
+	// handleGatherError handles the error according to
+	// e.opts.ErrorHandling and returns true if we have to abort after
+	// the handling. gatheredAny is whether any metrics have been
+	// gathered despite the error.
+	handleGatherError := func(err error, gatheredAny bool) bool {
+		if e.opts.ErrorLog != nil {
+			e.opts.ErrorLog.Println("error gathering metrics:", err)
+		}
+		e.errCnt.WithLabelValues("gathering").Inc()
+		errored = true
+		switch e.opts.ErrorHandling {
+		case PanicOnError:
+			panic(err)
+		case ContinueOnError:
+			if gatheredAny {
 				return false
 			}
-			if opts.ErrorLog != nil {
-				opts.ErrorLog.Println("error encoding and sending metric family:", err)
-			}
-			errCnt.WithLabelValues("encoding").Inc()
-			switch opts.ErrorHandling {
-			case PanicOnError:
-				panic(err)
-			case HTTPErrorOnError:
-				// We cannot really send an HTTP error at this
-				// point because we most likely have written
-				// something to rsp already. But at least we can
-				// stop sending.
-				return true
-			}
-			// Do nothing in all other cases, including ContinueOnError.
+		}
+		if enc == nil {
+			// Still report the error if no metrics have been sent.
+			httpError(rsp, err)
+		}
+		// Otherwise, we cannot send an HTTP error anymore because
+		// we have written something to rsp already. But at least
+		// we can stop sending.
+		return true
+	}
+
+	// handleError handles the error according to e.opts.ErrorHandling
+	// and returns true if we have to abort after the handling.
+	handleError := func(err error) bool {
+		if err == nil {
 			return false
 		}
+		if e.opts.ErrorLog != nil {
+			e.opts.ErrorLog.Println("error encoding and sending metric family:", err)
+		}
+		e.errCnt.WithLabelValues("encoding").Inc()
+		errored = true
+		switch e.opts.ErrorHandling {
+		case PanicOnError:
+			panic(err)
+		case HTTPErrorOnError:
+			// We cannot really send an HTTP error at this
+			// point because we most likely have written
+			// something to rsp already. But at least we can
+			// stop sending.
+			return true
+		}
+		// Do nothing in all other cases, including ContinueOnError.
+		return false
+	}
 
+	var streamer prometheus.StreamingGatherer
+	if e.opts.EnableStreaming {
+		streamer, _ = reg.(prometheus.StreamingGatherer)
+	}
+	if streamer != nil {
+		var sentAny bool
+		err := gatherStream(streamer, req, func(mf *dto.MetricFamily) error {
+			for _, mf := range filter.Filter([]*dto.MetricFamily{mf}) {
+				if enc == nil {
+					startEncoding()
+				}
+				sentAny = true
+				if handleError(enc.Encode(mf)) {
+					return errEncodingAborted
+				}
+			}
+			return nil
+		})
+		if errors.Is(err, errEncodingAborted) {
+			return false
+		}
+		if err != nil && handleGatherError(err, sentAny) {
+			return false
+		}
+	} else {
+		mfs, done, err := gather(reg, req)
+		defer done()
+		if err != nil && handleGatherError(err, len(mfs) > 0) {
+			return false
+		}
+		mfs = filter.Filter(mfs)
+		startEncoding()
 		for _, mf := range mfs {
 			if handleError(enc.Encode(mf)) {
-				return
+				return false
 			}
 		}
-		if closer, ok := enc.(expfmt.Closer); ok {
-			// This in particular takes care of the final "# EOF\n" line for OpenMetrics.
-			if handleError(closer.Close()) {
-				return
-			}
+	}
+
+	if enc == nil {
+		startEncoding()
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		// This in particular takes care of the final "# EOF\n" line for OpenMetrics.
+		if handleError(closer.Close()) {
+			return false
 		}
-	})
+	}
+	return !errored
+}
 
-	if opts.Timeout <= 0 {
-		return h
+type requestContextKey struct{}
+
+// RequestFromContext returns the HTTP request served by a handler created with
+// HandlerFor or HandlerForTransactional from the context passed to Collectors
+// implementing prometheus.ContextCollector. This allows Collectors to make use
+// of request-scoped information like query parameters. The second return
+// value is false if the context does not carry a request.
+func RequestFromContext(ctx context.Context) (*http.Request, bool) {
+	req, ok := ctx.Value(requestContextKey{}).(*http.Request)
+	return req, ok
+}
+
+// gather calls the GatherWithContext method of the provided
+// TransactionalGatherer (if available) with the context of the provided
+// request, which additionally carries the request itself. Otherwise, it simply
+// calls Gather.
+func gather(reg prometheus.TransactionalGatherer, req *http.Request) ([]*dto.MetricFamily, func(), error) {
+	if cg, ok := reg.(prometheus.ContextTransactionalGatherer); ok {
+		return cg.GatherWithContext(context.WithValue(req.Context(), requestContextKey{}, req))
 	}
-	return http.TimeoutHandler(h, opts.Timeout, fmt.Sprintf(
-		"Exceeded configured timeout of %v.\n",
-		opts.Timeout,
-	))
+	return reg.Gather()
+}
+
+// errEncodingAborted is returned to GatherStream to abort gathering after an
+// encoding error.
+var errEncodingAborted = errors.New("encoding aborted")
+
+// gatherStream calls the GatherStream method of the provided StreamingGatherer
+// with the context of the provided request, which additionally carries the
+// request itself.
+func gatherStream(sg prometheus.StreamingGatherer, req *http.Request, f func(*dto.MetricFamily) error) error {
+	return sg.GatherStream(context.WithValue(req.Context(), requestContextKey{}, req), f)
 }
 
 // InstrumentMetricHandler is usually used with an http.Handler returned by the
@@ -341,6 +494,16 @@ type HandlerOpts struct {
 	// If DisableCompression is true, the handler will never compress the
 	// response, even if requested by the client.
 	DisableCompression bool
+	// OfferedCompressions are the content encodings (and their
+	// compression levels) the handler offers to clients, in order of
+	// preference. The handler negotiates the encoding with the
+	// Accept-Encoding header of the request, taking q-values into
+	// account, and uses the order of OfferedCompressions to break ties.
+	// If OfferedCompressions is empty, Gzip, Zstd, and Deflate are offered
+	// (in that order) at their default levels. OfferedCompressions is
+	// ignored if DisableCompression is true. Handler creation panics if an
+	// encoding is not supported or a level is invalid.
+	OfferedCompressions []OfferedCompression
 	// The number of concurrent HTTP requests is limited to
 	// MaxRequestsInFlight. Additional requests are responded to with 503
 	// Service Unavailable and a suitable message in the body. If
@@ -348,14 +511,31 @@ type HandlerOpts struct {
 	MaxRequestsInFlight int
 	// If handling a request takes longer than Timeout, it is responded to
 	// with 503 ServiceUnavailable and a suitable Message. No timeout is
-	// applied if Timeout is 0 or negative. Note that with the current
-	// implementation, reaching the timeout simply ends the HTTP requests as
-	// described above (and even that only if sending of the body hasn't
-	// started yet), while the bulk work of gathering all the metrics keeps
-	// running in the background (with the eventual result to be thrown
-	// away). Until the implementation is improved, it is recommended to
-	// implement a separate timeout in potentially slow Collectors.
+	// applied if Timeout is 0 or negative.
+	//
+	// Additionally, a deadline is set on the context of each request and
+	// thereby passed on to the collection (see
+	// HandlerForTransactional). If the request has a valid
+	// X-Prometheus-Scrape-Timeout-Seconds header (which the Prometheus
+	// server sets to the scrape timeout), the deadline is derived from the
+	// header, reduced by ScrapeTimeoutOffset and capped by Timeout (if
+	// positive). Otherwise, the deadline is derived from Timeout (if
+	// positive). A prometheus.Registry skips Collectors that are still
+	// collecting once the deadline is reached and reports them as errors,
+	// which are then handled according to ErrorHandling (in particular,
+	// ContinueOnError still serves the metrics of all other Collectors).
+	// Collectors implementing prometheus.ContextCollector can honor the
+	// deadline themselves. Other Collectors keep running in the
+	// background (with the eventual result to be thrown away), so it is
+	// still recommended to implement a separate timeout in potentially
+	// slow Collectors.
 	Timeout time.Duration
+	// ScrapeTimeoutOffset is subtracted from the timeout taken from the
+	// X-Prometheus-Scrape-Timeout-Seconds header (see Timeout) to leave
+	// time for encoding and sending the response before the Prometheus
+	// server gives up on the scrape. It is not applied if it is not smaller
+	// than the timeout from the header.
+	ScrapeTimeoutOffset time.Duration
 	// If true, the experimental OpenMetrics encoding is added to the
 	// possible options during content negotiation. Note that Prometheus
 	// 2.5.0+ will negotiate OpenMetrics as first priority. OpenMetrics is
@@ -366,19 +546,54 @@ type HandlerOpts struct {
 	// (which changes the identity of the resulting series on the Prometheus
 	// server).
 	EnableOpenMetrics bool
-}
-
-// gzipAccepted returns whether the client will accept gzip-encoded content.
-func gzipAccepted(header http.Header) bool {
-	a := header.Get(acceptEncodingHeader)
-	parts := strings.Split(a, ",")
-	for _, part := range parts {
-		part = strings.TrimSpace(part)
-		if part == "gzip" || strings.HasPrefix(part, "gzip;") {
-			return true
-		}
-	}
-	return false
+	// If EnableOpenMetricsTextCreatedSamples is true, the created timestamps
+	// of counters, histograms, and summaries are exposed as synthetic
+	// "_created" series in the OpenMetrics text format. They are always
+	// part of the protobuf format. Note that the additional series increase
+	// the size of the response and require rewriting the output of the
+	// encoder, which makes encoding affected metric families noticeably
+	// slower. It has no effect unless EnableOpenMetrics is true.
+	EnableOpenMetricsTextCreatedSamples bool
+	// If EnableFiltering is true, the handler only serves the metrics
+	// selected by the "name[]" and "match[]" query parameters of the
+	// request (if any). Each "name[]" parameter selects a metric family by
+	// its exact name. Each "match[]" parameter is a series selector as
+	// known from PromQL, e.g. `http_requests_total{code=~"5.."}` or
+	// `{__name__=~"go_.*"}`. A metric is served if it is selected by any of
+	// the parameters. Requests with invalid selectors are responded to
+	// with 400 Bad Request. Note that the filtering happens after
+	// gathering, i.e. it saves encoding and transfer, but not collection.
+	// See prometheus.MetricFilter for details.
+	EnableFiltering bool
+	// If CacheDuration is positive, the handler caches each encoded (and
+	// compressed) response and reuses it for CacheDuration to respond to
+	// requests negotiating the same format and content encoding (and, if
+	// EnableFiltering is true, using the same filter parameters).
+	// Concurrent requests arriving while such a response is still being
+	// created wait for it instead of gathering and encoding the metrics
+	// again. This considerably reduces the load caused by many scrapers
+	// (e.g. in HA setups), at the price of serving metrics that are up to
+	// CacheDuration old. If the gatherer implements
+	// prometheus.GenerationProvider (as prometheus.Registry does), a cached
+	// response is not reused anymore once Collectors have been registered
+	// or unregistered. Responses for which any error was encountered are
+	// never reused. Note that Collectors looking at the request (see
+	// RequestFromContext) only see the request that caused the cached
+	// response to be created.
+	CacheDuration time.Duration
+	// If EnableStreaming is true and the gatherer implements
+	// prometheus.StreamingGatherer (as the gatherer created by HandlerFor
+	// does), each metric family is encoded and sent as soon as it has been
+	// gathered completely, rather than gathering all metric families
+	// before sending the first one. This bounds the memory needed per
+	// request for gatherers exposing a large number of metrics, see
+	// prometheus.Registry.GatherStream for details. However, most gathering
+	// errors are only known once sending the body has started. In that
+	// case, an HTTP error cannot be sent anymore, and with HTTPErrorOnError,
+	// the handler merely stops sending (which in particular omits the
+	// final "# EOF" line of OpenMetrics, marking the response as
+	// incomplete).
+	EnableStreaming bool
 }
 
 // httpError removes any content-encoding header and then calls http.Error with
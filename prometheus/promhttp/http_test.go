@@ -15,9 +15,11 @@ package promhttp
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -25,6 +27,7 @@ import (
 	"time"
 
 	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -42,6 +45,19 @@ func (e errorCollector) Collect(ch chan<- prometheus.Metric) {
 	)
 }
 
+// constCollector collects the provided metrics.
+type constCollector []prometheus.Metric
+
+func (c constCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c constCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c {
+		ch <- m
+	}
+}
+
 type blockingCollector struct {
 	CollectStarted, Block chan struct{}
 }
@@ -331,3 +347,534 @@ func TestHandlerTimeout(t *testing.T) {
 
 	close(c.Block) // To not leak a goroutine.
 }
+
+func TestHandlerFiltering(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	cnt := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "requests_total",
+		Help: "Total requests.",
+	}, []string{"code"})
+	cnt.WithLabelValues("200").Inc()
+	cnt.WithLabelValues("500").Inc()
+	gge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "temperature",
+		Help: "Current temperature.",
+	})
+	reg.MustRegister(cnt, gge)
+
+	handler := HandlerFor(reg, HandlerOpts{EnableFiltering: true})
+
+	for _, tc := range []struct {
+		query    string
+		wantCode int
+		want     []string
+		notWant  []string
+	}{
+		{
+			query:    "",
+			wantCode: http.StatusOK,
+			want:     []string{`requests_total{code="200"}`, `requests_total{code="500"}`, "temperature"},
+		},
+		{
+			query:    "name[]=temperature",
+			wantCode: http.StatusOK,
+			want:     []string{"temperature"},
+			notWant:  []string{"requests_total"},
+		},
+		{
+			query:    `match[]=requests_total{code=~"5.."}`,
+			wantCode: http.StatusOK,
+			want:     []string{`requests_total{code="500"}`},
+			notWant:  []string{`requests_total{code="200"}`, "temperature"},
+		},
+		{
+			query:    `match[]={__name__=~"temp.*"}&match[]={code="200"}`,
+			wantCode: http.StatusOK,
+			want:     []string{`requests_total{code="200"}`, "temperature"},
+			notWant:  []string{`requests_total{code="500"}`},
+		},
+		{
+			query:    `match[]=requests_total{code=}`,
+			wantCode: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			request, _ := http.NewRequest("GET", "/?"+tc.query, nil)
+			request.Header.Add("Accept", "text/plain")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+
+			if got, want := w.Code, tc.wantCode; got != want {
+				t.Fatalf("got HTTP status code %d, want %d", got, want)
+			}
+			body := w.Body.String()
+			for _, s := range tc.want {
+				if !strings.Contains(body, s) {
+					t.Errorf("body %q does not contain %q", body, s)
+				}
+			}
+			for _, s := range tc.notWant {
+				if strings.Contains(body, s) {
+					t.Errorf("body %q unexpectedly contains %q", body, s)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerUnits(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	cnt := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sent_bytes_total",
+		Help: "Total bytes sent.",
+		Unit: "bytes",
+	})
+	gge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "temperature",
+		Help: "Current temperature.",
+	})
+	his := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "request_duration_seconds",
+		Help:    "Request duration.",
+		Unit:    "seconds",
+		Buckets: []float64{1},
+	})
+	reg.MustRegister(cnt, gge, his)
+
+	for _, tc := range []struct {
+		name    string
+		handler http.Handler
+	}{
+		{name: "registry", handler: HandlerFor(reg, HandlerOpts{EnableOpenMetrics: true})},
+		{name: "gatherers", handler: HandlerFor(prometheus.Gatherers{reg}, HandlerOpts{EnableOpenMetrics: true})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			request, _ := http.NewRequest("GET", "/", nil)
+			request.Header.Add("Accept", "application/openmetrics-text")
+			w := httptest.NewRecorder()
+			tc.handler.ServeHTTP(w, request)
+
+			want := `# HELP request_duration_seconds Request duration.
+# TYPE request_duration_seconds histogram
+# UNIT request_duration_seconds seconds
+request_duration_seconds_bucket{le="1.0"} 0
+request_duration_seconds_bucket{le="+Inf"} 0
+request_duration_seconds_sum 0.0
+request_duration_seconds_count 0
+# HELP sent_bytes Total bytes sent.
+# TYPE sent_bytes counter
+# UNIT sent_bytes bytes
+sent_bytes_total 0.0
+# HELP temperature Current temperature.
+# TYPE temperature gauge
+temperature 0.0
+# EOF
+`
+			if got := w.Body.String(); got != want {
+				t.Errorf("got body\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+
+	// The text format has no units.
+	request, _ := http.NewRequest("GET", "/", nil)
+	request.Header.Add("Accept", "text/plain")
+	w := httptest.NewRecorder()
+	HandlerFor(reg, HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(w, request)
+	if body := w.Body.String(); strings.Contains(body, "# UNIT") {
+		t.Errorf("text format body %q unexpectedly contains units", body)
+	}
+}
+
+func TestHandlerInfoAndStateSet(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	info := prometheus.NewInfoVec(prometheus.InfoOpts{
+		Name: "build_info",
+		Help: "Build information.",
+	}, []string{"version"})
+	info.WithLabelValues("1.2.3")
+	stateSet := prometheus.NewStateSet(prometheus.StateSetOpts{
+		Name:   "connection_state",
+		Help:   "State of the connection.",
+		States: []string{"connected", "disconnected"},
+		Enum:   true,
+	})
+	reg.MustRegister(info, stateSet)
+
+	scrape := func(handler http.Handler, accept string) string {
+		request, _ := http.NewRequest("GET", "/", nil)
+		request.Header.Add("Accept", accept)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w.Body.String()
+	}
+
+	for _, tc := range []struct {
+		name    string
+		handler http.Handler
+	}{
+		{name: "registry", handler: HandlerFor(reg, HandlerOpts{EnableOpenMetrics: true})},
+		{name: "gatherers", handler: HandlerFor(prometheus.Gatherers{reg}, HandlerOpts{EnableOpenMetrics: true})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			want := `# HELP build Build information.
+# TYPE build info
+build_info{version="1.2.3"} 1.0
+# HELP connection_state State of the connection.
+# TYPE connection_state stateset
+connection_state{connection_state="connected"} 1.0
+connection_state{connection_state="disconnected"} 0.0
+# EOF
+`
+			if got := scrape(tc.handler, "application/openmetrics-text"); got != want {
+				t.Errorf("got body\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+
+	// The text format has neither info nor state set types.
+	want := `# HELP build_info Build information.
+# TYPE build_info gauge
+build_info{version="1.2.3"} 1
+# HELP connection_state State of the connection.
+# TYPE connection_state gauge
+connection_state{connection_state="connected"} 1
+connection_state{connection_state="disconnected"} 0
+`
+	if got := scrape(HandlerFor(reg, HandlerOpts{EnableOpenMetrics: true}), "text/plain"); got != want {
+		t.Errorf("got body\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHandlerCreatedTimestamps(t *testing.T) {
+	ct := time.Unix(1700000000, 500000000)
+	var (
+		cntDesc = prometheus.NewDesc("requests_total", "Total requests.", []string{"path"}, nil)
+		hisDesc = prometheus.NewDesc("request_duration_seconds", "Request duration.", nil, nil)
+		sumDesc = prometheus.NewDesc("response_size_bytes", "Response size.", nil, nil)
+		ggeDesc = prometheus.NewDesc("temperature", "Current temperature.", nil, nil)
+	)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(constCollector{
+		prometheus.MustNewConstMetricWithCreatedTimestamp(cntDesc, prometheus.CounterValue, 3, ct, "/a"),
+		prometheus.MustNewConstMetric(cntDesc, prometheus.CounterValue, 2, "/b"),
+		prometheus.MustNewConstMetricWithCreatedTimestamp(cntDesc, prometheus.CounterValue, 1, ct, `/"c"`),
+		prometheus.MustNewConstHistogramWithCreatedTimestamp(hisDesc, 1, 0.5, map[float64]uint64{1: 1}, ct),
+		prometheus.MustNewConstSummaryWithCreatedTimestamp(sumDesc, 1, 100, nil, ct),
+		prometheus.MustNewConstMetric(ggeDesc, prometheus.GaugeValue, 20),
+	})
+	handler := HandlerFor(reg, HandlerOpts{
+		EnableOpenMetrics:                   true,
+		EnableOpenMetricsTextCreatedSamples: true,
+	})
+
+	request, _ := http.NewRequest("GET", "/", nil)
+	request.Header.Add("Accept", "application/openmetrics-text")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+	want := `# HELP request_duration_seconds Request duration.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{le="1.0"} 1
+request_duration_seconds_bucket{le="+Inf"} 1
+request_duration_seconds_sum 0.5
+request_duration_seconds_count 1
+request_duration_seconds_created 1700000000.5
+# HELP requests Total requests.
+# TYPE requests counter
+requests_total{path="/\"c\""} 1.0
+requests_created{path="/\"c\""} 1700000000.5
+requests_total{path="/a"} 3.0
+requests_created{path="/a"} 1700000000.5
+requests_total{path="/b"} 2.0
+# HELP response_size_bytes Response size.
+# TYPE response_size_bytes summary
+response_size_bytes_sum 100.0
+response_size_bytes_count 1
+response_size_bytes_created 1700000000.5
+# HELP temperature Current temperature.
+# TYPE temperature gauge
+temperature 20.0
+# EOF
+`
+	if got := w.Body.String(); got != want {
+		t.Errorf("got body\n%s\nwant\n%s", got, want)
+	}
+
+	// The _created samples are opt-in.
+	request, _ = http.NewRequest("GET", "/", nil)
+	request.Header.Add("Accept", "application/openmetrics-text")
+	w = httptest.NewRecorder()
+	HandlerFor(reg, HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(w, request)
+	if body := w.Body.String(); strings.Contains(body, "_created") {
+		t.Errorf("body %q unexpectedly contains _created samples", body)
+	}
+
+	// The protobuf format has the created timestamps in the messages.
+	request, _ = http.NewRequest("GET", "/", nil)
+	request.Header.Add("Accept", "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+	dec := expfmt.NewDecoder(w.Body, expfmt.FmtProtoDelim)
+	mf := &dto.MetricFamily{}
+	if err := dec.Decode(mf); err != nil {
+		t.Fatal(err)
+	}
+	if got := mf.GetMetric()[0].GetHistogram().GetCreatedTimestamp().AsTime(); !got.Equal(ct) {
+		t.Errorf("got created timestamp %v, want %v", got, ct)
+	}
+}
+
+func TestHandlerGaugeHistogram(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	gh := prometheus.NewGaugeHistogram(prometheus.GaugeHistogramOpts{
+		Name:    "queue_age_seconds",
+		Help:    "Age of the queued items.",
+		Unit:    "seconds",
+		Buckets: []float64{1},
+	})
+	gh.Set(1, 2)
+	gh.Set(math.Inf(+1), 1)
+	gh.SetSum(7)
+	reg.MustRegister(gh)
+	handler := HandlerFor(reg, HandlerOpts{EnableOpenMetrics: true})
+
+	for _, tc := range []struct {
+		accept, want string
+	}{
+		{
+			accept: "application/openmetrics-text",
+			want: `# HELP queue_age_seconds Age of the queued items.
+# TYPE queue_age_seconds gaugehistogram
+# UNIT queue_age_seconds seconds
+queue_age_seconds_bucket{le="1.0"} 2
+queue_age_seconds_bucket{le="+Inf"} 3
+queue_age_seconds_gsum 7.0
+queue_age_seconds_gcount 3
+# EOF
+`,
+		},
+		{
+			accept: "text/plain",
+			want: `# HELP queue_age_seconds Age of the queued items.
+# TYPE queue_age_seconds histogram
+queue_age_seconds_bucket{le="1"} 2
+queue_age_seconds_bucket{le="+Inf"} 3
+queue_age_seconds_sum 7
+queue_age_seconds_count 3
+`,
+		},
+	} {
+		t.Run(tc.accept, func(t *testing.T) {
+			request, _ := http.NewRequest("GET", "/", nil)
+			request.Header.Add("Accept", tc.accept)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+			if got := w.Body.String(); got != tc.want {
+				t.Errorf("got body\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "foo", want: `[__name__="foo"]`},
+		{in: ` foo { a = "b" , c!~'d\'e', f=~` + "`g\\\\`" + `, h!="", } `, want: `[__name__="foo" a="b" c!~"d'e" f=~"g\\\\" h!=""]`},
+		{in: `{a="\"b\""}`, want: `[a="\"b\""]`},
+		{in: "", wantErr: true},
+		{in: "{}", wantErr: true},
+		{in: "foo{a}", wantErr: true},
+		{in: `foo{a="b"`, wantErr: true},
+		{in: `foo{a="b}`, wantErr: true},
+		{in: `foo{a=~"("}`, wantErr: true},
+		{in: `foo bar`, wantErr: true},
+	} {
+		got, err := parseSelector(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected error, got %v", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.in, err)
+			continue
+		}
+		if s := fmt.Sprint(got); s != tc.want {
+			t.Errorf("%q: got %s, want %s", tc.in, s, tc.want)
+		}
+	}
+}
+
+// requestCollector collects one gauge with a "target" label taken from the
+// query parameters of the request found in the context.
+type requestCollector struct {
+	desc *prometheus.Desc
+}
+
+func (c requestCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c requestCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectWithContext(context.Background(), ch)
+}
+
+func (c requestCollector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	target := "none"
+	if req, ok := RequestFromContext(ctx); ok {
+		target = req.URL.Query().Get("target")
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, target)
+}
+
+func TestHandlerPassesRequestContext(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(requestCollector{
+		desc: prometheus.NewDesc("target_info", "help", []string{"target"}, nil),
+	})
+
+	for name, handler := range map[string]http.Handler{
+		"plain":         HandlerFor(reg, HandlerOpts{}),
+		"transactional": HandlerForTransactional(prometheus.ToTransactionalGatherer(reg), HandlerOpts{}),
+	} {
+		w := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/?target=example.org", nil)
+		request.Header.Add("Accept", "text/plain")
+		handler.ServeHTTP(w, request)
+
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Errorf("%s: got HTTP status code %d, want %d", name, got, want)
+		}
+		if want := `target_info{target="example.org"} 1`; !strings.Contains(w.Body.String(), want) {
+			t.Errorf("%s: body %q does not contain %q", name, w.Body.String(), want)
+		}
+	}
+
+	// A non-context gatherer still works, without a request in the context.
+	w := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/?target=example.org", nil)
+	request.Header.Add("Accept", "text/plain")
+	HandlerForTransactional(&mockTransactionGatherer{g: reg}, HandlerOpts{}).ServeHTTP(w, request)
+	if want := `target_info{target="none"} 1`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("body %q does not contain %q", w.Body.String(), want)
+	}
+}
+
+func TestHandlerStreaming(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	cnt := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "requests_total",
+		Help: "Total requests.",
+	}, []string{"code"})
+	cnt.WithLabelValues("500").Inc()
+	cnt.WithLabelValues("200").Inc()
+	reg.MustRegister(cnt, prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "temperature",
+		Help: "Current temperature.",
+	}))
+
+	for _, accept := range []string{"text/plain", "application/openmetrics-text"} {
+		scrape := func(opts HandlerOpts) string {
+			opts.EnableOpenMetrics = true
+			request, _ := http.NewRequest("GET", "/?name[]=temperature", nil)
+			request.Header.Add("Accept", accept)
+			request.Header.Add(acceptEncodingHeader, "gzip")
+			w := httptest.NewRecorder()
+			HandlerFor(reg, opts).ServeHTTP(w, request)
+			if got, want := w.Code, http.StatusOK; got != want {
+				t.Fatalf("got HTTP status code %d, want %d", got, want)
+			}
+			return w.Body.String()
+		}
+		if got, want := scrape(HandlerOpts{EnableStreaming: true}), scrape(HandlerOpts{}); got != want {
+			t.Errorf("Accept %q: got streamed body %q, want %q", accept, got, want)
+		}
+		if got, want := scrape(HandlerOpts{EnableStreaming: true, EnableFiltering: true}), scrape(HandlerOpts{EnableFiltering: true}); got != want {
+			t.Errorf("Accept %q: got filtered streamed body %q, want %q", accept, got, want)
+		}
+	}
+
+	// Errors gathered after the first metric family has been sent cannot
+	// result in an HTTP error anymore.
+	reg.MustRegister(errorCollector{})
+	request, _ := http.NewRequest("GET", "/", nil)
+	request.Header.Add("Accept", "application/openmetrics-text")
+	w := httptest.NewRecorder()
+	HandlerFor(reg, HandlerOpts{EnableStreaming: true, EnableOpenMetrics: true}).ServeHTTP(w, request)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("got HTTP status code %d, want %d", got, want)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "temperature") || strings.Contains(body, "# EOF") {
+		t.Errorf("got body %q, want truncated body without # EOF", body)
+	}
+}
+
+func TestHandlerScrapeTimeoutHeader(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	gge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "temperature",
+		Help: "Current temperature.",
+	})
+	c := blockingCollector{Block: make(chan struct{}), CollectStarted: make(chan struct{}, 1)}
+	defer close(c.Block)
+	reg.MustRegister(gge, c)
+	handler := HandlerFor(reg, HandlerOpts{
+		ErrorHandling:       ContinueOnError,
+		ScrapeTimeoutOffset: 100 * time.Millisecond,
+	})
+
+	request, _ := http.NewRequest("GET", "/", nil)
+	request.Header.Add("Accept", "text/plain")
+	request.Header.Add(scrapeTimeoutHeader, "0.2")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(w, request)
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("handling the request took %v, want approx. 100ms", elapsed)
+	}
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("got HTTP status code %d, want %d", got, want)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "temperature 0") {
+		t.Errorf("body %q does not contain the metrics of the fast collector", body)
+	}
+}
+
+func TestHandlerTimeoutFromHeader(t *testing.T) {
+	for _, tc := range []struct {
+		header      string
+		timeout     time.Duration
+		offset      time.Duration
+		wantTimeout time.Duration
+	}{
+		{header: "", timeout: 0, wantTimeout: 0},
+		{header: "", timeout: time.Second, wantTimeout: time.Second},
+		{header: "10", timeout: 0, wantTimeout: 10 * time.Second},
+		{header: "10", offset: time.Second, wantTimeout: 9 * time.Second},
+		{header: "0.5", offset: time.Second, wantTimeout: 500 * time.Millisecond},
+		{header: "10", timeout: 5 * time.Second, offset: time.Second, wantTimeout: 5 * time.Second},
+		{header: "2", timeout: 5 * time.Second, offset: time.Second, wantTimeout: time.Second},
+		{header: "-1", timeout: 5 * time.Second, wantTimeout: 5 * time.Second},
+		{header: "invalid", timeout: 0, wantTimeout: 0},
+		{header: "NaN", timeout: 5 * time.Second, wantTimeout: 5 * time.Second},
+		{header: "Inf", timeout: 5 * time.Second, wantTimeout: 5 * time.Second},
+		{header: "+Inf", timeout: 0, wantTimeout: 0},
+		{header: "1e300", timeout: 5 * time.Second, wantTimeout: 5 * time.Second},
+		{header: "1e10", timeout: 0, wantTimeout: 0},
+	} {
+		header := http.Header{}
+		if tc.header != "" {
+			header.Set(scrapeTimeoutHeader, tc.header)
+		}
+		got := handlerTimeout(header, HandlerOpts{Timeout: tc.timeout, ScrapeTimeoutOffset: tc.offset})
+		if got != tc.wantTimeout {
+			t.Errorf("header %q, timeout %v, offset %v: got %v, want %v", tc.header, tc.timeout, tc.offset, got, tc.wantTimeout)
+		}
+	}
+}
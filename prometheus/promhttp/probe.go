@@ -0,0 +1,185 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promhttp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeTimeoutHeader is set by the Prometheus server to the scrape timeout in
+// seconds.
+const scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+
+// ProbeFunc probes the provided target and returns a Gatherer exposing the
+// results of the probe. params are the query parameters of the probe request
+// (including the "target" parameter), which allows to configure the probe, e.g.
+// by a "module" parameter. The provided context is canceled once the timeout of
+// the probe is reached, and it carries the request (see RequestFromContext).
+//
+// A failed probe is reported by returning an error. If a Gatherer is returned
+// along with the error, it is still gathered from.
+type ProbeFunc func(ctx context.Context, target string, params url.Values) (prometheus.Gatherer, error)
+
+// ProbeHandlerOpts specifies options how to serve probes via an http.Handler
+// created by ProbeHandler. The zero value of ProbeHandlerOpts is a reasonable
+// default.
+type ProbeHandlerOpts struct {
+	// HandlerOpts configure how the results of a probe are served, in the
+	// same way as for HandlerFor. However, Timeout, ScrapeTimeoutOffset,
+	// MaxRequestsInFlight, CacheDuration, and EnableStreaming are ignored.
+	// The ErrorLog is also used to log failed probes.
+	HandlerOpts HandlerOpts
+	// If AllowTarget is not nil, it is called with the target of each
+	// request, and requests for targets for which it returns false are
+	// responded to with 403 Forbidden without probing the target.
+	AllowTarget func(target string) bool
+	// DefaultTimeout is the timeout of a probe if the request has no valid
+	// X-Prometheus-Scrape-Timeout-Seconds header (which the Prometheus
+	// server sets to the scrape timeout). No timeout is applied if
+	// DefaultTimeout is 0 or negative.
+	DefaultTimeout time.Duration
+	// TimeoutOffset is subtracted from the timeout taken from the
+	// X-Prometheus-Scrape-Timeout-Seconds header to leave time for
+	// sending the response before the scrape times out. It is not applied
+	// if it is not smaller than the timeout from the header.
+	TimeoutOffset time.Duration
+}
+
+// ProbeHandler returns an http.Handler for multi-target exporters in the style
+// of the blackbox exporter, which probe the target provided in the "target"
+// query parameter of each request (as in "/probe?target=example.org") and
+// expose the results. For each request, the provided ProbeFunc is called, and
+// the metrics of the returned Gatherer are served together with the following
+// metrics:
+//   - "probe_success", a gauge that is 1 if the probe was successful and 0
+//     otherwise.
+//   - "probe_duration_seconds", a gauge with the duration of the probe in
+//     seconds (including gathering from the returned Gatherer).
+//
+// A failed probe does not result in an HTTP error, but only in a
+// "probe_success" of 0. Requests without a target are responded to with 400 Bad
+// Request.
+func ProbeHandler(probe ProbeFunc, opts ProbeHandlerOpts) http.Handler {
+	e := newExposer(opts.HandlerOpts)
+
+	return http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+		params := req.URL.Query()
+		target := params.Get("target")
+		if target == "" {
+			http.Error(rsp, "Target parameter is missing.", http.StatusBadRequest)
+			return
+		}
+		if opts.AllowTarget != nil && !opts.AllowTarget(target) {
+			http.Error(rsp, fmt.Sprintf("Target %q is not allowed.", target), http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), requestContextKey{}, req)
+		if timeout := probeTimeout(req.Header, opts); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		var (
+			probeSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "probe_success",
+				Help: "Whether the probe was successful.",
+			})
+			probeDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "probe_duration_seconds",
+				Help: "Duration of the probe in seconds.",
+			})
+			probeRegistry = prometheus.NewRegistry()
+		)
+		probeRegistry.MustRegister(probeSuccess, probeDuration)
+
+		start := time.Now()
+		mfs, err := runProbe(ctx, probe, target, params)
+		probeDuration.Set(time.Since(start).Seconds())
+		if err != nil {
+			if opts.HandlerOpts.ErrorLog != nil {
+				opts.HandlerOpts.ErrorLog.Println("probe of target", target, "failed:", err)
+			}
+		} else {
+			probeSuccess.Set(1)
+		}
+
+		e.serve(rsp, req, prometheus.ToTransactionalGatherer(prometheus.Gatherers{
+			probeRegistry,
+			prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return mfs, nil }),
+		}))
+	})
+}
+
+// runProbe calls probe and gathers from the returned Gatherer with the provided
+// context (if the Gatherer implements prometheus.ContextGatherer).
+func runProbe(ctx context.Context, probe ProbeFunc, target string, params url.Values) ([]*dto.MetricFamily, error) {
+	g, err := probe(ctx, target, params)
+	if g == nil {
+		return nil, err
+	}
+	var mfs []*dto.MetricFamily
+	var gatherErr error
+	if cg, ok := g.(prometheus.ContextGatherer); ok {
+		mfs, gatherErr = cg.GatherWithContext(ctx)
+	} else {
+		mfs, gatherErr = g.Gather()
+	}
+	if err == nil {
+		err = gatherErr
+	}
+	return mfs, err
+}
+
+// probeTimeout returns the timeout for a probe requested with the provided
+// header.
+func probeTimeout(header http.Header, opts ProbeHandlerOpts) time.Duration {
+	if timeout, ok := scrapeTimeout(header, opts.TimeoutOffset); ok {
+		return timeout
+	}
+	return opts.DefaultTimeout
+}
+
+// scrapeTimeout returns the timeout from the X-Prometheus-Scrape-Timeout-Seconds
+// header, reduced by the provided offset unless the offset is not smaller than
+// the timeout. The second return value is false if the header is missing or
+// invalid, or if the timeout is not positive or too large for a time.Duration.
+func scrapeTimeout(header http.Header, offset time.Duration) (time.Duration, bool) {
+	v := header.Get(scrapeTimeoutHeader)
+	if v == "" {
+		return 0, false
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	// Reject NaN and values that don't fit into a time.Duration (including
+	// +Inf), which would overflow in the conversion below.
+	if err != nil || !(seconds > 0) || seconds >= math.MaxInt64/float64(time.Second) {
+		return 0, false
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > offset {
+		timeout -= offset
+	}
+	return timeout, true
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promhttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestProbeHandler(t *testing.T) {
+	var (
+		gotTarget   string
+		gotModule   string
+		gotDeadline time.Duration
+	)
+	probe := func(ctx context.Context, target string, params url.Values) (prometheus.Gatherer, error) {
+		gotTarget, gotModule = target, params.Get("module")
+		gotDeadline = 0
+		if deadline, ok := ctx.Deadline(); ok {
+			gotDeadline = time.Until(deadline)
+		}
+		if _, ok := RequestFromContext(ctx); !ok {
+			t.Error("request not found in context")
+		}
+		reg := prometheus.NewRegistry()
+		up := prometheus.NewGauge(prometheus.GaugeOpts{Name: "target_up", Help: "Whether the target is up."})
+		reg.MustRegister(up)
+		if target == "down.example.org" {
+			return reg, errors.New("target down")
+		}
+		up.Set(1)
+		return reg, nil
+	}
+	handler := ProbeHandler(probe, ProbeHandlerOpts{
+		AllowTarget:    func(target string) bool { return strings.HasSuffix(target, ".example.org") },
+		DefaultTimeout: time.Minute,
+		TimeoutOffset:  500 * time.Millisecond,
+	})
+
+	scrape := func(query, scrapeTimeout string) (int, string) {
+		request, _ := http.NewRequest("GET", "/probe?"+query, nil)
+		request.Header.Add("Accept", "text/plain")
+		if scrapeTimeout != "" {
+			request.Header.Add(scrapeTimeoutHeader, scrapeTimeout)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w.Code, w.Body.String()
+	}
+
+	code, body := scrape("target=up.example.org&module=http", "10")
+	if code != http.StatusOK {
+		t.Fatalf("got HTTP status code %d, want %d", code, http.StatusOK)
+	}
+	for _, want := range []string{"probe_success 1", "probe_duration_seconds ", "target_up 1"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body %q does not contain %q", body, want)
+		}
+	}
+	if gotTarget != "up.example.org" || gotModule != "http" {
+		t.Errorf("got target %q and module %q", gotTarget, gotModule)
+	}
+	if gotDeadline > 9500*time.Millisecond || gotDeadline < 9*time.Second {
+		t.Errorf("got deadline in %v, want approx. 9.5s", gotDeadline)
+	}
+
+	code, body = scrape("target=down.example.org", "invalid")
+	if code != http.StatusOK {
+		t.Fatalf("got HTTP status code %d, want %d", code, http.StatusOK)
+	}
+	for _, want := range []string{"probe_success 0", "target_up 0"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body %q does not contain %q", body, want)
+		}
+	}
+	if gotDeadline > time.Minute || gotDeadline < 59*time.Second {
+		t.Errorf("got deadline in %v, want approx. the default timeout", gotDeadline)
+	}
+
+	if code, _ := scrape("", ""); code != http.StatusBadRequest {
+		t.Errorf("got HTTP status code %d for missing target, want %d", code, http.StatusBadRequest)
+	}
+	gotTarget = ""
+	if code, _ := scrape("target=evil.example.com", ""); code != http.StatusForbidden {
+		t.Errorf("got HTTP status code %d for disallowed target, want %d", code, http.StatusForbidden)
+	}
+	if gotTarget != "" {
+		t.Errorf("disallowed target %q was probed", gotTarget)
+	}
+}
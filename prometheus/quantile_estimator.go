@@ -0,0 +1,309 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/beorn7/perks/quantile"
+)
+
+// QuantileEstimator estimates quantiles of a stream of observations. It is used
+// by a Summary to calculate the quantiles in its Objectives. A Summary
+// serializes all calls of the methods of the QuantileEstimators it uses, so
+// implementations don't need to be safe for concurrent use.
+//
+// The *Stream type of the github.com/beorn7/perks/quantile package implements
+// QuantileEstimator.
+type QuantileEstimator interface {
+	// Insert adds an observation.
+	Insert(v float64)
+	// Query returns the estimated value of the q-quantile (0 ≤ q ≤ 1) of
+	// the observations inserted since the last Reset. It is only called
+	// if Count returns a value > 0.
+	Query(q float64) float64
+	// Count returns the number of observations inserted since the last
+	// Reset.
+	Count() int
+	// Reset discards all observations.
+	Reset()
+}
+
+// QuantileEstimatorFactory creates a new QuantileEstimator for the provided
+// objectives (see SummaryOpts.Objectives). A Summary calls it once for each of
+// its age buckets.
+type QuantileEstimatorFactory func(objectives map[float64]float64) QuantileEstimator
+
+// CKMSQuantileEstimator is the QuantileEstimatorFactory used by a Summary if no
+// other one is configured. It creates targeted quantile streams as provided by
+// the github.com/beorn7/perks/quantile package, which implements the algorithm
+// by Cormode, Korn, Muthukrishnan, and Srivastava. The streams honor the
+// allowed error of each objective, but their memory and CPU usage grows
+// considerably with the number of objectives (and with lower allowed errors).
+func CKMSQuantileEstimator(objectives map[float64]float64) QuantileEstimator {
+	return quantile.NewTargeted(objectives)
+}
+
+// DDSketchQuantileEstimator returns a QuantileEstimatorFactory creating
+// estimators that work like a DDSketch: Observations are counted in buckets
+// with exponentially growing widths, so that each estimated quantile value is
+// within the provided relative error of the true quantile value (e.g. within
+// ±1% for a relativeError of 0.01). The allowed errors of the objectives are
+// ignored. The memory usage grows only logarithmically with the range of the
+// observed values (about 1,000 buckets for values from 1ms to 1,000,000s with
+// a relative error of 1%), and inserting an observation is cheap. Zero is
+// counted exactly, and observations of ±Inf are treated as ±math.MaxFloat64.
+// NaN observations are ignored.
+//
+// DDSketchQuantileEstimator panics if relativeError is not between 0 and 1
+// (both exclusive).
+func DDSketchQuantileEstimator(relativeError float64) QuantileEstimatorFactory {
+	if !(relativeError > 0 && relativeError < 1) {
+		panic(fmt.Errorf("relative error for DDSketchQuantileEstimator must be between 0 and 1, got %v", relativeError))
+	}
+	gamma := (1 + relativeError) / (1 - relativeError)
+	return func(map[float64]float64) QuantileEstimator {
+		return &ddSketch{
+			gamma:    gamma,
+			logGamma: math.Log(gamma),
+			positive: map[int]uint64{},
+			negative: map[int]uint64{},
+		}
+	}
+}
+
+// ddSketch is the QuantileEstimator created by DDSketchQuantileEstimator. The
+// bucket with key i counts observations with an absolute value in
+// (gamma^(i-1), gamma^i].
+type ddSketch struct {
+	gamma, logGamma    float64
+	positive, negative map[int]uint64
+	zero               uint64
+	count              int
+}
+
+func (d *ddSketch) Insert(v float64) {
+	switch {
+	case math.IsNaN(v):
+		return
+	case v > 0:
+		d.positive[d.key(v)]++
+	case v < 0:
+		d.negative[d.key(-v)]++
+	default:
+		d.zero++
+	}
+	d.count++
+}
+
+// key returns the key of the bucket for the provided positive value.
+func (d *ddSketch) key(v float64) int {
+	if v > math.MaxFloat64 {
+		v = math.MaxFloat64
+	}
+	return int(math.Ceil(math.Log(v) / d.logGamma))
+}
+
+// value returns the estimate for all values in the bucket with the provided
+// key, which is within the relative error of all of them.
+func (d *ddSketch) value(key int) float64 {
+	return 2 * math.Pow(d.gamma, float64(key)) / (d.gamma + 1)
+}
+
+func (d *ddSketch) Query(q float64) float64 {
+	rank := uint64(q * float64(d.count-1))
+	// Negative observations first, with the largest absolute value first.
+	keys := sortedKeys(d.negative)
+	for i := len(keys) - 1; i >= 0; i-- {
+		c := d.negative[keys[i]]
+		if rank < c {
+			return -d.value(keys[i])
+		}
+		rank -= c
+	}
+	if rank < d.zero {
+		return 0
+	}
+	rank -= d.zero
+	keys = sortedKeys(d.positive)
+	for _, k := range keys {
+		c := d.positive[k]
+		if rank < c {
+			return d.value(k)
+		}
+		rank -= c
+	}
+	// Only reached because of floating point inaccuracies.
+	return d.value(keys[len(keys)-1])
+}
+
+func (d *ddSketch) Count() int {
+	return d.count
+}
+
+func (d *ddSketch) Reset() {
+	d.positive = map[int]uint64{}
+	d.negative = map[int]uint64{}
+	d.zero = 0
+	d.count = 0
+}
+
+func sortedKeys(m map[int]uint64) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// TDigestQuantileEstimator returns a QuantileEstimatorFactory creating
+// estimators that work like a merging t-digest: Observations are clustered
+// into centroids, which are kept small close to the extreme quantiles and get
+// larger towards the median. The compression parameter limits the number of
+// centroids to roughly 2×compression. Higher values result in more accurate
+// estimates at the cost of more memory and CPU usage. 100 is a common choice.
+// The allowed errors of the objectives are ignored. t-digests are particularly
+// accurate for extreme quantiles (like 0.001 or 0.999). NaN observations are
+// ignored.
+//
+// TDigestQuantileEstimator panics if compression is less than 10.
+func TDigestQuantileEstimator(compression float64) QuantileEstimatorFactory {
+	if !(compression >= 10) {
+		panic(fmt.Errorf("compression for TDigestQuantileEstimator must be at least 10, got %v", compression))
+	}
+	return func(map[float64]float64) QuantileEstimator {
+		return &tDigest{
+			compression: compression,
+			buffer:      make([]centroid, 0, int(5*compression)),
+		}
+	}
+}
+
+// centroid is a cluster of observations in a tDigest.
+type centroid struct {
+	mean, weight float64
+}
+
+// tDigest is the QuantileEstimator created by TDigestQuantileEstimator.
+// Observations are collected in buffer and merged into the (sorted)
+// centroids once the buffer is full or a quantile is queried.
+type tDigest struct {
+	compression float64
+	centroids   []centroid
+	buffer      []centroid
+	count       int
+	min, max    float64
+}
+
+func (t *tDigest) Insert(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	if t.count == 0 || v < t.min {
+		t.min = v
+	}
+	if t.count == 0 || v > t.max {
+		t.max = v
+	}
+	t.count++
+	t.buffer = append(t.buffer, centroid{mean: v, weight: 1})
+	if len(t.buffer) == cap(t.buffer) {
+		t.merge()
+	}
+}
+
+// merge merges the buffer into the centroids.
+func (t *tDigest) merge() {
+	if len(t.buffer) == 0 {
+		return
+	}
+	all := append(t.buffer, t.centroids...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+	total := float64(t.count)
+
+	merged := make([]centroid, 0, len(t.centroids)+1)
+	cur := all[0]
+	var weightSoFar float64
+	limit := t.maxQuantile(0)
+	for _, c := range all[1:] {
+		if (weightSoFar+cur.weight+c.weight)/total <= limit {
+			cur.weight += c.weight
+			cur.mean += (c.mean - cur.mean) * c.weight / cur.weight
+			continue
+		}
+		weightSoFar += cur.weight
+		merged = append(merged, cur)
+		limit = t.maxQuantile(weightSoFar / total)
+		cur = c
+	}
+	t.centroids = append(merged, cur)
+	t.buffer = t.buffer[:0]
+}
+
+// maxQuantile returns the largest quantile a centroid starting at quantile q
+// may extend to, according to the k₁ scale function of the t-digest paper,
+// k(q) = compression / 2π × asin(2q - 1).
+func (t *tDigest) maxQuantile(q float64) float64 {
+	k := t.compression / (2 * math.Pi) * math.Asin(2*q-1)
+	k++
+	if k >= t.compression/4 {
+		return 1
+	}
+	return (math.Sin(k*2*math.Pi/t.compression) + 1) / 2
+}
+
+func (t *tDigest) Query(q float64) float64 {
+	t.merge()
+	switch {
+	case q <= 0:
+		return t.min
+	case q >= 1:
+		return t.max
+	case len(t.centroids) == 1:
+		return t.centroids[0].mean
+	}
+	// Interpolate linearly between the centers of the centroids (and
+	// between min and max and the first and last centroid, respectively).
+	rank := q * float64(t.count)
+	first, last := t.centroids[0], t.centroids[len(t.centroids)-1]
+	if rank < first.weight/2 {
+		return t.min + (first.mean-t.min)*rank/(first.weight/2)
+	}
+	if rank > float64(t.count)-last.weight/2 {
+		return last.mean + (t.max-last.mean)*(rank-float64(t.count)+last.weight/2)/(last.weight/2)
+	}
+	center := first.weight / 2
+	for i := 1; i < len(t.centroids); i++ {
+		prev, c := t.centroids[i-1], t.centroids[i]
+		nextCenter := center + prev.weight/2 + c.weight/2
+		if rank <= nextCenter {
+			return prev.mean + (c.mean-prev.mean)*(rank-center)/(nextCenter-center)
+		}
+		center = nextCenter
+	}
+	return last.mean // Only reached because of floating point inaccuracies.
+}
+
+func (t *tDigest) Count() int {
+	return t.count
+}
+
+func (t *tDigest) Reset() {
+	t.centroids = t.centroids[:0]
+	t.buffer = t.buffer[:0]
+	t.count = 0
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestQuantileEstimators(t *testing.T) {
+	objectives := map[float64]float64{0.01: 0.001, 0.5: 0.05, 0.9: 0.01, 0.99: 0.001, 0.999: 0.0001}
+	rng := rand.New(rand.NewSource(42))
+	values := make([]float64, 100000)
+	for i := range values {
+		values[i] = rng.ExpFloat64()*100 - 10 // Some negative values, too.
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	scenarios := []struct {
+		name    string
+		factory QuantileEstimatorFactory
+		// Whether the error is relative to the value or to the rank.
+		relativeError, rankError float64
+	}{
+		{name: "CKMS", factory: CKMSQuantileEstimator, rankError: 0.05},
+		{name: "DDSketch", factory: DDSketchQuantileEstimator(0.01), relativeError: 0.01},
+		{name: "t-digest", factory: TDigestQuantileEstimator(100), rankError: 0.005},
+	}
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			e := s.factory(objectives)
+			for i := 0; i < 2; i++ { // Second round after Reset.
+				for _, v := range values {
+					e.Insert(v)
+				}
+				e.Insert(math.NaN())
+				if got, want := e.Count(), len(values); s.name != "CKMS" && got != want {
+					t.Errorf("got count %d, want %d", got, want)
+				}
+				for q := range objectives {
+					got := e.Query(q)
+					if s.relativeError > 0 {
+						want := sorted[int(q*float64(len(sorted)-1))]
+						if math.Abs(got-want) > s.relativeError*math.Abs(want) {
+							t.Errorf("quantile %v: got %v, want %v ± %v%%", q, got, want, 100*s.relativeError)
+						}
+						continue
+					}
+					rank := float64(sort.SearchFloat64s(sorted, got)) / float64(len(sorted))
+					if math.Abs(rank-q) > s.rankError {
+						t.Errorf("quantile %v: got %v with rank %v", q, got, rank)
+					}
+				}
+				e.Reset()
+				if got := e.Count(); got != 0 {
+					t.Errorf("got count %d after reset, want 0", got)
+				}
+			}
+		})
+	}
+}
+
+func TestDDSketchQuantileEstimatorZero(t *testing.T) {
+	e := DDSketchQuantileEstimator(0.01)(nil)
+	for _, v := range []float64{-1, 0, 0, 0, math.Inf(1)} {
+		e.Insert(v)
+	}
+	if got := e.Query(0); got > -0.99 || got < -1.01 {
+		t.Errorf("got %v for quantile 0, want approx. -1", got)
+	}
+	if got := e.Query(0.5); got != 0 {
+		t.Errorf("got %v for quantile 0.5, want 0", got)
+	}
+	if got := e.Query(1); got < math.MaxFloat64/1.01 {
+		t.Errorf("got %v for quantile 1, want approx. math.MaxFloat64", got)
+	}
+}
+
+func TestQuantileEstimatorValidation(t *testing.T) {
+	assertPanics(t, func() { DDSketchQuantileEstimator(0) }, "relative error 0")
+	assertPanics(t, func() { DDSketchQuantileEstimator(1) }, "relative error 1")
+	assertPanics(t, func() { TDigestQuantileEstimator(5) }, "compression 5")
+}
+
+func TestSummaryWithQuantileEstimator(t *testing.T) {
+	for name, factory := range map[string]QuantileEstimatorFactory{
+		"DDSketch": DDSketchQuantileEstimator(0.01),
+		"t-digest": TDigestQuantileEstimator(100),
+	} {
+		s := NewSummary(SummaryOpts{
+			Name:              "test",
+			Help:              "test help",
+			Objectives:        map[float64]float64{0.5: 0.05, 0.99: 0.001},
+			QuantileEstimator: factory,
+		})
+		for i := 1; i <= 1000; i++ {
+			s.Observe(float64(i))
+		}
+		m := &dto.Metric{}
+		if err := s.Write(m); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := m.GetSummary().GetSampleCount(), uint64(1000); got != want {
+			t.Errorf("%s: got sample count %d, want %d", name, got, want)
+		}
+		for i, want := range []float64{500, 990} {
+			q := m.GetSummary().GetQuantile()[i]
+			if math.Abs(q.GetValue()-want) > 0.02*want {
+				t.Errorf("%s: got %v for quantile %v, want approx. %v", name, q.GetValue(), q.GetQuantile(), want)
+			}
+		}
+	}
+}
@@ -15,6 +15,7 @@ package prometheus
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -24,6 +25,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode/utf8"
 
 	"github.com/prometheus/client_golang/prometheus/internal"
@@ -69,6 +71,9 @@ func NewRegistry() *Registry {
 		collectorsByID:  map[uint64]Collector{},
 		descIDs:         map[uint64]struct{}{},
 		dimHashesByName: map[string]uint64{},
+		unitsByName:     map[string]string{},
+		typesByName:     map[string]string{},
+		namesByID:       map[uint64][]string{},
 	}
 }
 
@@ -186,6 +191,93 @@ func Unregister(c Collector) bool {
 	return DefaultRegisterer.Unregister(c)
 }
 
+// ContextGatherer is a Gatherer that can pass a context to the Collectors it
+// gathers from (see ContextCollector). Registry and Gatherers implement
+// ContextGatherer.
+type ContextGatherer interface {
+	Gatherer
+	// GatherWithContext works like Gather but passes on the provided
+	// context. Collection is aborted once the context is done.
+	GatherWithContext(ctx context.Context) ([]*dto.MetricFamily, error)
+}
+
+// gatherWithContext calls the GatherWithContext method of the provided
+// Gatherer if it implements ContextGatherer, or its Gather method otherwise.
+func gatherWithContext(ctx context.Context, g Gatherer) ([]*dto.MetricFamily, error) {
+	if cg, ok := g.(ContextGatherer); ok {
+		return cg.GatherWithContext(ctx)
+	}
+	return g.Gather()
+}
+
+// UnitProvider is implemented by Gatherers and TransactionalGatherers that know
+// the units of the metric families they gather (see the Unit field in
+// Opts). The expfmt package and the protobuf messages of the exposition formats
+// have no notion of units yet, so that units cannot be returned together with
+// the gathered metric families. Instead, exposition code (like the promhttp
+// package) can look them up via this interface. Registry, Gatherers,
+// MultiTRegistry, and the TransactionalGatherer returned by
+// ToTransactionalGatherer implement UnitProvider.
+type UnitProvider interface {
+	// Units returns a map from names of metric families to their
+	// units. Metric families without a unit are not included.
+	Units() map[string]string
+}
+
+// unitsOf returns the units of the provided Gatherer or TransactionalGatherer
+// if it implements UnitProvider, or nil otherwise.
+func unitsOf(g interface{}) map[string]string {
+	if up, ok := g.(UnitProvider); ok {
+		return up.Units()
+	}
+	return nil
+}
+
+// TypeProvider is implemented by Gatherers and TransactionalGatherers that know
+// the OpenMetrics types of the metric families they gather in case those types
+// cannot be represented by the protobuf messages of the exposition formats.
+// That is currently the case for the Info and StateSet types, which are
+// gathered as gauges. Exposition code (like the promhttp package) can look up
+// the actual types via this interface. Registry, Gatherers, MultiTRegistry, and
+// the TransactionalGatherer returned by ToTransactionalGatherer implement
+// TypeProvider.
+type TypeProvider interface {
+	// OpenMetricsTypes returns a map from names of metric families to
+	// their OpenMetrics types ("info" or "stateset"). Metric families
+	// whose type is represented by the gathered protobuf messages are not
+	// included.
+	OpenMetricsTypes() map[string]string
+}
+
+// openMetricsTypesOf returns the OpenMetrics types of the provided Gatherer or
+// TransactionalGatherer if it implements TypeProvider, or nil otherwise.
+func openMetricsTypesOf(g interface{}) map[string]string {
+	if tp, ok := g.(TypeProvider); ok {
+		return tp.OpenMetricsTypes()
+	}
+	return nil
+}
+
+// GenerationProvider is implemented by Gatherers and TransactionalGatherers that
+// can tell whether the set of Collectors they gather from has changed, e.g. to
+// invalidate cached expositions (as done by the promhttp package). Registry,
+// Gatherers, MultiTRegistry, and the TransactionalGatherer returned by
+// ToTransactionalGatherer implement GenerationProvider.
+type GenerationProvider interface {
+	// Generation returns a number that changes whenever a Collector is
+	// registered or unregistered.
+	Generation() uint64
+}
+
+// generationOf returns the generation of the provided Gatherer or
+// TransactionalGatherer if it implements GenerationProvider, or 0 otherwise.
+func generationOf(g interface{}) uint64 {
+	if gp, ok := g.(GenerationProvider); ok {
+		return gp.Generation()
+	}
+	return 0
+}
+
 // GathererFunc turns a function into a Gatherer.
 type GathererFunc func() ([]*dto.MetricFamily, error)
 
@@ -262,8 +354,67 @@ type Registry struct {
 	collectorsByID        map[uint64]Collector // ID is a hash of the descIDs.
 	descIDs               map[uint64]struct{}
 	dimHashesByName       map[string]uint64
+	unitsByName           map[string]string
+	typesByName           map[string]string   // OpenMetrics types, see TypeProvider.
+	namesByID             map[uint64][]string // Sorted metric names by collector ID.
 	uncheckedCollectors   []Collector
 	pedanticChecksEnabled bool
+	generation            uint64 // Incremented upon each (un)registration.
+
+	// collectorTimeout, collectorTimeouts, and collectorCancellations are
+	// only set once SetCollectorTimeout has been called.
+	collectorTimeout       time.Duration
+	collectorTimeouts      Counter
+	collectorCancellations Counter
+}
+
+// SetCollectorTimeout sets the maximum duration the Collect method of each
+// registered Collector may take during a Gather call. Collectors that take
+// longer are skipped, i.e. none of the metrics they have collected so far are
+// returned, while the metrics of all other Collectors are still returned. Each
+// skipped Collector results in an error (wrapping context.DeadlineExceeded) in
+// the returned MultiError. Note that the Collect method of the skipped
+// Collector keeps running in the background until it returns. (Collectors that
+// implement CollectWithContext get the chance to return early.) A timeout <= 0
+// disables the per-Collector timeout.
+//
+// Additionally, SetCollectorTimeout registers the counters
+// "prometheus_collector_timeouts_total" and
+// "prometheus_collector_cancellations_total" with the Registry. The former
+// counts the Collectors that have been skipped because they ran into the
+// timeout or the deadline of the context passed to GatherWithContext, the
+// latter the Collectors that have been skipped because that context was
+// canceled (e.g. because the client of a scrape went away). An error is
+// returned if the registration of the counters fails.
+func (r *Registry) SetCollectorTimeout(timeout time.Duration) error {
+	r.mtx.RLock()
+	registered := r.collectorTimeouts != nil
+	r.mtx.RUnlock()
+	if !registered {
+		timeouts := NewCounter(CounterOpts{
+			Name: "prometheus_collector_timeouts_total",
+			Help: "Total number of collectors skipped during gathering because they exceeded their deadline.",
+		})
+		cancellations := NewCounter(CounterOpts{
+			Name: "prometheus_collector_cancellations_total",
+			Help: "Total number of collectors skipped during gathering because the gathering was canceled.",
+		})
+		if err := r.Register(timeouts); err != nil {
+			return err
+		}
+		if err := r.Register(cancellations); err != nil {
+			r.Unregister(timeouts)
+			return err
+		}
+		r.mtx.Lock()
+		r.collectorTimeouts = timeouts
+		r.collectorCancellations = cancellations
+		r.mtx.Unlock()
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.collectorTimeout = timeout
+	return nil
 }
 
 // Register implements Registerer.
@@ -272,6 +423,9 @@ func (r *Registry) Register(c Collector) error {
 		descChan           = make(chan *Desc, capDescChan)
 		newDescIDs         = map[uint64]struct{}{}
 		newDimHashesByName = map[string]uint64{}
+		newUnitsByName     = map[string]string{}
+		newTypesByName     = map[string]string{}
+		newNames           = map[string]struct{}{}
 		collectorID        uint64 // All desc IDs XOR'd together.
 		duplicateDescErr   error
 	)
@@ -306,28 +460,43 @@ func (r *Registry) Register(c Collector) error {
 			newDescIDs[desc.id] = struct{}{}
 			collectorID ^= desc.id
 		}
+		newNames[desc.fqName] = struct{}{}
 
-		// Are all the label names and the help string consistent with
-		// previous descriptors of the same name?
-		// First check existing descriptors...
+		// Are all the label names, the help string, the unit, and the
+		// OpenMetrics type consistent with previous descriptors of the
+		// same name? First check existing descriptors...
+		omType := trackedOpenMetricsType(desc)
 		if dimHash, exists := r.dimHashesByName[desc.fqName]; exists {
 			if dimHash != desc.dimHash {
-				return fmt.Errorf("a previously registered descriptor with the same fully-qualified name as %s has different label names or a different help string", desc)
+				return fmt.Errorf("a previously registered descriptor with the same fully-qualified name as %s has different label names, a different help string, or a different unit", desc)
+			}
+			if r.typesByName[desc.fqName] != omType {
+				return fmt.Errorf("a previously registered descriptor with the same fully-qualified name as %s has a different OpenMetrics type", desc)
 			}
 		} else {
 			// ...then check the new descriptors already seen.
 			if dimHash, exists := newDimHashesByName[desc.fqName]; exists {
 				if dimHash != desc.dimHash {
-					return fmt.Errorf("descriptors reported by collector have inconsistent label names or help strings for the same fully-qualified name, offender is %s", desc)
+					return fmt.Errorf("descriptors reported by collector have inconsistent label names, help strings, or units for the same fully-qualified name, offender is %s", desc)
+				}
+				if newTypesByName[desc.fqName] != omType {
+					return fmt.Errorf("descriptors reported by collector have inconsistent OpenMetrics types for the same fully-qualified name, offender is %s", desc)
 				}
 			} else {
 				newDimHashesByName[desc.fqName] = desc.dimHash
+				if desc.unit != "" {
+					newUnitsByName[desc.fqName] = desc.unit
+				}
+				if omType != "" {
+					newTypesByName[desc.fqName] = omType
+				}
 			}
 		}
 	}
 	// A Collector yielding no Desc at all is considered unchecked.
 	if len(newDescIDs) == 0 {
 		r.uncheckedCollectors = append(r.uncheckedCollectors, c)
+		r.generation++
 		return nil
 	}
 	if existing, exists := r.collectorsByID[collectorID]; exists {
@@ -358,6 +527,19 @@ func (r *Registry) Register(c Collector) error {
 	for name, dimHash := range newDimHashesByName {
 		r.dimHashesByName[name] = dimHash
 	}
+	for name, unit := range newUnitsByName {
+		r.unitsByName[name] = unit
+	}
+	for name, typ := range newTypesByName {
+		r.typesByName[name] = typ
+	}
+	names := make([]string, 0, len(newNames))
+	for name := range newNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	r.namesByID[collectorID] = names
+	r.generation++
 	return nil
 }
 
@@ -390,11 +572,13 @@ func (r *Registry) Unregister(c Collector) bool {
 	defer r.mtx.Unlock()
 
 	delete(r.collectorsByID, collectorID)
+	delete(r.namesByID, collectorID)
 	for id := range descIDs {
 		delete(r.descIDs, id)
 	}
-	// dimHashesByName is left untouched as those must be consistent
-	// throughout the lifetime of a program.
+	// dimHashesByName, unitsByName, and typesByName are left untouched as
+	// those must be consistent throughout the lifetime of a program.
+	r.generation++
 	return true
 }
 
@@ -409,6 +593,16 @@ func (r *Registry) MustRegister(cs ...Collector) {
 
 // Gather implements Gatherer.
 func (r *Registry) Gather() ([]*dto.MetricFamily, error) {
+	return r.GatherWithContext(context.Background())
+}
+
+// GatherWithContext works like Gather, but the collection of each Collector is
+// aborted once the provided context is canceled or the timeout set with
+// SetCollectorTimeout is exceeded, whatever happens first. Aborted Collectors
+// are skipped and reported as errors in the returned MultiError, while the
+// metrics of all other Collectors are still returned. See SetCollectorTimeout
+// for details.
+func (r *Registry) GatherWithContext(ctx context.Context) ([]*dto.MetricFamily, error) {
 	r.mtx.RLock()
 
 	if len(r.collectorsByID) == 0 && len(r.uncheckedCollectors) == 0 {
@@ -424,6 +618,11 @@ func (r *Registry) Gather() ([]*dto.MetricFamily, error) {
 		wg                  sync.WaitGroup
 		errs                MultiError          // The collected errors to return in the end.
 		registeredDescIDs   map[uint64]struct{} // Only used for pedantic checks
+		collectErrsMtx      sync.Mutex          // Protects collectErrs.
+		collectErrs         MultiError          // Errors of aborted Collectors.
+		collectorTimeout    = r.collectorTimeout
+		collectorTimeouts   = r.collectorTimeouts
+		collectorCancels    = r.collectorCancellations
 	)
 
 	goroutineBudget := len(r.collectorsByID) + len(r.uncheckedCollectors)
@@ -448,13 +647,22 @@ func (r *Registry) Gather() ([]*dto.MetricFamily, error) {
 
 	wg.Add(goroutineBudget)
 
+	collect := func(collector Collector, ch chan<- Metric) {
+		if err := collectWithTimeout(ctx, collectorTimeout, collector, ch); err != nil {
+			countAbortedCollection(err, collectorTimeouts, collectorCancels)
+			collectErrsMtx.Lock()
+			collectErrs = append(collectErrs, err)
+			collectErrsMtx.Unlock()
+		}
+	}
+
 	collectWorker := func() {
 		for {
 			select {
 			case collector := <-checkedCollectors:
-				collector.Collect(checkedMetricChan)
+				collect(collector, checkedMetricChan)
 			case collector := <-uncheckedCollectors:
-				collector.Collect(uncheckedMetricChan)
+				collect(collector, uncheckedMetricChan)
 			default:
 				return
 			}
@@ -555,9 +763,80 @@ func (r *Registry) Gather() ([]*dto.MetricFamily, error) {
 			break
 		}
 	}
+	// All workers are done once both channels are closed, so collectErrs
+	// can be accessed without locking.
+	errs = append(errs, collectErrs...)
 	return internal.NormalizeMetricFamilies(metricFamiliesByName), errs.MaybeUnwrap()
 }
 
+// collectWithTimeout calls the Collect (or CollectWithContext) method of the
+// provided Collector. If the provided context is already canceled, Collect is
+// not called at all, and an error is returned. If the context has a deadline
+// or the provided timeout is > 0, the collected metrics are buffered until
+// Collect returns. Then the buffered metrics are sent to ch. If the deadline is
+// exceeded or the context is canceled before Collect returns, the buffered
+// metrics are discarded and an error is returned. In that case, Collect keeps
+// running in the background, and all metrics it still collects are discarded.
+// Without a deadline, the metrics are sent to ch directly.
+func collectWithTimeout(ctx context.Context, timeout time.Duration, c Collector, ch chan<- Metric) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("collection of %T skipped: %w", c, err)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		// Without a deadline, there is no need to buffer the metrics in
+		// a separate goroutine. A context-aware Collector may still
+		// return early upon cancellation.
+		collectWithContext(ctx, c, ch)
+		return nil
+	}
+
+	var (
+		metrics []Metric
+		mc      = make(chan Metric, capMetricChan)
+	)
+	go func() {
+		collectWithContext(ctx, c, mc)
+		close(mc)
+	}()
+	for {
+		select {
+		case m, ok := <-mc:
+			if !ok {
+				for _, m := range metrics {
+					ch <- m
+				}
+				return nil
+			}
+			metrics = append(metrics, m)
+		case <-ctx.Done():
+			// Drain mc in the background to not block the Collector.
+			go func() {
+				for range mc {
+				}
+			}()
+			return fmt.Errorf("collection of %T aborted: %w", c, ctx.Err())
+		}
+	}
+}
+
+// countAbortedCollection increments timeouts if err is caused by an exceeded
+// deadline and cancellations otherwise. Both counters may be nil.
+func countAbortedCollection(err error, timeouts, cancellations Counter) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		if timeouts != nil {
+			timeouts.Inc()
+		}
+	case cancellations != nil:
+		cancellations.Inc()
+	}
+}
+
 // Describe implements Collector.
 func (r *Registry) Describe(ch chan<- *Desc) {
 	r.mtx.RLock()
@@ -572,14 +851,20 @@ func (r *Registry) Describe(ch chan<- *Desc) {
 
 // Collect implements Collector.
 func (r *Registry) Collect(ch chan<- Metric) {
+	r.CollectWithContext(context.Background(), ch)
+}
+
+// CollectWithContext implements ContextCollector. The provided context is
+// passed on to all registered Collectors implementing ContextCollector.
+func (r *Registry) CollectWithContext(ctx context.Context, ch chan<- Metric) {
 	r.mtx.RLock()
 	defer r.mtx.RUnlock()
 
 	for _, c := range r.collectorsByID {
-		c.Collect(ch)
+		collectWithContext(ctx, c, ch)
 	}
 	for _, c := range r.uncheckedCollectors {
-		c.Collect(ch)
+		collectWithContext(ctx, c, ch)
 	}
 }
 
@@ -601,7 +886,7 @@ func WriteToTextfile(filename string, g Gatherer) error {
 		return err
 	}
 	for _, mf := range mfs {
-		if _, err := expfmt.MetricFamilyToText(tmp, mf); err != nil {
+		if _, err := expfmt.MetricFamilyToText(tmp, internal.GaugeHistogramAsHistogram(mf)); err != nil {
 			return err
 		}
 	}
@@ -671,12 +956,19 @@ func processMetric(
 				)
 			}
 		case dto.MetricType_HISTOGRAM:
-			if dtoMetric.Histogram == nil {
+			if dtoMetric.Histogram == nil || desc.openMetricsType == "gaugehistogram" {
 				return fmt.Errorf(
 					"collected metric %s %s should be a Histogram",
 					desc.fqName, dtoMetric,
 				)
 			}
+		case dto.MetricType_GAUGE_HISTOGRAM:
+			if dtoMetric.Histogram == nil || desc.openMetricsType != "gaugehistogram" {
+				return fmt.Errorf(
+					"collected metric %s %s should be a GaugeHistogram",
+					desc.fqName, dtoMetric,
+				)
+			}
 		default:
 			panic("encountered MetricFamily with invalid type")
 		}
@@ -694,6 +986,8 @@ func processMetric(
 			metricFamily.Type = dto.MetricType_SUMMARY.Enum()
 		case dtoMetric.Untyped != nil:
 			metricFamily.Type = dto.MetricType_UNTYPED.Enum()
+		case dtoMetric.Histogram != nil && desc.openMetricsType == "gaugehistogram":
+			metricFamily.Type = dto.MetricType_GAUGE_HISTOGRAM.Enum()
 		case dtoMetric.Histogram != nil:
 			metricFamily.Type = dto.MetricType_HISTOGRAM.Enum()
 		default:
@@ -723,6 +1017,51 @@ func processMetric(
 	return nil
 }
 
+// Generation implements GenerationProvider.
+func (r *Registry) Generation() uint64 {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	return r.generation
+}
+
+// Units implements UnitProvider. It returns the units of all descriptors that
+// have ever been registered with the Registry.
+func (r *Registry) Units() map[string]string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	units := make(map[string]string, len(r.unitsByName))
+	for name, unit := range r.unitsByName {
+		units[name] = unit
+	}
+	return units
+}
+
+// trackedOpenMetricsType returns the OpenMetrics type of the provided Desc as
+// tracked by the Registry, i.e. "info", "stateset", or "" otherwise. Gauge
+// histograms are not tracked as they have their own type in the protobuf
+// messages.
+func trackedOpenMetricsType(desc *Desc) string {
+	if desc.openMetricsType == "gaugehistogram" {
+		return ""
+	}
+	return desc.openMetricsType
+}
+
+// OpenMetricsTypes implements TypeProvider. It returns the OpenMetrics types of
+// all descriptors that have ever been registered with the Registry.
+func (r *Registry) OpenMetricsTypes() map[string]string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	types := make(map[string]string, len(r.typesByName))
+	for name, typ := range r.typesByName {
+		types[name] = typ
+	}
+	return types
+}
+
 // Gatherers is a slice of Gatherer instances that implements the Gatherer
 // interface itself. Its Gather method calls Gather on all Gatherers in the
 // slice in order and returns the merged results. Errors returned from the
@@ -743,6 +1082,46 @@ type Gatherers []Gatherer
 
 // Gather implements Gatherer.
 func (gs Gatherers) Gather() ([]*dto.MetricFamily, error) {
+	return gs.GatherWithContext(context.Background())
+}
+
+// Generation implements GenerationProvider. It returns the sum of the
+// generations of all contained Gatherers implementing GenerationProvider.
+func (gs Gatherers) Generation() uint64 {
+	var generation uint64
+	for _, g := range gs {
+		generation += generationOf(g)
+	}
+	return generation
+}
+
+// Units implements UnitProvider. It merges the units of all contained
+// Gatherers implementing UnitProvider.
+func (gs Gatherers) Units() map[string]string {
+	units := map[string]string{}
+	for _, g := range gs {
+		for name, unit := range unitsOf(g) {
+			units[name] = unit
+		}
+	}
+	return units
+}
+
+// OpenMetricsTypes implements TypeProvider. It merges the OpenMetrics types of
+// all contained Gatherers implementing TypeProvider.
+func (gs Gatherers) OpenMetricsTypes() map[string]string {
+	types := map[string]string{}
+	for _, g := range gs {
+		for name, typ := range openMetricsTypesOf(g) {
+			types[name] = typ
+		}
+	}
+	return types
+}
+
+// GatherWithContext implements ContextGatherer. The provided context is passed
+// on to all contained Gatherers implementing ContextGatherer.
+func (gs Gatherers) GatherWithContext(ctx context.Context) ([]*dto.MetricFamily, error) {
 	var (
 		metricFamiliesByName = map[string]*dto.MetricFamily{}
 		metricHashes         = map[uint64]struct{}{}
@@ -750,7 +1129,7 @@ func (gs Gatherers) Gather() ([]*dto.MetricFamily, error) {
 	)
 
 	for i, g := range gs {
-		mfs, err := g.Gather()
+		mfs, err := gatherWithContext(ctx, g)
 		if err != nil {
 			multiErr := MultiError{}
 			if errors.As(err, &multiErr) {
@@ -828,7 +1207,7 @@ func checkSuffixCollisions(mf *dto.MetricFamily, mfs map[string]*dto.MetricFamil
 						newName, newNameWithoutSuffix,
 					)
 				}
-			case dto.MetricType_HISTOGRAM:
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
 				return fmt.Errorf(
 					"collected metric named %q collides with previously collected histogram named %q",
 					newName, newNameWithoutSuffix,
@@ -836,7 +1215,8 @@ func checkSuffixCollisions(mf *dto.MetricFamily, mfs map[string]*dto.MetricFamil
 			}
 		}
 	}
-	if newType == dto.MetricType_SUMMARY || newType == dto.MetricType_HISTOGRAM {
+	isHistogram := newType == dto.MetricType_HISTOGRAM || newType == dto.MetricType_GAUGE_HISTOGRAM
+	if newType == dto.MetricType_SUMMARY || isHistogram {
 		if _, ok := mfs[newName+"_count"]; ok {
 			return fmt.Errorf(
 				"collected histogram or summary named %q collides with previously collected metric named %q",
@@ -850,7 +1230,7 @@ func checkSuffixCollisions(mf *dto.MetricFamily, mfs map[string]*dto.MetricFamil
 			)
 		}
 	}
-	if newType == dto.MetricType_HISTOGRAM {
+	if isHistogram {
 		if _, ok := mfs[newName+"_bucket"]; ok {
 			return fmt.Errorf(
 				"collected histogram named %q collides with previously collected metric named %q",
@@ -877,6 +1257,7 @@ func checkMetricConsistency(
 		metricFamily.GetType() == dto.MetricType_COUNTER && dtoMetric.Counter == nil ||
 		metricFamily.GetType() == dto.MetricType_SUMMARY && dtoMetric.Summary == nil ||
 		metricFamily.GetType() == dto.MetricType_HISTOGRAM && dtoMetric.Histogram == nil ||
+		metricFamily.GetType() == dto.MetricType_GAUGE_HISTOGRAM && dtoMetric.Histogram == nil ||
 		metricFamily.GetType() == dto.MetricType_UNTYPED && dtoMetric.Untyped == nil {
 		return fmt.Errorf(
 			"collected metric %q { %s} is not a %s",
@@ -1006,15 +1387,64 @@ func NewMultiTRegistry(tGatherers ...TransactionalGatherer) *MultiTRegistry {
 	}
 }
 
+// Generation implements GenerationProvider. It returns the sum of the
+// generations of all contained TransactionalGatherers implementing
+// GenerationProvider.
+func (r *MultiTRegistry) Generation() uint64 {
+	var generation uint64
+	for _, g := range r.tGatherers {
+		generation += generationOf(g)
+	}
+	return generation
+}
+
+// Units implements UnitProvider. It merges the units of all contained
+// TransactionalGatherers implementing UnitProvider.
+func (r *MultiTRegistry) Units() map[string]string {
+	units := map[string]string{}
+	for _, g := range r.tGatherers {
+		for name, unit := range unitsOf(g) {
+			units[name] = unit
+		}
+	}
+	return units
+}
+
+// OpenMetricsTypes implements TypeProvider. It merges the OpenMetrics types of
+// all contained TransactionalGatherers implementing TypeProvider.
+func (r *MultiTRegistry) OpenMetricsTypes() map[string]string {
+	types := map[string]string{}
+	for _, g := range r.tGatherers {
+		for name, typ := range openMetricsTypesOf(g) {
+			types[name] = typ
+		}
+	}
+	return types
+}
+
 // Gather implements TransactionalGatherer interface.
 func (r *MultiTRegistry) Gather() (mfs []*dto.MetricFamily, done func(), err error) {
+	return r.GatherWithContext(context.Background())
+}
+
+// GatherWithContext implements ContextTransactionalGatherer interface.
+func (r *MultiTRegistry) GatherWithContext(ctx context.Context) (mfs []*dto.MetricFamily, done func(), err error) {
 	errs := MultiError{}
 
 	dFns := make([]func(), 0, len(r.tGatherers))
 	// TODO(bwplotka): Implement concurrency for those?
 	for _, g := range r.tGatherers {
 		// TODO(bwplotka): Check for duplicates?
-		m, d, err := g.Gather()
+		var (
+			m   []*dto.MetricFamily
+			d   func()
+			err error
+		)
+		if cg, ok := g.(ContextTransactionalGatherer); ok {
+			m, d, err = cg.GatherWithContext(ctx)
+		} else {
+			m, d, err = g.Gather()
+		}
 		errs.Append(err)
 
 		mfs = append(mfs, m...)
@@ -1059,7 +1489,19 @@ type TransactionalGatherer interface {
 	Gather() (_ []*dto.MetricFamily, done func(), err error)
 }
 
+// ContextTransactionalGatherer is a TransactionalGatherer that can pass a
+// context to the Collectors it gathers from, in the same way as a
+// ContextGatherer.
+type ContextTransactionalGatherer interface {
+	TransactionalGatherer
+	// GatherWithContext works like Gather but passes on the provided
+	// context. Collection is aborted once the context is done.
+	GatherWithContext(ctx context.Context) (_ []*dto.MetricFamily, done func(), err error)
+}
+
 // ToTransactionalGatherer transforms Gatherer to transactional one with noop as done function.
+// The returned TransactionalGatherer also implements ContextTransactionalGatherer,
+// passing on the context if the provided Gatherer implements ContextGatherer.
 func ToTransactionalGatherer(g Gatherer) TransactionalGatherer {
 	return &noTransactionGatherer{g: g}
 }
@@ -1073,3 +1515,43 @@ func (g *noTransactionGatherer) Gather() (_ []*dto.MetricFamily, done func(), er
 	mfs, err := g.g.Gather()
 	return mfs, func() {}, err
 }
+
+// GatherWithContext implements ContextTransactionalGatherer interface.
+func (g *noTransactionGatherer) GatherWithContext(ctx context.Context) (_ []*dto.MetricFamily, done func(), err error) {
+	mfs, err := gatherWithContext(ctx, g.g)
+	return mfs, func() {}, err
+}
+
+// Generation implements GenerationProvider by passing on the generation of the
+// wrapped Gatherer, if it implements GenerationProvider.
+func (g *noTransactionGatherer) Generation() uint64 {
+	return generationOf(g.g)
+}
+
+// GatherStream implements StreamingGatherer by passing on the call to the
+// wrapped Gatherer if it implements StreamingGatherer. Otherwise, all
+// MetricFamilies are gathered at once and then handed over one by one.
+func (g *noTransactionGatherer) GatherStream(ctx context.Context, f func(*dto.MetricFamily) error) error {
+	if sg, ok := g.g.(StreamingGatherer); ok {
+		return sg.GatherStream(ctx, f)
+	}
+	mfs, err := gatherWithContext(ctx, g.g)
+	for _, mf := range mfs {
+		if err := f(mf); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// Units implements UnitProvider by passing on the units of the wrapped
+// Gatherer, if it implements UnitProvider.
+func (g *noTransactionGatherer) Units() map[string]string {
+	return unitsOf(g.g)
+}
+
+// OpenMetricsTypes implements TypeProvider by passing on the OpenMetrics types
+// of the wrapped Gatherer, if it implements TypeProvider.
+func (g *noTransactionGatherer) OpenMetricsTypes() map[string]string {
+	return openMetricsTypesOf(g.g)
+}
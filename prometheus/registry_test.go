@@ -21,12 +21,14 @@ package prometheus_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -56,17 +58,19 @@ func testHandler(t testing.TB) {
 	// require a major rework of this test anyway, at which time I will
 	// structure it in a better way.
 
-	metricVec := prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name:        "name",
-			Help:        "docstring",
-			ConstLabels: prometheus.Labels{"constname": "constvalue"},
-		},
-		[]string{"labelname"},
+	// The counters are const metrics, which have no created timestamp, so
+	// that the expected output is stable.
+	metricVecDesc := prometheus.NewDesc(
+		"name", "docstring",
+		[]string{"labelname"}, prometheus.Labels{"constname": "constvalue"},
 	)
-
-	metricVec.WithLabelValues("val1").Inc()
-	metricVec.WithLabelValues("val2").Inc()
+	metricVec := &describingCollector{
+		descs: []*prometheus.Desc{metricVecDesc},
+		collectFunc: func(ch chan<- prometheus.Metric) {
+			ch <- prometheus.MustNewConstMetric(metricVecDesc, prometheus.CounterValue, 1, "val1")
+			ch <- prometheus.MustNewConstMetric(metricVecDesc, prometheus.CounterValue, 1, "val2")
+		},
+	}
 
 	externalMetricFamily := &dto.MetricFamily{
 		Name: proto.String("externalname"),
@@ -118,8 +122,8 @@ metric: <
 >
 
 `)
-	externalMetricFamilyAsProtoCompactText := []byte(`name:"externalname" help:"externaldocstring" type:COUNTER metric:<label:<name:"externalconstname" value:"externalconstvalue" > label:<name:"externallabelname" value:"externalval1" > counter:<value:1 > >`)
-	externalMetricFamilyAsProtoCompactText = append(externalMetricFamilyAsProtoCompactText, []byte(" \n")...)
+	externalMetricFamilyAsProtoCompactText := []byte(`name:"externalname" help:"externaldocstring" type:COUNTER metric:{label:{name:"externalconstname" value:"externalconstvalue"} label:{name:"externallabelname" value:"externalval1"} counter:{value:1}}`)
+	externalMetricFamilyAsProtoCompactText = append(externalMetricFamilyAsProtoCompactText, []byte("\n")...)
 
 	expectedMetricFamily := &dto.MetricFamily{
 		Name: proto.String("name"),
@@ -200,8 +204,8 @@ metric: <
 >
 
 `)
-	expectedMetricFamilyAsProtoCompactText := []byte(`name:"name" help:"docstring" type:COUNTER metric:<label:<name:"constname" value:"constvalue" > label:<name:"labelname" value:"val1" > counter:<value:1 > > metric:<label:<name:"constname" value:"constvalue" > label:<name:"labelname" value:"val2" > counter:<value:1 > >`)
-	expectedMetricFamilyAsProtoCompactText = append(expectedMetricFamilyAsProtoCompactText, []byte(" \n")...)
+	expectedMetricFamilyAsProtoCompactText := []byte(`name:"name" help:"docstring" type:COUNTER metric:{label:{name:"constname" value:"constvalue"} label:{name:"labelname" value:"val1"} counter:{value:1}} metric:{label:{name:"constname" value:"constvalue"} label:{name:"labelname" value:"val2"} counter:{value:1}}`)
+	expectedMetricFamilyAsProtoCompactText = append(expectedMetricFamilyAsProtoCompactText, []byte("\n")...)
 
 	externalMetricFamilyWithSameName := &dto.MetricFamily{
 		Name: proto.String("name"),
@@ -226,8 +230,8 @@ metric: <
 		},
 	}
 
-	expectedMetricFamilyMergedWithExternalAsProtoCompactText := []byte(`name:"name" help:"docstring" type:COUNTER metric:<label:<name:"constname" value:"constvalue" > label:<name:"labelname" value:"different_val" > counter:<value:42 > > metric:<label:<name:"constname" value:"constvalue" > label:<name:"labelname" value:"val1" > counter:<value:1 > > metric:<label:<name:"constname" value:"constvalue" > label:<name:"labelname" value:"val2" > counter:<value:1 > >`)
-	expectedMetricFamilyMergedWithExternalAsProtoCompactText = append(expectedMetricFamilyMergedWithExternalAsProtoCompactText, []byte(" \n")...)
+	expectedMetricFamilyMergedWithExternalAsProtoCompactText := []byte(`name:"name" help:"docstring" type:COUNTER metric:{label:{name:"constname" value:"constvalue"} label:{name:"labelname" value:"different_val"} counter:{value:42}} metric:{label:{name:"constname" value:"constvalue"} label:{name:"labelname" value:"val1"} counter:{value:1}} metric:{label:{name:"constname" value:"constvalue"} label:{name:"labelname" value:"val2"} counter:{value:1}}`)
+	expectedMetricFamilyMergedWithExternalAsProtoCompactText = append(expectedMetricFamilyMergedWithExternalAsProtoCompactText, []byte("\n")...)
 
 	externalMetricFamilyWithInvalidLabelValue := &dto.MetricFamily{
 		Name: proto.String("name"),
@@ -254,7 +258,7 @@ metric: <
 
 	expectedMetricFamilyInvalidLabelValueAsText := []byte(`An error has occurred while serving metrics:
 
-collected metric "name" { label:<name:"constname" value:"\377" > label:<name:"labelname" value:"different_val" > counter:<value:42 > } has a label named "constname" whose value is not utf8: "\xff"
+collected metric "name" { label:{name:"constname" value:"\xff"} label:{name:"labelname" value:"different_val"} counter:{value:42}} has a label named "constname" whose value is not utf8: "\xff"
 `)
 
 	summary := prometheus.NewSummary(prometheus.SummaryOpts{
@@ -338,7 +342,7 @@ collected metric named "complex_count" collides with previously collected histog
 	}
 	duplicateLabelMsg := []byte(`An error has occurred while serving metrics:
 
-collected metric "broken_metric" { label:<name:"foo" value:"bar" > label:<name:"foo" value:"baz" > counter:<value:2.7 > } has two or more labels with the same name: foo
+collected metric "broken_metric" { label:{name:"foo" value:"bar"} label:{name:"foo" value:"baz"} counter:{value:2.7}} has two or more labels with the same name: foo
 `)
 
 	type output struct {
@@ -725,10 +729,12 @@ collected metric "broken_metric" { label:<name:"foo" value:"bar" > label:<name:"
 			}
 		}
 
-		if !bytes.Equal(scenario.out.body, writer.Body.Bytes()) {
+		// The compact protobuf text format randomly adds extra spaces to
+		// make its output unstable, so they are removed before comparing.
+		if got := normalizeSpaces(writer.Body.String()); string(scenario.out.body) != got {
 			t.Errorf(
 				"%d. expected body:\n%s\ngot body:\n%s\n",
-				i, scenario.out.body, writer.Body.Bytes(),
+				i, scenario.out.body, got,
 			)
 		}
 	}
@@ -1331,3 +1337,224 @@ func TestCheckMetricConsistency(t *testing.T) {
 	}
 	reg.Unregister(invalidCollector)
 }
+
+func TestRegisterUnits(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "disk_free_bytes",
+		Help:        "Free disk space.",
+		Unit:        "bytes",
+		ConstLabels: prometheus.Labels{"disk": "a"},
+	}))
+	reg.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "requests_total",
+		Help: "Total requests.",
+	}))
+
+	// Same name but different unit.
+	err := reg.Register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "disk_free_bytes",
+		Help:        "Free disk space.",
+		ConstLabels: prometheus.Labels{"disk": "b"},
+	}))
+	if err == nil {
+		t.Error("registering a metric with a different unit should fail")
+	}
+
+	// Unit not matching the name.
+	err = reg.Register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "disk_free",
+		Help: "Free disk space.",
+		Unit: "bytes",
+	}))
+	if err == nil {
+		t.Error("registering a metric with a unit not matching its name should fail")
+	}
+
+	want := map[string]string{"disk_free_bytes": "bytes"}
+	if got := reg.Units(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got units %v, want %v", got, want)
+	}
+}
+
+func TestRegisterOpenMetricsTypes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewInfo(prometheus.InfoOpts{
+		Name:        "build_info",
+		Help:        "Build information.",
+		ConstLabels: prometheus.Labels{"version": "v1"},
+	}))
+	reg.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "feature",
+		Help:        "Enabled features.",
+		ConstLabels: prometheus.Labels{"feature": "a"},
+	}))
+
+	// Same name but a gauge instead of an info.
+	err := reg.Register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "build_info",
+		Help:        "Build information.",
+		ConstLabels: prometheus.Labels{"version": "v2"},
+	}))
+	if err == nil {
+		t.Error("registering a gauge with the name of an info should fail")
+	}
+
+	// Same name but a state set instead of a gauge.
+	err = reg.Register(prometheus.NewStateSet(prometheus.StateSetOpts{
+		Name:   "feature",
+		Help:   "Enabled features.",
+		States: []string{"b"},
+	}))
+	if err == nil {
+		t.Error("registering a state set with the name of a gauge should fail")
+	}
+
+	want := map[string]string{"build_info": "info"}
+	if got := reg.OpenMetricsTypes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got OpenMetrics types %v, want %v", got, want)
+	}
+}
+
+func TestGatherWithCollectorTimeout(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if err := reg.SetCollectorTimeout(50 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// Setting the timeout again must not fail on re-registration.
+	if err := reg.SetCollectorTimeout(50 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	block := make(chan struct{})
+	defer close(block)
+	slowDesc := prometheus.NewDesc("slow_metric", "help", nil, nil)
+	reg.MustRegister(&customCollector{
+		collectFunc: func(ch chan<- prometheus.Metric) {
+			ch <- prometheus.MustNewConstMetric(slowDesc, prometheus.GaugeValue, 1)
+			<-block
+		},
+	})
+	fastDesc := prometheus.NewDesc("fast_metric", "help", nil, nil)
+	reg.MustRegister(&customCollector{
+		collectFunc: func(ch chan<- prometheus.Metric) {
+			ch <- prometheus.MustNewConstMetric(fastDesc, prometheus.GaugeValue, 1)
+		},
+	})
+
+	for i := 1; i <= 2; i++ {
+		mfs, err := reg.Gather()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+		}
+		got := map[string]float64{}
+		for _, mf := range mfs {
+			got[mf.GetName()] = mf.GetMetric()[0].GetCounter().GetValue()
+		}
+		if _, ok := got["slow_metric"]; ok {
+			t.Error("metric of timed out collector was returned")
+		}
+		if _, ok := got["fast_metric"]; !ok {
+			t.Error("metric of fast collector is missing")
+		}
+		// The counter might get collected before the slow collector
+		// times out.
+		if v := got["prometheus_collector_timeouts_total"]; v != float64(i-1) && v != float64(i) {
+			t.Errorf("got %v timeouts, want %v or %v", v, i-1, i)
+		}
+	}
+}
+
+func TestGatherWithContext(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	desc := prometheus.NewDesc("metric", "help", nil, nil)
+	reg.MustRegister(&customCollector{
+		collectFunc: func(ch chan<- prometheus.Metric) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1)
+		},
+	})
+
+	mfs, err := reg.GatherWithContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) != 1 {
+		t.Errorf("got %d metric families, want 1", len(mfs))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mfs, err = reg.GatherWithContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if len(mfs) != 0 {
+		t.Errorf("got %d metric families, want 0", len(mfs))
+	}
+}
+
+func TestGatherCountsCancellations(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if err := reg.SetCollectorTimeout(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	desc := prometheus.NewDesc("metric", "help", nil, nil)
+	reg.MustRegister(&customCollector{
+		collectFunc: func(ch chan<- prometheus.Metric) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1)
+		},
+	})
+
+	// A canceled gathering skips all three collectors.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := reg.GatherWithContext(ctx)
+	var multiErr prometheus.MultiError
+	if !errors.As(err, &multiErr) || len(multiErr) != 3 || !errors.Is(multiErr[0], context.Canceled) {
+		t.Fatalf("got error %v, want 3 errors wrapping %v", err, context.Canceled)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, mf := range mfs {
+		got[mf.GetName()] = mf.GetMetric()[0].GetCounter().GetValue()
+	}
+	if v := got["prometheus_collector_timeouts_total"]; v != 0 {
+		t.Errorf("got %v timeouts, want 0", v)
+	}
+	if v := got["prometheus_collector_cancellations_total"]; v != 3 {
+		t.Errorf("got %v cancellations, want 3", v)
+	}
+}
+
+func TestRegistryGeneration(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	other := prometheus.NewRegistry()
+	gatherers := prometheus.Gatherers{reg, other}
+	tGatherer := prometheus.ToTransactionalGatherer(gatherers).(prometheus.GenerationProvider)
+
+	gen, tGen := reg.Generation(), tGatherer.Generation()
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "c", Help: "help"})
+	reg.MustRegister(c)
+	if reg.Generation() == gen {
+		t.Error("generation unchanged after registration")
+	}
+	if tGatherer.Generation() == tGen {
+		t.Error("generation of wrapping gatherer unchanged after registration")
+	}
+
+	gen = reg.Generation()
+	if err := reg.Register(c); err == nil {
+		t.Fatal("expected error for duplicate registration")
+	}
+	if reg.Generation() != gen {
+		t.Error("generation changed after failed registration")
+	}
+	reg.Unregister(c)
+	if reg.Generation() == gen {
+		t.Error("generation unchanged after unregistration")
+	}
+}
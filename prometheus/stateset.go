@@ -0,0 +1,367 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// StateSet is a Collector that represents a set of named states, each of which
+// is either enabled or disabled. This is the StateSet type of OpenMetrics. If
+// used as an enum (see StateSetOpts.Enum), exactly one of the states is
+// enabled at any time.
+//
+// A StateSet consists of one series per state, with a label named after the
+// fully-qualified metric name carrying the state and the value 1 for enabled
+// and 0 for disabled states. The series are gathered as gauges, which is how
+// the Prometheus text and protobuf formats represent state sets. Registry
+// implements TypeProvider to report their actual type, so that the promhttp
+// package exposes them with the stateset type in the OpenMetrics text format.
+//
+// To create StateSet instances, use NewStateSet.
+type StateSet interface {
+	Collector
+
+	// Set enables or disables the given state. If the StateSet is an enum,
+	// enabling a state disables all other states, and disabling the only
+	// enabled state panics. Set also panics if the state is unknown.
+	Set(state string, enabled bool)
+	// Enabled returns whether the given state is enabled. It panics if the
+	// state is unknown.
+	Enabled(state string) bool
+}
+
+// StateSetOpts bundles the options for creating a StateSet metric. It is
+// mandatory to set Name and States to a non-empty value. All other fields are
+// optional and can safely be left at their zero value.
+type StateSetOpts struct {
+	// Namespace, Subsystem, Name, Help, and ConstLabels have the same
+	// meaning as in Opts. The fully-qualified name is also used as the
+	// name of the label carrying the state, so it must be a valid label
+	// name (in particular, it must not contain colons) and must not be
+	// used as the name of another label of the StateSet.
+	Namespace string
+	Subsystem string
+	Name      string
+	Help      string
+
+	ConstLabels Labels
+
+	// States are the names of all states of the StateSet. Each state must
+	// be non-empty and unique.
+	States []string
+
+	// Enum, if true, turns the StateSet into an enum, i.e. exactly one of
+	// its states is enabled at any time. Initially, that is the first of
+	// States. If false, all states are initially disabled.
+	Enum bool
+}
+
+// StateSetVecOpts bundles the options to create a StateSetVec metric.
+// It is mandatory to set StateSetOpts, see there for mandatory fields. VariableLabels
+// is optional and can safely be left to its default value.
+type StateSetVecOpts struct {
+	StateSetOpts
+
+	// VariableLabels are used to partition the metric vector by the given set
+	// of labels. Each label value will be constrained with the optional Contraint
+	// function, if provided.
+	VariableLabels ConstrainableLabels
+}
+
+// NewStateSet creates a new StateSet based on the provided StateSetOpts. It
+// panics if States is empty or contains empty or duplicate states, or if the
+// resulting descriptor is invalid (e.g. because the fully-qualified name is not
+// a valid label name).
+func NewStateSet(opts StateSetOpts) StateSet {
+	return V2.NewStateSetVec(StateSetVecOpts{StateSetOpts: opts}).WithLabelValues()
+}
+
+// stateSetConfig is shared by a StateSetVec and all the StateSets in it.
+type stateSetConfig struct {
+	desc     *Desc
+	states   []string
+	stateIdx map[string]int
+	enum     bool
+
+	mtx sync.Mutex // Serializes state changes to keep enums consistent.
+}
+
+type stateSet struct {
+	cfg *stateSetConfig
+	// gauges contains the series of each state in the order of
+	// cfg.states.
+	gauges []*gauge
+}
+
+func (s *stateSet) Describe(ch chan<- *Desc) {
+	ch <- s.cfg.desc
+}
+
+func (s *stateSet) Collect(ch chan<- Metric) {
+	for _, g := range s.gauges {
+		ch <- g
+	}
+}
+
+func (s *stateSet) Set(state string, enabled bool) {
+	i := s.index(state)
+
+	s.cfg.mtx.Lock()
+	defer s.cfg.mtx.Unlock()
+
+	switch {
+	case !s.cfg.enum:
+		s.gauges[i].Set(boolToFloat(enabled))
+	case enabled:
+		for j, g := range s.gauges {
+			g.Set(boolToFloat(j == i))
+		}
+	case s.enabled(i):
+		panic(fmt.Errorf(
+			"cannot disable state %q of enum state set %q, enable another state instead",
+			state, s.cfg.desc.fqName,
+		))
+	}
+}
+
+func (s *stateSet) Enabled(state string) bool {
+	return s.enabled(s.index(state))
+}
+
+func (s *stateSet) index(state string) int {
+	i, ok := s.cfg.stateIdx[state]
+	if !ok {
+		panic(fmt.Errorf("unknown state %q of state set %q", state, s.cfg.desc.fqName))
+	}
+	return i
+}
+
+func (s *stateSet) enabled(i int) bool {
+	return math.Float64frombits(atomic.LoadUint64(&s.gauges[i].valBits)) == 1
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// StateSetVec is a Collector that bundles a set of StateSets that all share the
+// same Desc, but have different values for their variable labels. This is used
+// if you want to track the same states partitioned by various dimensions
+// (e.g. the state of each connection to a backend). Create instances with
+// NewStateSetVec.
+//
+// Like the other vectors, StateSetVec embeds a MetricVec, which contains the
+// series of all states, with the state label as the last variable label of its
+// Desc. Therefore, the methods of the embedded MetricVec that are not
+// overridden by StateSetVec (like Range, RangePartialMatch, and Reset) operate
+// on the series of the individual states rather than on whole StateSets. As
+// that would break the consistency of StateSets, neither SetTTL nor
+// SetCardinalityLimit should be used on a StateSetVec.
+type StateSetVec struct {
+	*MetricVec
+	cfg *stateSetConfig
+}
+
+// NewStateSetVec creates a new StateSetVec based on the provided StateSetOpts
+// and partitioned by the given label names. It panics under the same
+// conditions as NewStateSet.
+func NewStateSetVec(opts StateSetOpts, labelNames []string) *StateSetVec {
+	return V2.NewStateSetVec(StateSetVecOpts{
+		StateSetOpts:   opts,
+		VariableLabels: UnconstrainedLabels(labelNames),
+	})
+}
+
+// NewStateSetVec creates a new StateSetVec based on the provided
+// StateSetVecOpts.
+func (v2) NewStateSetVec(opts StateSetVecOpts) *StateSetVec {
+	fqName := BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+	if len(opts.States) == 0 {
+		panic(fmt.Errorf("state set %q has no states", fqName))
+	}
+	stateIdx := make(map[string]int, len(opts.States))
+	for i, state := range opts.States {
+		if state == "" {
+			panic(fmt.Errorf("state set %q has an empty state", fqName))
+		}
+		if _, ok := stateIdx[state]; ok {
+			panic(fmt.Errorf("state set %q has duplicate state %q", fqName, state))
+		}
+		stateIdx[state] = i
+	}
+
+	var variableLabels ConstrainedLabels
+	if opts.VariableLabels != nil {
+		variableLabels = append(variableLabels, opts.VariableLabels.constrainedLabels()...)
+	}
+	variableLabels = append(variableLabels, ConstrainedLabel{Name: fqName})
+	desc := V2.NewDesc(fqName, opts.Help, variableLabels, opts.ConstLabels)
+	if desc.err != nil {
+		// Unlike other metrics, a StateSet creates its series right
+		// away, so an invalid Desc cannot wait for registration.
+		panic(desc.err)
+	}
+	desc.openMetricsType = "stateset"
+
+	cfg := &stateSetConfig{
+		desc:     desc,
+		states:   append([]string(nil), opts.States...),
+		stateIdx: stateIdx,
+		enum:     opts.Enum,
+	}
+	return &StateSetVec{
+		MetricVec: NewMetricVec(desc, func(lvs ...string) Metric {
+			if len(lvs) != len(desc.variableLabels) {
+				panic(makeInconsistentCardinalityError(desc.fqName, desc.variableLabels.labelNames(), lvs))
+			}
+			result := &gauge{desc: desc, labelPairs: MakeLabelPairs(desc, lvs)}
+			if cfg.enum && lvs[len(lvs)-1] == cfg.states[0] {
+				result.Set(1)
+			}
+			result.init(result) // Init self-collection.
+			return result
+		}),
+		cfg: cfg,
+	}
+}
+
+// GetMetricWithLabelValues returns the StateSet for the given slice of label
+// values (same order as the variable labels in StateSetVecOpts, not including
+// the state label). If that combination of label values is accessed for the
+// first time, a new StateSet is created, with all its states in their initial
+// state.
+//
+// An error is returned if the number of label values is not the same as the
+// number of variable labels.
+func (v *StateSetVec) GetMetricWithLabelValues(lvs ...string) (StateSet, error) {
+	s := &stateSet{cfg: v.cfg, gauges: make([]*gauge, len(v.cfg.states))}
+	stateLVs := make([]string, len(lvs)+1)
+	copy(stateLVs, lvs)
+	for i, state := range v.cfg.states {
+		stateLVs[len(lvs)] = state
+		m, err := v.MetricVec.GetMetricWithLabelValues(stateLVs...)
+		if err != nil {
+			return nil, err
+		}
+		s.gauges[i] = m.(*gauge)
+	}
+	return s, nil
+}
+
+// GetMetricWith returns the StateSet for the given Labels map (the label names
+// must match those of the variable labels in StateSetVecOpts, not including the
+// state label). If that label map is accessed for the first time, a new
+// StateSet is created.
+//
+// An error is returned if the number and names of the Labels are inconsistent
+// with those of the variable labels.
+func (v *StateSetVec) GetMetricWith(labels Labels) (StateSet, error) {
+	stateLabel := v.cfg.desc.fqName
+	if _, ok := labels[stateLabel]; ok {
+		return nil, fmt.Errorf("label %q is the state label of state set %q", stateLabel, stateLabel)
+	}
+	s := &stateSet{cfg: v.cfg, gauges: make([]*gauge, len(v.cfg.states))}
+	stateLabels := make(Labels, len(labels)+1)
+	for name, value := range labels {
+		stateLabels[name] = value
+	}
+	for i, state := range v.cfg.states {
+		stateLabels[stateLabel] = state
+		m, err := v.MetricVec.GetMetricWith(stateLabels)
+		if err != nil {
+			return nil, err
+		}
+		s.gauges[i] = m.(*gauge)
+	}
+	return s, nil
+}
+
+// WithLabelValues works as GetMetricWithLabelValues, but panics where
+// GetMetricWithLabelValues would have returned an error. Not returning an
+// error allows shortcuts like
+//
+//	myVec.WithLabelValues("backend-1").Set("connected", true)
+func (v *StateSetVec) WithLabelValues(lvs ...string) StateSet {
+	s, err := v.GetMetricWithLabelValues(lvs...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// With works as GetMetricWith, but panics where GetMetricWithLabels would have
+// returned an error. Not returning an error allows shortcuts like
+//
+//	myVec.With(prometheus.Labels{"backend": "backend-1"}).Set("connected", true)
+func (v *StateSetVec) With(labels Labels) StateSet {
+	s, err := v.GetMetricWith(labels)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// DeleteLabelValues removes the StateSet where the variable labels are the
+// same as those passed in as labels (same order as the variable labels in
+// StateSetVecOpts). It returns true if a StateSet was deleted.
+func (v *StateSetVec) DeleteLabelValues(lvs ...string) bool {
+	stateLVs := make([]string, len(lvs)+1)
+	copy(stateLVs, lvs)
+	deleted := false
+	for _, state := range v.cfg.states {
+		stateLVs[len(lvs)] = state
+		if v.MetricVec.DeleteLabelValues(stateLVs...) {
+			deleted = true
+		}
+	}
+	return deleted
+}
+
+// Delete deletes the StateSet where the variable labels are the same as those
+// passed in as labels. It returns true if a StateSet was deleted.
+func (v *StateSetVec) Delete(labels Labels) bool {
+	stateLabel := v.cfg.desc.fqName
+	if _, ok := labels[stateLabel]; ok {
+		return false
+	}
+	stateLabels := make(Labels, len(labels)+1)
+	for name, value := range labels {
+		stateLabels[name] = value
+	}
+	deleted := false
+	for _, state := range v.cfg.states {
+		stateLabels[stateLabel] = state
+		if v.MetricVec.Delete(stateLabels) {
+			deleted = true
+		}
+	}
+	return deleted
+}
+
+// DeletePartialMatch deletes all StateSets where the variable labels contain
+// all of those passed in as labels. The order of the labels does not matter.
+// It returns the number of StateSets deleted.
+func (v *StateSetVec) DeletePartialMatch(labels Labels) int {
+	if _, ok := labels[v.cfg.desc.fqName]; ok {
+		return 0
+	}
+	return v.MetricVec.DeletePartialMatch(labels) / len(v.cfg.states)
+}
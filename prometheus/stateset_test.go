@@ -0,0 +1,151 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestStateSet(t *testing.T) {
+	reg := NewPedanticRegistry()
+	features := NewStateSet(StateSetOpts{
+		Name:   "feature",
+		Help:   "Enabled features.",
+		States: []string{"a", "b", "c"},
+	})
+	reg.MustRegister(features)
+	features.Set("a", true)
+	features.Set("c", true)
+	features.Set("a", false)
+
+	if got, want := gatherAsText(t, reg, expfmt.FmtText), `# HELP feature Enabled features.
+# TYPE feature gauge
+feature{feature="a"} 0
+feature{feature="b"} 0
+feature{feature="c"} 1
+`; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	// The stateset type is only known via the registry, see the promhttp
+	// package for the exposition in the OpenMetrics text format.
+	if got, want := reg.OpenMetricsTypes(), map[string]string{"feature": "stateset"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got OpenMetrics types %v, want %v", got, want)
+	}
+
+	if features.Enabled("a") || !features.Enabled("c") {
+		t.Error("unexpected enabled states")
+	}
+	assertPanics(t, func() { features.Set("d", true) }, "unknown state")
+}
+
+func TestStateSetVecEnum(t *testing.T) {
+	reg := NewPedanticRegistry()
+	vec := NewStateSetVec(StateSetOpts{
+		Name:   "connection_state",
+		Help:   "State of the connection to each backend.",
+		States: []string{"idle", "connecting", "connected"},
+		Enum:   true,
+	}, []string{"backend"})
+	reg.MustRegister(vec)
+
+	// The first state is enabled initially.
+	a := vec.WithLabelValues("a")
+	if !a.Enabled("idle") {
+		t.Error("initial state is not enabled")
+	}
+	a.Set("connected", true)
+	vec.With(Labels{"backend": "b"}).Set("connecting", true)
+	// Disabling a disabled state is a no-op.
+	a.Set("idle", false)
+	assertPanics(t, func() { a.Set("connected", false) }, "disabling the only enabled state")
+
+	if got, want := gatherAsText(t, reg, expfmt.FmtText), `# HELP connection_state State of the connection to each backend.
+# TYPE connection_state gauge
+connection_state{backend="a",connection_state="connected"} 1
+connection_state{backend="a",connection_state="connecting"} 0
+connection_state{backend="a",connection_state="idle"} 0
+connection_state{backend="b",connection_state="connected"} 0
+connection_state{backend="b",connection_state="connecting"} 1
+connection_state{backend="b",connection_state="idle"} 0
+`; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// Accessing an existing StateSet again keeps its states.
+	if !vec.WithLabelValues("a").Enabled("connected") {
+		t.Error("state was reset when accessing the StateSet again")
+	}
+
+	if _, err := vec.GetMetricWith(Labels{"connection_state": "idle"}); err == nil {
+		t.Error("using the state label as variable label succeeded unexpectedly")
+	}
+	if _, err := vec.GetMetricWithLabelValues("a", "b"); err == nil {
+		t.Error("using too many label values succeeded unexpectedly")
+	}
+
+	// The embedded MetricVec operates on the series of the individual
+	// states.
+	var series int
+	vec.Range(func(Labels, Metric) bool {
+		series++
+		return true
+	})
+	if series != 6 {
+		t.Errorf("ranged over %d series, want 6", series)
+	}
+
+	if !vec.DeleteLabelValues("a") {
+		t.Error("deleting StateSet a failed")
+	}
+	if vec.Delete(Labels{"backend": "a"}) {
+		t.Error("deleting StateSet a again succeeded unexpectedly")
+	}
+	if got := vec.DeletePartialMatch(Labels{"backend": "b"}); got != 1 {
+		t.Errorf("deleted %d StateSets, want 1", got)
+	}
+	if got := gatherAsText(t, reg, expfmt.FmtText); got != "" {
+		t.Errorf("got %q after deleting all StateSets", got)
+	}
+
+	vec.WithLabelValues("c")
+	vec.Reset()
+	if got := gatherAsText(t, reg, expfmt.FmtText); got != "" {
+		t.Errorf("got %q after resetting the vector", got)
+	}
+}
+
+func TestStateSetValidation(t *testing.T) {
+	for name, opts := range map[string]StateSetOpts{
+		"no states":        {Name: "state"},
+		"empty state":      {Name: "state", States: []string{"a", ""}},
+		"duplicate state":  {Name: "state", States: []string{"a", "a"}},
+		"invalid label":    {Name: "state:colon", States: []string{"a"}},
+		"state label used": {Name: "state", States: []string{"a"}, ConstLabels: Labels{"state": "x"}},
+	} {
+		assertPanics(t, func() { NewStateSet(opts) }, name)
+	}
+}
+
+func assertPanics(t *testing.T, f func(), what string) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s: expected panic", what)
+		}
+	}()
+	f()
+}
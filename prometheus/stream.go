@@ -0,0 +1,322 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/client_golang/prometheus/internal"
+)
+
+// StreamingGatherer is implemented by Gatherers and TransactionalGatherers that
+// can hand over the gathered MetricFamilies one at a time rather than all of
+// them at once, so that they don't have to be kept in memory at the same time.
+// Registry and the TransactionalGatherer returned by ToTransactionalGatherer
+// implement StreamingGatherer. The promhttp package makes use of it if
+// HandlerOpts.EnableStreaming is set.
+type StreamingGatherer interface {
+	// GatherStream gathers like the GatherWithContext method of a
+	// ContextGatherer, but instead of returning the MetricFamilies, it
+	// calls f with each of them, ordered by name and with their metrics
+	// sorted in the same way as in the result of Gather. The calls of f
+	// happen one after another, and GatherStream only returns after the
+	// last call has returned. If f returns an error, gathering is aborted
+	// and that error is returned. Otherwise, the returned error reports
+	// the errors encountered during gathering, in the same way as the
+	// error returned by Gather.
+	GatherStream(ctx context.Context, f func(*dto.MetricFamily) error) error
+}
+
+// GatherStream implements StreamingGatherer. Each MetricFamily is handed over
+// as soon as all Collectors describing metrics with its name have finished
+// collecting (and all MetricFamilies with names sorting before it have been
+// handed over). To keep the memory footprint low, the Collectors are collected
+// in the order of their lexicographically first described metric name, and at
+// most GOMAXPROCS Collectors are collected at the same time. The peak memory
+// usage is therefore bounded by the metrics of the Collectors in flight and
+// those waiting for a Collector of an earlier MetricFamily, rather than by all
+// the metrics of the Registry.
+//
+// Unchecked Collectors (see Register) are collected first, and no MetricFamily
+// is handed over before all of them have finished. Metrics collected by a
+// Collector that hasn't described their name are only handed over once all
+// Collectors have finished. If such a metric sorts before a MetricFamily that
+// has been handed over already, it is dropped and reported as an error.
+func (r *Registry) GatherStream(ctx context.Context, f func(*dto.MetricFamily) error) error {
+	r.mtx.RLock()
+	var (
+		jobs              = make([]streamJob, 0, len(r.uncheckedCollectors)+len(r.collectorsByID))
+		checkedJobs       = make([]streamJob, 0, len(r.collectorsByID))
+		registeredDescIDs map[uint64]struct{} // Only used for pedantic checks
+		collectorTimeout  = r.collectorTimeout
+		collectorTimeouts = r.collectorTimeouts
+		collectorCancels  = r.collectorCancellations
+	)
+	for _, c := range r.uncheckedCollectors {
+		jobs = append(jobs, streamJob{collector: c})
+	}
+	for id, c := range r.collectorsByID {
+		checkedJobs = append(checkedJobs, streamJob{collector: c, names: r.namesByID[id], checked: true})
+	}
+	if r.pedanticChecksEnabled {
+		registeredDescIDs = make(map[uint64]struct{}, len(r.descIDs))
+		for id := range r.descIDs {
+			registeredDescIDs[id] = struct{}{}
+		}
+	}
+	r.mtx.RUnlock()
+
+	if len(jobs)+len(checkedJobs) == 0 {
+		// Fast path.
+		return nil
+	}
+	sort.Slice(checkedJobs, func(i, j int) bool {
+		return checkedJobs[i].names[0] < checkedJobs[j].names[0]
+	})
+	jobs = append(jobs, checkedJobs...)
+
+	var (
+		s              = newFamilyStream(jobs, registeredDescIDs, f)
+		events         = make(chan streamEvent, capMetricChan)
+		queue          = make(chan int, len(jobs))
+		abort          = make(chan struct{})
+		wg             sync.WaitGroup
+		errs           MultiError // The collected errors to return in the end.
+		collectErrsMtx sync.Mutex // Protects collectErrs.
+		collectErrs    MultiError // Errors of aborted Collectors.
+	)
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+
+	collect := func(collector Collector, ch chan<- Metric) {
+		if err := collectWithTimeout(ctx, collectorTimeout, collector, ch); err != nil {
+			countAbortedCollection(err, collectorTimeouts, collectorCancels)
+			collectErrsMtx.Lock()
+			collectErrs = append(collectErrs, err)
+			collectErrsMtx.Unlock()
+		}
+	}
+
+	collectWorker := func() {
+		defer wg.Done()
+		for i := range queue {
+			select {
+			case <-abort:
+				// Skip the remaining Collectors.
+			default:
+				mc := make(chan Metric, capMetricChan)
+				go func(c Collector) {
+					collect(c, mc)
+					close(mc)
+				}(jobs[i].collector)
+				for m := range mc {
+					events <- streamEvent{job: i, metric: m}
+				}
+			}
+			events <- streamEvent{job: i, done: true}
+		}
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go collectWorker()
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+	// Drain events in case of premature return.
+	defer func() {
+		close(abort)
+		for range events {
+		}
+	}()
+
+	for e := range events {
+		if !e.done {
+			errs.Append(s.add(e.metric, jobs[e.job].checked))
+			continue
+		}
+		s.finish(jobs[e.job])
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	s.finishUndescribed()
+	if err := s.flush(); err != nil {
+		return err
+	}
+	// All workers are done once events is closed, so collectErrs can be
+	// accessed without locking.
+	errs = append(errs, collectErrs...)
+	return errs.MaybeUnwrap()
+}
+
+// streamJob is a Collector to be collected by GatherStream.
+type streamJob struct {
+	collector Collector
+	names     []string // Sorted names of the described metrics.
+	checked   bool
+}
+
+// streamEvent is sent by the workers of GatherStream for each collected metric
+// and once a Collector has finished.
+type streamEvent struct {
+	job    int // Index of the streamJob.
+	metric Metric
+	done   bool
+}
+
+// familyStream keeps track of the MetricFamilies collected by GatherStream and
+// hands them over in order once they are complete.
+type familyStream struct {
+	names       []string       // Sorted names of all known MetricFamilies.
+	next        int            // Index in names of the next MetricFamily to hand over.
+	pending     map[string]int // Number of unfinished Collectors by name.
+	unchecked   int            // Number of unfinished unchecked Collectors.
+	undescribed []string       // Names collected from Collectors not describing them.
+
+	// Handed over MetricFamilies are kept without metrics in families to
+	// check for suffix collisions.
+	families          map[string]*dto.MetricFamily
+	hashes            map[string]map[uint64]struct{} // Metric hashes by name.
+	registeredDescIDs map[uint64]struct{}
+	f                 func(*dto.MetricFamily) error
+}
+
+func newFamilyStream(
+	jobs []streamJob,
+	registeredDescIDs map[uint64]struct{},
+	f func(*dto.MetricFamily) error,
+) *familyStream {
+	s := &familyStream{
+		pending:           map[string]int{},
+		families:          map[string]*dto.MetricFamily{},
+		hashes:            map[string]map[uint64]struct{}{},
+		registeredDescIDs: registeredDescIDs,
+		f:                 f,
+	}
+	for _, j := range jobs {
+		if !j.checked {
+			s.unchecked++
+			continue
+		}
+		for _, name := range j.names {
+			s.pending[name]++
+		}
+	}
+	s.names = make([]string, 0, len(s.pending))
+	for name := range s.pending {
+		s.names = append(s.names, name)
+	}
+	sort.Strings(s.names)
+	return s
+}
+
+// add adds the provided metric, collected by a checked or unchecked Collector,
+// to its MetricFamily.
+func (s *familyStream) add(metric Metric, checked bool) error {
+	desc := metric.Desc()
+	// Wrapped metrics collected by an unchecked Collector can have an
+	// invalid Desc.
+	if desc.err != nil {
+		return desc.err
+	}
+	name := desc.fqName
+	i := sort.SearchStrings(s.names, name)
+	if i < s.next {
+		return fmt.Errorf(
+			"collected metric %s with a name not described by its Collector after later metric families have been handed over already",
+			name,
+		)
+	}
+	if i == len(s.names) || s.names[i] != name {
+		s.names = append(s.names, "")
+		copy(s.names[i+1:], s.names[i:])
+		s.names[i] = name
+		if checked {
+			// Wait for all Collectors, as we cannot know which of
+			// them will collect metrics with this name.
+			s.pending[name] = 1
+			s.undescribed = append(s.undescribed, name)
+		}
+	}
+	hashes, ok := s.hashes[name]
+	if !ok {
+		hashes = map[uint64]struct{}{}
+		s.hashes[name] = hashes
+	}
+	var registeredDescIDs map[uint64]struct{}
+	if checked {
+		registeredDescIDs = s.registeredDescIDs
+	}
+	return processMetric(metric, s.families, hashes, registeredDescIDs)
+}
+
+// finish records that the Collector of the provided job has finished.
+func (s *familyStream) finish(j streamJob) {
+	if !j.checked {
+		s.unchecked--
+		return
+	}
+	for _, name := range j.names {
+		s.pending[name]--
+	}
+}
+
+// finishUndescribed records that all Collectors have finished, which completes
+// the MetricFamilies with names not described by their Collectors.
+func (s *familyStream) finishUndescribed() {
+	for _, name := range s.undescribed {
+		s.pending[name]--
+	}
+}
+
+// flush hands over all MetricFamilies that are complete and not preceded by an
+// incomplete one. It returns the first error returned by f.
+func (s *familyStream) flush() error {
+	if s.unchecked > 0 {
+		return nil
+	}
+	for s.next < len(s.names) {
+		name := s.names[s.next]
+		if s.pending[name] > 0 {
+			return nil
+		}
+		s.next++
+		delete(s.hashes, name)
+		mf, ok := s.families[name]
+		if !ok || len(mf.Metric) == 0 {
+			continue
+		}
+		s.families[name] = &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+		sort.Sort(internal.MetricSorter(mf.Metric))
+		if err := s.f(mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
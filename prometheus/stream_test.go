@@ -0,0 +1,205 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// describingCollector describes the provided Descs and collects with the
+// provided function.
+type describingCollector struct {
+	descs       []*prometheus.Desc
+	collectFunc func(ch chan<- prometheus.Metric)
+}
+
+func (c *describingCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range c.descs {
+		ch <- d
+	}
+}
+
+func (c *describingCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collectFunc(ch)
+}
+
+func gatherStream(t *testing.T, sg prometheus.StreamingGatherer) ([]*dto.MetricFamily, error) {
+	t.Helper()
+	var mfs []*dto.MetricFamily
+	err := sg.GatherStream(context.Background(), func(mf *dto.MetricFamily) error {
+		mfs = append(mfs, mf)
+		return nil
+	})
+	return mfs, err
+}
+
+func TestGatherStreamMatchesGather(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	cv := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "b_total", Help: "help"}, []string{"l"})
+	for _, l := range []string{"z", "a", "m"} {
+		cv.WithLabelValues(l).Inc()
+	}
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "a", Help: "help"})
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "c", Help: "help"})
+	h.Observe(1)
+	desc := prometheus.NewDesc("unchecked", "help", nil, nil)
+	unchecked := &customCollector{collectFunc: func(ch chan<- prometheus.Metric) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1)
+	}}
+	reg.MustRegister(cv, g, h, unchecked, prometheus.NewGoCollector())
+
+	want, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := gatherStream(t, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d metric families, want %d", len(got), len(want))
+	}
+	for i := range want {
+		// Skip the values of the Go collector, which change between
+		// gatherings.
+		if strings.HasPrefix(want[i].GetName(), "go_") {
+			if got[i].GetName() != want[i].GetName() {
+				t.Errorf("got metric family %q, want %q", got[i].GetName(), want[i].GetName())
+			}
+			continue
+		}
+		if got[i].String() != want[i].String() {
+			t.Errorf("got %s, want %s", got[i], want[i])
+		}
+	}
+
+	// The same applies to the TransactionalGatherer wrapping the Registry.
+	tg := prometheus.ToTransactionalGatherer(prometheus.Gatherers{reg}).(prometheus.StreamingGatherer)
+	got, err = gatherStream(t, tg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d metric families from wrapped gatherer, want %d", len(got), len(want))
+	}
+}
+
+func TestGatherStreamHandsOverEarly(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	descA := prometheus.NewDesc("a", "help", nil, nil)
+	descB := prometheus.NewDesc("b", "help", nil, nil)
+	handedOverA := make(chan struct{})
+	reg.MustRegister(
+		&describingCollector{
+			descs: []*prometheus.Desc{descA},
+			collectFunc: func(ch chan<- prometheus.Metric) {
+				ch <- prometheus.MustNewConstMetric(descA, prometheus.GaugeValue, 1)
+			},
+		},
+		&describingCollector{
+			descs: []*prometheus.Desc{descB},
+			collectFunc: func(ch chan<- prometheus.Metric) {
+				select {
+				case <-handedOverA:
+				case <-time.After(time.Second):
+					t.Error("metric family a not handed over while collecting b")
+				}
+				ch <- prometheus.MustNewConstMetric(descB, prometheus.GaugeValue, 1)
+			},
+		},
+	)
+
+	var names []string
+	err := reg.GatherStream(context.Background(), func(mf *dto.MetricFamily) error {
+		names = append(names, mf.GetName())
+		if mf.GetName() == "a" {
+			close(handedOverA)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(names, ","), "a,b"; got != want {
+		t.Errorf("got metric families %q, want %q", got, want)
+	}
+}
+
+func TestGatherStreamUndescribedMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	descA := prometheus.NewDesc("a", "help", nil, nil)
+	descB := prometheus.NewDesc("b", "help", nil, nil)
+	descC := prometheus.NewDesc("c", "help", nil, nil)
+	descD := prometheus.NewDesc("d", "help", nil, nil)
+	handedOverB := make(chan struct{})
+	reg.MustRegister(
+		&describingCollector{
+			descs: []*prometheus.Desc{descB},
+			collectFunc: func(ch chan<- prometheus.Metric) {
+				ch <- prometheus.MustNewConstMetric(descB, prometheus.GaugeValue, 1)
+			},
+		},
+		&describingCollector{
+			descs: []*prometheus.Desc{descC},
+			collectFunc: func(ch chan<- prometheus.Metric) {
+				<-handedOverB
+				ch <- prometheus.MustNewConstMetric(descA, prometheus.GaugeValue, 1)
+				ch <- prometheus.MustNewConstMetric(descC, prometheus.GaugeValue, 1)
+				ch <- prometheus.MustNewConstMetric(descD, prometheus.GaugeValue, 1)
+			},
+		},
+	)
+
+	var names []string
+	err := reg.GatherStream(context.Background(), func(mf *dto.MetricFamily) error {
+		names = append(names, mf.GetName())
+		if mf.GetName() == "b" {
+			close(handedOverB)
+		}
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "collected metric a ") {
+		t.Errorf("got error %v, want error about metric a", err)
+	}
+	if got, want := strings.Join(names, ","), "b,c,d"; got != want {
+		t.Errorf("got metric families %q, want %q", got, want)
+	}
+}
+
+func TestGatherStreamAbort(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	for _, name := range []string{"a", "b", "c"} {
+		reg.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: "help"}))
+	}
+	abort := errors.New("abort")
+	var calls int
+	err := reg.GatherStream(context.Background(), func(*dto.MetricFamily) error {
+		calls++
+		return abort
+	})
+	if !errors.Is(err, abort) {
+		t.Errorf("got error %v, want %v", err, abort)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls after abort, want 1", calls)
+	}
+}
@@ -24,7 +24,6 @@ import (
 
 	dto "github.com/prometheus/client_model/go"
 
-	"github.com/beorn7/perks/quantile"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -100,6 +99,14 @@ type SummaryOpts struct {
 	// string.
 	Help string
 
+	// Unit is the unit of this Summary, e.g. "seconds" or "bytes". It is
+	// optional. If set, the fully-qualified name must end with the unit
+	// (in case of a counter followed by "_total"), as required by
+	// OpenMetrics. The unit is exposed in the OpenMetrics text format.
+	//
+	// Metrics with the same fully-qualified name must have the same Unit.
+	Unit string
+
 	// ConstLabels are used to attach fixed labels to this metric. Metrics
 	// with the same fully-qualified name must have the same label names in
 	// their ConstLabels.
@@ -145,6 +152,13 @@ type SummaryOpts struct {
 	// is the internal buffer size of the underlying package
 	// "github.com/bmizerany/perks/quantile").
 	BufCap uint32
+
+	// QuantileEstimator creates the estimators used to calculate the
+	// quantiles in Objectives. The default value is CKMSQuantileEstimator.
+	// See DDSketchQuantileEstimator and TDigestQuantileEstimator for
+	// alternatives that are cheaper for many objectives. Note that those
+	// ignore the allowed errors in Objectives.
+	QuantileEstimator QuantileEstimatorFactory
 }
 
 // SummaryVecOpts bundles the options to create a SummaryVec metric.
@@ -157,6 +171,15 @@ type SummaryVecOpts struct {
 	// of labels. Each label value will be constrained with the optional Contraint
 	// function, if provided.
 	VariableLabels ConstrainableLabels
+
+	// CardinalityLimit caps the number of children the vector may hold
+	// and defines what happens to new label value combinations once the
+	// cap is reached. The zero value imposes no limit.
+	CardinalityLimit CardinalityLimit
+	// TTL, if positive, is the duration after which children that have
+	// been neither accessed nor updated are deleted from the vector. See
+	// MetricVec.SetTTL for details.
+	TTL time.Duration
 }
 
 // Problem with the sliding-window decay algorithm... The Merge method of
@@ -175,11 +198,15 @@ type SummaryVecOpts struct {
 // can't be used anymore.
 
 // NewSummary creates a new Summary based on the provided SummaryOpts.
+//
+// The returned implementation also implements BatchObserver and
+// CreatedTimestamper. It is safe to perform the corresponding type assertions.
 func NewSummary(opts SummaryOpts) Summary {
 	return newSummary(
-		NewDesc(
+		NewDescWithUnit(
 			BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
 			opts.Help,
+			opts.Unit,
 			nil,
 			opts.ConstLabels,
 		),
@@ -222,12 +249,17 @@ func newSummary(desc *Desc, opts SummaryOpts, labelValues ...string) Summary {
 		opts.BufCap = DefBufCap
 	}
 
+	if opts.QuantileEstimator == nil {
+		opts.QuantileEstimator = CKMSQuantileEstimator
+	}
+
 	if len(opts.Objectives) == 0 {
 		// Use the lock-free implementation of a Summary without objectives.
 		s := &noObjectivesSummary{
 			desc:       desc,
 			labelPairs: MakeLabelPairs(desc, labelValues),
 			counts:     [2]*summaryCounts{{}, {}},
+			createdTs:  time.Now(),
 		}
 		s.init(s) // Init self-collection.
 		return s
@@ -238,8 +270,10 @@ func newSummary(desc *Desc, opts SummaryOpts, labelValues ...string) Summary {
 
 		objectives:       opts.Objectives,
 		sortedObjectives: make([]float64, 0, len(opts.Objectives)),
+		newEstimator:     opts.QuantileEstimator,
 
 		labelPairs: MakeLabelPairs(desc, labelValues),
+		createdTs:  time.Now(),
 
 		hotBuf:         make([]float64, 0, opts.BufCap),
 		coldBuf:        make([]float64, 0, opts.BufCap),
@@ -273,17 +307,19 @@ type summary struct {
 
 	objectives       map[float64]float64
 	sortedObjectives []float64
+	newEstimator     QuantileEstimatorFactory
 
 	labelPairs []*dto.LabelPair
+	createdTs  time.Time
 
 	sum float64
 	cnt uint64
 
 	hotBuf, coldBuf []float64
 
-	streams                          []*quantile.Stream
+	streams                          []QuantileEstimator
 	streamDuration                   time.Duration
-	headStream                       *quantile.Stream
+	headStream                       QuantileEstimator
 	headStreamIdx                    int
 	headStreamExpTime, hotBufExpTime time.Time
 }
@@ -292,6 +328,11 @@ func (s *summary) Desc() *Desc {
 	return s.desc
 }
 
+// CreatedTimestamp implements CreatedTimestamper.
+func (s *summary) CreatedTimestamp() time.Time {
+	return s.createdTs
+}
+
 func (s *summary) Observe(v float64) {
 	s.bufMtx.Lock()
 	defer s.bufMtx.Unlock()
@@ -306,6 +347,52 @@ func (s *summary) Observe(v float64) {
 	}
 }
 
+// ObserveMany implements BatchObserver.
+func (s *summary) ObserveMany(values []float64) {
+	s.observeBatch(uint64(len(values)), func(buf []float64) {
+		values = values[copy(buf, values):]
+	})
+}
+
+// ObserveN implements BatchObserver. Note that the quantile estimation still
+// has to process each of the n observations separately.
+func (s *summary) ObserveN(v float64, n uint64) {
+	s.observeBatch(n, func(buf []float64) {
+		for i := range buf {
+			buf[i] = v
+		}
+	})
+}
+
+// observeBatch adds n observations to hotBuf, flushing it whenever it is full.
+// fill is called with the free part of hotBuf (but at most the remaining number
+// of observations) and has to fill it completely with the next observations.
+func (s *summary) observeBatch(n uint64, fill func(buf []float64)) {
+	if n == 0 {
+		return
+	}
+	s.bufMtx.Lock()
+	defer s.bufMtx.Unlock()
+
+	now := time.Now()
+	if now.After(s.hotBufExpTime) {
+		s.asyncFlush(now)
+	}
+	for n > 0 {
+		free := uint64(cap(s.hotBuf) - len(s.hotBuf))
+		if free > n {
+			free = n
+		}
+		l := len(s.hotBuf)
+		s.hotBuf = s.hotBuf[:l+int(free)]
+		fill(s.hotBuf[l:])
+		n -= free
+		if len(s.hotBuf) == cap(s.hotBuf) {
+			s.asyncFlush(now)
+		}
+	}
+}
+
 func (s *summary) Write(out *dto.Metric) error {
 	sum := &dto.Summary{}
 	qs := make([]*dto.Quantile, 0, len(s.objectives))
@@ -319,6 +406,7 @@ func (s *summary) Write(out *dto.Metric) error {
 	s.flushColdBuf()
 	sum.SampleCount = proto.Uint64(s.cnt)
 	sum.SampleSum = proto.Float64(s.sum)
+	sum.CreatedTimestamp = createdTimestampProto(s.createdTs)
 
 	for _, rank := range s.sortedObjectives {
 		var q float64
@@ -345,8 +433,8 @@ func (s *summary) Write(out *dto.Metric) error {
 	return nil
 }
 
-func (s *summary) newStream() *quantile.Stream {
-	return quantile.NewTargeted(s.objectives)
+func (s *summary) newStream() QuantileEstimator {
+	return s.newEstimator(s.objectives)
 }
 
 // asyncFlush needs bufMtx locked.
@@ -440,12 +528,18 @@ type noObjectivesSummary struct {
 	counts [2]*summaryCounts
 
 	labelPairs []*dto.LabelPair
+	createdTs  time.Time
 }
 
 func (s *noObjectivesSummary) Desc() *Desc {
 	return s.desc
 }
 
+// CreatedTimestamp implements CreatedTimestamper.
+func (s *noObjectivesSummary) CreatedTimestamp() time.Time {
+	return s.createdTs
+}
+
 func (s *noObjectivesSummary) Observe(v float64) {
 	// We increment h.countAndHotIdx so that the counter in the lower
 	// 63 bits gets incremented. At the same time, we get the new value
@@ -465,6 +559,33 @@ func (s *noObjectivesSummary) Observe(v float64) {
 	atomic.AddUint64(&hotCounts.count, 1)
 }
 
+// ObserveMany implements BatchObserver.
+func (s *noObjectivesSummary) ObserveMany(values []float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	s.observeN(sum, uint64(len(values)))
+}
+
+// ObserveN implements BatchObserver.
+func (s *noObjectivesSummary) ObserveN(v float64, n uint64) {
+	s.observeN(v*float64(n), n)
+}
+
+// observeN adds n observations with the provided sum.
+func (s *noObjectivesSummary) observeN(sum float64, n uint64) {
+	if n == 0 {
+		return
+	}
+	// See Observe for the algorithm.
+	hotCounts := s.counts[atomic.AddUint64(&s.countAndHotIdx, n)>>63]
+	atomicAddFloat(&hotCounts.sumBits, sum)
+	// Increment count last as we take it as a signal that the observations
+	// are complete.
+	atomic.AddUint64(&hotCounts.count, n)
+}
+
 func (s *noObjectivesSummary) Write(out *dto.Metric) error {
 	// For simplicity, we protect this whole method by a mutex. It is not in
 	// the hot path, i.e. Observe is called much more often than Write. The
@@ -490,8 +611,9 @@ func (s *noObjectivesSummary) Write(out *dto.Metric) error {
 	}
 
 	sum := &dto.Summary{
-		SampleCount: proto.Uint64(count),
-		SampleSum:   proto.Float64(math.Float64frombits(atomic.LoadUint64(&coldCounts.sumBits))),
+		SampleCount:      proto.Uint64(count),
+		SampleSum:        proto.Float64(math.Float64frombits(atomic.LoadUint64(&coldCounts.sumBits))),
+		CreatedTimestamp: createdTimestampProto(s.createdTs),
 	}
 
 	out.Summary = sum
@@ -554,17 +676,19 @@ func (v2) NewSummaryVec(opts SummaryVecOpts) *SummaryVec {
 			panic(errQuantileLabelNotAllowed)
 		}
 	}
-	desc := V2.NewDesc(
+	desc := V2.NewDescWithUnit(
 		BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
 		opts.Help,
+		opts.Unit,
 		opts.VariableLabels,
 		opts.ConstLabels,
 	)
-	return &SummaryVec{
-		MetricVec: NewMetricVec(desc, func(lvs ...string) Metric {
-			return newSummary(desc, opts.SummaryOpts, lvs...)
-		}),
-	}
+	vec := NewMetricVec(desc, func(lvs ...string) Metric {
+		return newSummary(desc, opts.SummaryOpts, lvs...)
+	})
+	vec.SetCardinalityLimit(opts.CardinalityLimit)
+	vec.SetTTL(opts.TTL)
+	return &SummaryVec{vec}
 }
 
 // GetMetricWithLabelValues returns the Summary for the given slice of label
@@ -681,18 +805,25 @@ type constSummary struct {
 	sum        float64
 	quantiles  map[float64]float64
 	labelPairs []*dto.LabelPair
+	createdTs  time.Time
 }
 
 func (s *constSummary) Desc() *Desc {
 	return s.desc
 }
 
+// CreatedTimestamp implements CreatedTimestamper.
+func (s *constSummary) CreatedTimestamp() time.Time {
+	return s.createdTs
+}
+
 func (s *constSummary) Write(out *dto.Metric) error {
 	sum := &dto.Summary{}
 	qs := make([]*dto.Quantile, 0, len(s.quantiles))
 
 	sum.SampleCount = proto.Uint64(s.count)
 	sum.SampleSum = proto.Float64(s.sum)
+	sum.CreatedTimestamp = createdTimestampProto(s.createdTs)
 
 	for rank, q := range s.quantiles {
 		qs = append(qs, &dto.Quantile{
@@ -764,3 +895,40 @@ func MustNewConstSummary(
 	}
 	return m
 }
+
+// NewConstSummaryWithCreatedTimestamp does the same thing as NewConstSummary,
+// but the returned Metric also implements CreatedTimestamper, returning the
+// provided created timestamp ct.
+func NewConstSummaryWithCreatedTimestamp(
+	desc *Desc,
+	count uint64,
+	sum float64,
+	quantiles map[float64]float64,
+	ct time.Time,
+	labelValues ...string,
+) (Metric, error) {
+	m, err := NewConstSummary(desc, count, sum, quantiles, labelValues...)
+	if err != nil {
+		return nil, err
+	}
+	m.(*constSummary).createdTs = ct
+	return m, nil
+}
+
+// MustNewConstSummaryWithCreatedTimestamp is a version of
+// NewConstSummaryWithCreatedTimestamp that panics where
+// NewConstSummaryWithCreatedTimestamp would have returned an error.
+func MustNewConstSummaryWithCreatedTimestamp(
+	desc *Desc,
+	count uint64,
+	sum float64,
+	quantiles map[float64]float64,
+	ct time.Time,
+	labelValues ...string,
+) Metric {
+	m, err := NewConstSummaryWithCreatedTimestamp(desc, count, sum, quantiles, ct, labelValues...)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
@@ -420,3 +420,47 @@ func getBounds(vars []float64, q, ε float64) (min, max float64) {
 	}
 	return
 }
+
+func TestSummaryObserveBatch(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	for _, objectives := range []map[float64]float64{nil, {0.5: 0.05, 0.9: 0.01}} {
+		newSummary := func() Summary {
+			return NewSummary(SummaryOpts{
+				Name:       "test",
+				Help:       "test help",
+				Objectives: objectives,
+				BufCap:     4, // Small buffer to exercise flushing.
+			})
+		}
+		want, many, n := newSummary(), newSummary(), newSummary()
+		for _, v := range values {
+			want.Observe(v)
+			want.Observe(v)
+		}
+		for _, v := range values {
+			want.Observe(v)
+		}
+		many.(BatchObserver).ObserveMany(values)
+		many.(BatchObserver).ObserveMany(values)
+		many.(BatchObserver).ObserveMany(values)
+		for _, v := range values {
+			n.(BatchObserver).ObserveN(v, 3)
+		}
+
+		wantM := &dto.Metric{}
+		if err := want.Write(wantM); err != nil {
+			t.Fatal(err)
+		}
+		wantM.Summary.CreatedTimestamp = nil
+		for name, got := range map[string]Summary{"ObserveMany": many, "ObserveN": n} {
+			gotM := &dto.Metric{}
+			if err := got.Write(gotM); err != nil {
+				t.Fatal(err)
+			}
+			gotM.Summary.CreatedTimestamp = nil
+			if gotM.String() != wantM.String() {
+				t.Errorf("%s with objectives %v: got %s, want %s", name, objectives, gotM, wantM)
+			}
+		}
+	}
+}
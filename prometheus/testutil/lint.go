@@ -33,7 +33,8 @@ func CollectAndLint(c prometheus.Collector, metricNames ...string) ([]promlint.P
 
 // GatherAndLint gathers all metrics from the provided Gatherer and checks them
 // with the linter in the promlint package. If any metricNames are provided,
-// only metrics with those names are checked.
+// only metrics with those names are checked. If the Gatherer implements
+// prometheus.UnitProvider, the declared units are checked, too.
 func GatherAndLint(g prometheus.Gatherer, metricNames ...string) ([]promlint.Problem, error) {
 	got, err := g.Gather()
 	if err != nil {
@@ -42,5 +43,8 @@ func GatherAndLint(g prometheus.Gatherer, metricNames ...string) ([]promlint.Pro
 	if metricNames != nil {
 		got = filterMetrics(got, metricNames)
 	}
+	if up, ok := g.(prometheus.UnitProvider); ok {
+		return promlint.NewWithMetricFamiliesAndUnits(got, up.Units()).Lint()
+	}
 	return promlint.NewWithMetricFamilies(got).Lint()
 }
@@ -37,6 +37,9 @@ type Linter struct {
 	// of them.
 	r   io.Reader
 	mfs []*dto.MetricFamily
+	// units maps metric family names to their declared units, see
+	// NewWithMetricFamiliesAndUnits.
+	units map[string]string
 }
 
 // A Problem is an issue detected by a Linter.
@@ -72,6 +75,18 @@ func NewWithMetricFamilies(mfs []*dto.MetricFamily) *Linter {
 	}
 }
 
+// NewWithMetricFamiliesAndUnits works like NewWithMetricFamilies but also
+// checks the declared units of metric families. The units map has metric
+// family names as keys and their units as values, as returned by
+// prometheus.UnitProvider. A declared unit has to be a base unit and has to be
+// the suffix of the metric name (ignoring a "_total" suffix).
+func NewWithMetricFamiliesAndUnits(mfs []*dto.MetricFamily, units map[string]string) *Linter {
+	return &Linter{
+		mfs:   mfs,
+		units: units,
+	}
+}
+
 // Lint performs a linting pass, returning a slice of Problems indicating any
 // issues found in the metrics stream. The slice is sorted by metric name
 // and issue description.
@@ -91,11 +106,11 @@ func (l *Linter) Lint() ([]Problem, error) {
 				return nil, err
 			}
 
-			problems = append(problems, lint(mf)...)
+			problems = append(problems, lint(mf, l.units[mf.GetName()])...)
 		}
 	}
 	for _, mf := range l.mfs {
-		problems = append(problems, lint(mf)...)
+		problems = append(problems, lint(mf, l.units[mf.GetName()])...)
 	}
 
 	// Ensure deterministic output.
@@ -109,11 +124,12 @@ func (l *Linter) Lint() ([]Problem, error) {
 	return problems, nil
 }
 
-// lint is the entry point for linting a single metric.
-func lint(mf *dto.MetricFamily) []Problem {
+// lint is the entry point for linting a single metric. unit is the declared
+// unit of the metric, or empty if it has none.
+func lint(mf *dto.MetricFamily, unit string) []Problem {
 	fns := []func(mf *dto.MetricFamily) []Problem{
 		lintHelp,
-		lintMetricUnits,
+		func(mf *dto.MetricFamily) []Problem { return lintMetricUnits(mf, unit) },
 		lintCounter,
 		lintHistogramSummaryReserved,
 		lintMetricTypeInName,
@@ -143,19 +159,29 @@ func lintHelp(mf *dto.MetricFamily) []Problem {
 	return problems
 }
 
-// lintMetricUnits detects issues with metric unit names.
-func lintMetricUnits(mf *dto.MetricFamily) []Problem {
+// lintMetricUnits detects issues with metric unit names. If the metric has a
+// declared unit, it is checked instead of the unit detected in the name.
+func lintMetricUnits(mf *dto.MetricFamily, declared string) []Problem {
 	var problems []Problem
 
-	unit, base, ok := metricUnits(*mf.Name)
+	name := mf.GetName()
+	if declared != "" {
+		if !strings.HasSuffix(strings.TrimSuffix(name, "_total"), "_"+declared) {
+			problems = append(problems, newProblem(mf, fmt.Sprintf("metric name should end in declared unit %q", declared)))
+		}
+		// Check the declared unit rather than guessing it from the name.
+		name = declared
+	}
+
+	unit, base, ok := metricUnits(name)
 	if !ok {
 		// No known units detected.
-		return nil
+		return problems
 	}
 
 	// Unit is already a base unit.
 	if unit == base {
-		return nil
+		return problems
 	}
 
 	problems = append(problems, newProblem(mf, fmt.Sprintf("use base unit %q instead of %q", base, unit)))
@@ -193,7 +219,7 @@ func lintHistogramSummaryReserved(mf *dto.MetricFamily) []Problem {
 
 	var problems []Problem
 
-	isHistogram := t == dto.MetricType_HISTOGRAM
+	isHistogram := t == dto.MetricType_HISTOGRAM || t == dto.MetricType_GAUGE_HISTOGRAM
 	isSummary := t == dto.MetricType_SUMMARY
 
 	n := mf.GetName()
@@ -19,6 +19,9 @@ import (
 	"strings"
 	"testing"
 
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+
 	"github.com/prometheus/client_golang/prometheus/testutil/promlint"
 )
 
@@ -434,6 +437,40 @@ x_ounces 10
 	}
 }
 
+func TestLintDeclaredUnits(t *testing.T) {
+	mf := func(name string) *dto.MetricFamily {
+		return &dto.MetricFamily{
+			Name:   proto.String(name),
+			Help:   proto.String("Test metric."),
+			Type:   dto.MetricType_UNTYPED.Enum(),
+			Metric: []*dto.Metric{{Untyped: &dto.Untyped{Value: proto.Float64(10)}}},
+		}
+	}
+	mfs := []*dto.MetricFamily{
+		mf("good_seconds"),
+		mf("mismatch_bytes"),
+		mf("duration_milliseconds"),
+		mf("no_unit"),
+	}
+	units := map[string]string{
+		"good_seconds":          "seconds",
+		"mismatch_bytes":        "seconds",
+		"duration_milliseconds": "milliseconds",
+	}
+
+	problems, err := promlint.NewWithMetricFamiliesAndUnits(mfs, units).Lint()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []promlint.Problem{
+		{Metric: "duration_milliseconds", Text: `use base unit "seconds" instead of "milliseconds"`},
+		{Metric: "mismatch_bytes", Text: `metric name should end in declared unit "seconds"`},
+	}
+	if !reflect.DeepEqual(want, problems) {
+		t.Fatalf("unexpected problems:\n- want: %v\n-  got: %v", want, problems)
+	}
+}
+
 func TestLintCounter(t *testing.T) {
 	tests := []test{
 		{
@@ -251,13 +251,13 @@ func compare(got, want []*dto.MetricFamily) error {
 	var gotBuf, wantBuf bytes.Buffer
 	enc := expfmt.NewEncoder(&gotBuf, expfmt.FmtText)
 	for _, mf := range got {
-		if err := enc.Encode(mf); err != nil {
+		if err := enc.Encode(internal.GaugeHistogramAsHistogram(mf)); err != nil {
 			return fmt.Errorf("encoding gathered metrics failed: %w", err)
 		}
 	}
 	enc = expfmt.NewEncoder(&wantBuf, expfmt.FmtText)
 	for _, mf := range want {
-		if err := enc.Encode(mf); err != nil {
+		if err := enc.Encode(internal.GaugeHistogramAsHistogram(mf)); err != nil {
 			return fmt.Errorf("encoding expected metrics failed: %w", err)
 		}
 	}
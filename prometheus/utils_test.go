@@ -0,0 +1,48 @@
+// Copyright 2023 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// toNormalizedJSON returns the protojson encoding of m with stable
+// whitespace, as protojson (like the protobuf text format) randomly adds
+// whitespace to make its output unstable.
+func toNormalizedJSON(m proto.Message) string {
+	mAsJSON, err := protojson.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := json.Compact(buffer, mAsJSON); err != nil {
+		panic(err)
+	}
+	return buffer.String()
+}
+
+// normalizeSpaces replaces each run of spaces in s that is not an indentation
+// by a single space, which makes metrics in the compact protobuf text format
+// (e.g. in error messages) stable.
+func normalizeSpaces(s string) string {
+	return multipleSpaces.ReplaceAllString(s, "$1 ")
+}
+
+var multipleSpaces = regexp.MustCompile("([^ \n]) {2,}")
@@ -14,6 +14,7 @@
 package prometheus
 
 import (
+	"errors"
 	"fmt"
 	"sort"
 	"time"
@@ -55,6 +56,24 @@ func (v ValueType) ToDTO() *dto.MetricType {
 	}
 }
 
+// CreatedTimestamper is implemented by Metrics that know the time they were
+// created, i.e. the time from which on their cumulative values have been
+// accumulated. This is the case for the counters, histograms, and summaries
+// created by this package (including the children of vectors, which are
+// created anew after a deletion or a MetricVec.Reset) and for const metrics
+// created with NewConstMetricWithCreatedTimestamp,
+// NewConstHistogramWithCreatedTimestamp, or
+// NewConstSummaryWithCreatedTimestamp. The zero time is returned if the
+// created timestamp is unknown.
+//
+// Created timestamps allow to tell a counter reset apart from a restart of the
+// process. Known created timestamps are also written into the created_timestamp
+// field of the protobuf message by the Write method of the Metric. The promhttp
+// package exposes them as _created samples in the OpenMetrics text format.
+type CreatedTimestamper interface {
+	CreatedTimestamp() time.Time
+}
+
 // valueFunc is a generic metric for simple values retrieved on collect time
 // from a function. It implements Metric and Collector. Its effective type is
 // determined by ValueType. This is a low-level building block used by the
@@ -91,7 +110,7 @@ func (v *valueFunc) Desc() *Desc {
 }
 
 func (v *valueFunc) Write(out *dto.Metric) error {
-	return populateMetric(v.valType, v.function(), v.labelPairs, nil, out)
+	return populateMetric(v.valType, v.function(), v.labelPairs, nil, time.Time{}, out)
 }
 
 // NewConstMetric returns a metric with one fixed value that cannot be
@@ -102,6 +121,14 @@ func (v *valueFunc) Write(out *dto.Metric) error {
 // labelValues is not consistent with the variable labels in Desc or if Desc is
 // invalid.
 func NewConstMetric(desc *Desc, valueType ValueType, value float64, labelValues ...string) (Metric, error) {
+	m, err := newConstMetric(desc, valueType, value, time.Time{}, labelValues...)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func newConstMetric(desc *Desc, valueType ValueType, value float64, ct time.Time, labelValues ...string) (*constMetric, error) {
 	if desc.err != nil {
 		return nil, desc.err
 	}
@@ -110,13 +137,14 @@ func NewConstMetric(desc *Desc, valueType ValueType, value float64, labelValues
 	}
 
 	metric := &dto.Metric{}
-	if err := populateMetric(valueType, value, MakeLabelPairs(desc, labelValues), nil, metric); err != nil {
+	if err := populateMetric(valueType, value, MakeLabelPairs(desc, labelValues), nil, ct, metric); err != nil {
 		return nil, err
 	}
 
 	return &constMetric{
-		desc:   desc,
-		metric: metric,
+		desc:      desc,
+		metric:    metric,
+		createdTs: ct,
 	}, nil
 }
 
@@ -130,15 +158,48 @@ func MustNewConstMetric(desc *Desc, valueType ValueType, value float64, labelVal
 	return m
 }
 
+// NewConstMetricWithCreatedTimestamp does the same thing as NewConstMetric,
+// but the returned Metric also implements CreatedTimestamper, returning the
+// provided created timestamp ct. Created timestamps are only meaningful for
+// cumulative metrics, so an error is returned if valueType is not
+// CounterValue.
+func NewConstMetricWithCreatedTimestamp(desc *Desc, valueType ValueType, value float64, ct time.Time, labelValues ...string) (Metric, error) {
+	if valueType != CounterValue {
+		return nil, errors.New("created timestamps are only supported for counters")
+	}
+	m, err := newConstMetric(desc, valueType, value, ct, labelValues...)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// MustNewConstMetricWithCreatedTimestamp is a version of
+// NewConstMetricWithCreatedTimestamp that panics where
+// NewConstMetricWithCreatedTimestamp would have returned an error.
+func MustNewConstMetricWithCreatedTimestamp(desc *Desc, valueType ValueType, value float64, ct time.Time, labelValues ...string) Metric {
+	m, err := NewConstMetricWithCreatedTimestamp(desc, valueType, value, ct, labelValues...)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
 type constMetric struct {
-	desc   *Desc
-	metric *dto.Metric
+	desc      *Desc
+	metric    *dto.Metric
+	createdTs time.Time
 }
 
 func (m *constMetric) Desc() *Desc {
 	return m.desc
 }
 
+// CreatedTimestamp implements CreatedTimestamper.
+func (m *constMetric) CreatedTimestamp() time.Time {
+	return m.createdTs
+}
+
 func (m *constMetric) Write(out *dto.Metric) error {
 	out.Label = m.metric.Label
 	out.Counter = m.metric.Counter
@@ -152,12 +213,13 @@ func populateMetric(
 	v float64,
 	labelPairs []*dto.LabelPair,
 	e *dto.Exemplar,
+	ct time.Time,
 	m *dto.Metric,
 ) error {
 	m.Label = labelPairs
 	switch t {
 	case CounterValue:
-		m.Counter = &dto.Counter{Value: proto.Float64(v), Exemplar: e}
+		m.Counter = &dto.Counter{Value: proto.Float64(v), Exemplar: e, CreatedTimestamp: createdTimestampProto(ct)}
 	case GaugeValue:
 		m.Gauge = &dto.Gauge{Value: proto.Float64(v)}
 	case UntypedValue:
@@ -168,6 +230,15 @@ func populateMetric(
 	return nil
 }
 
+// createdTimestampProto returns the provided created timestamp as a protobuf
+// timestamp, or nil if it is the zero time, i.e. unknown.
+func createdTimestampProto(ct time.Time) *timestamppb.Timestamp {
+	if ct.IsZero() {
+		return nil
+	}
+	return timestamppb.New(ct)
+}
+
 // MakeLabelPairs is a helper function to create protobuf LabelPairs from the
 // variable and constant labels in the provided Desc. The values for the
 // variable labels are defined by the labelValues slice, which must be in the
@@ -16,6 +16,10 @@ package prometheus
 import (
 	"fmt"
 	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestNewConstMetricInvalidLabelValues(t *testing.T) {
@@ -49,8 +53,78 @@ func TestNewConstMetricInvalidLabelValues(t *testing.T) {
 			MustNewConstMetric(metricDesc, CounterValue, 0.3, "\xFF")
 		}, fmt.Sprintf("WithLabelValues: expected panic because: %s", test.desc))
 
-		if _, err := NewConstMetric(metricDesc, CounterValue, 0.3, "\xFF"); err == nil {
+		m, err := NewConstMetric(metricDesc, CounterValue, 0.3, "\xFF")
+		if err == nil {
 			t.Errorf("NewConstMetric: expected error because: %s", test.desc)
 		}
+		if m != nil {
+			t.Errorf("NewConstMetric: expected nil metric because: %s", test.desc)
+		}
+	}
+}
+
+func TestNewConstMetricWithCreatedTimestamp(t *testing.T) {
+	desc := NewDesc("metric_test", "help", []string{"label"}, nil)
+	ct := time.Unix(1234567890, 0)
+
+	for _, m := range []Metric{
+		MustNewConstMetricWithCreatedTimestamp(desc, CounterValue, 1, ct, "value"),
+		MustNewConstHistogramWithCreatedTimestamp(desc, 1, 2, map[float64]uint64{1: 1}, ct, "value"),
+		MustNewConstSummaryWithCreatedTimestamp(desc, 1, 2, map[float64]float64{0.5: 2}, ct, "value"),
+	} {
+		if got := m.(CreatedTimestamper).CreatedTimestamp(); !got.Equal(ct) {
+			t.Errorf("%T: got created timestamp %v, want %v", m, got, ct)
+		}
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatal(err)
+		}
+		var written *timestamppb.Timestamp
+		switch {
+		case pb.Counter != nil:
+			written = pb.Counter.CreatedTimestamp
+		case pb.Histogram != nil:
+			written = pb.Histogram.CreatedTimestamp
+		case pb.Summary != nil:
+			written = pb.Summary.CreatedTimestamp
+		}
+		if got := written.AsTime(); written == nil || !got.Equal(ct) {
+			t.Errorf("%T: got written created timestamp %v, want %v", m, written, ct)
+		}
+	}
+
+	if m, err := NewConstMetricWithCreatedTimestamp(desc, GaugeValue, 1, ct, "value"); err == nil || m != nil {
+		t.Errorf("got metric %v and error %v for gauge with created timestamp, want nil metric and error", m, err)
+	}
+	if m, err := NewConstMetricWithCreatedTimestamp(desc, CounterValue, 1, ct); err == nil || m != nil {
+		t.Errorf("got metric %v and error %v for inconsistent label values, want nil metric and error", m, err)
+	}
+	if got := MustNewConstMetric(desc, CounterValue, 1, "value").(CreatedTimestamper).CreatedTimestamp(); !got.IsZero() {
+		t.Errorf("got created timestamp %v for const metric without one, want zero time", got)
+	}
+}
+
+func TestCreatedTimestamp(t *testing.T) {
+	before := time.Now()
+	counterVec := NewCounterVec(CounterOpts{Name: "counter", Help: "help"}, []string{"label"})
+	for _, m := range []Metric{
+		NewCounter(CounterOpts{Name: "counter", Help: "help"}),
+		counterVec.WithLabelValues("a"),
+		NewHistogram(HistogramOpts{Name: "histogram", Help: "help"}),
+		NewSummary(SummaryOpts{Name: "summary", Help: "help"}),
+		NewSummary(SummaryOpts{Name: "summary", Help: "help", Objectives: map[float64]float64{0.5: 0.05}}),
+	} {
+		ct := m.(CreatedTimestamper).CreatedTimestamp()
+		if ct.Before(before) || ct.After(time.Now()) {
+			t.Errorf("%T: got created timestamp %v, want it between %v and now", m, ct, before)
+		}
+	}
+
+	// Children of a vector are created anew after a Reset.
+	ct := counterVec.WithLabelValues("a").(CreatedTimestamper).CreatedTimestamp()
+	time.Sleep(time.Millisecond)
+	counterVec.Reset()
+	if got := counterVec.WithLabelValues("a").(CreatedTimestamper).CreatedTimestamp(); !got.After(ct) {
+		t.Errorf("got created timestamp %v after reset, want it after %v", got, ct)
 	}
 }
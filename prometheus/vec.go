@@ -14,12 +14,65 @@
 package prometheus
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/proto"
 )
 
+// OverflowLabelValue is the value of every variable label of the overflow
+// child of a vector configured with a CardinalityLimit in overflow mode.
+const OverflowLabelValue = "__overflow__"
+
+// CardinalityLimit caps the number of children (i.e. distinct label value
+// combinations) a metric vector may hold. It protects the process from
+// unbounded memory growth caused by label values with a high or unbounded
+// number of distinct values (like user IDs or raw URLs).
+//
+// If a vector has a limit set, it additionally exports a counter named
+// prometheus_vec_dropped_series_total, carrying the fully-qualified name of
+// the vector in the "metric_name" label (and no other labels, so that the
+// counters of all limited vectors can be registered together). The counter is
+// incremented each time a new label value combination was rejected or folded
+// into the overflow child. Note that two limited vectors with the same
+// fully-qualified name (and different constant labels) cannot be registered
+// with the same Registry, as their counters would collide.
+type CardinalityLimit struct {
+	// MaxSeries is the maximum number of children the vector may hold. The
+	// overflow child (see Overflow) is not counted. Zero (the default)
+	// means no limit.
+	MaxSeries int
+	// Overflow defines what happens if a new label value combination is
+	// accessed once MaxSeries is reached. If false, the GetMetricWith...
+	// methods of the vector return a CardinalityLimitError (and With and
+	// WithLabelValues panic accordingly). If true, all such accesses
+	// return a single overflow child, which has all its variable labels
+	// set to OverflowLabelValue.
+	Overflow bool
+}
+
+// CardinalityLimitError is returned by the GetMetricWith... methods of a
+// metric vector if a new label value combination is accessed after the vector
+// has reached the MaxSeries of its CardinalityLimit (and the limit is not
+// configured to fold into an overflow child).
+type CardinalityLimitError struct {
+	FQName      string
+	MaxSeries   int
+	LabelValues []string
+}
+
+func (err CardinalityLimitError) Error() string {
+	return fmt.Sprintf(
+		"cardinality limit of %d series reached for metric %q, rejecting label values %q",
+		err.MaxSeries, err.FQName, err.LabelValues,
+	)
+}
+
 // MetricVec is a Collector to bundle metrics of the same name that differ in
 // their label values. MetricVec is not used directly but as a building block
 // for implementations of vectors of a given metric type, like GaugeVec,
@@ -50,6 +103,7 @@ func NewMetricVec(desc *Desc, newMetric func(lvs ...string) Metric) *MetricVec {
 			metrics:   map[uint64][]metricWithLabelValues{},
 			desc:      desc,
 			newMetric: newMetric,
+			now:       time.Now,
 		},
 		hashAdd:     hashAdd,
 		hashAddByte: hashAddByte,
@@ -112,6 +166,111 @@ func (m *MetricVec) DeletePartialMatch(labels Labels) int {
 	return m.metricMap.deleteByLabels(labels, m.curry)
 }
 
+// Range calls f for each child currently held by the vector, with the variable
+// labels of the child and the child itself, until f returns false. The child
+// can be type-asserted to the type of the children of the typed vector (e.g. to
+// Counter for a CounterVec).
+//
+// The provided Labels do not contain labels curried in this vector, so that
+// they can be used with With, Delete, and the like of the same vector. (Note
+// that label values are provided after applying any label constraints.) If
+// called on a curried vector, only children matching the curried labels are
+// visited. Children are visited in no particular order. The overflow child of a
+// CardinalityLimit is not visited.
+//
+// Range works on a snapshot of the children taken before f is called for the
+// first time. Therefore, f may safely call other methods of the vector, e.g.
+// Delete to prune the visited child. Visiting a child does not count as an
+// access for the TTL set with SetTTL.
+func (m *MetricVec) Range(f func(labels Labels, metric Metric) bool) {
+	m.RangePartialMatch(nil, f)
+}
+
+// RangePartialMatch works like Range but only visits children that match all
+// of the provided LabelMatchers. The matchers are applied to the variable
+// labels (including curried ones, and with the label values after applying any
+// label constraints) and the constant labels of the vector. A matcher for
+// MetricNameLabel matches the fully-qualified name of the vector. Like with
+// MetricFilter, a missing label is treated as having the empty string as its
+// value.
+func (m *MetricVec) RangePartialMatch(matchers []*LabelMatcher, f func(labels Labels, metric Metric) bool) {
+	for _, child := range m.metricMap.childrenMatching(matchers, m.curry) {
+		if !f(m.uncurriedLabels(child.values), child.metric) {
+			return
+		}
+	}
+}
+
+// uncurriedLabels returns the provided (inlined) label values as Labels,
+// without the labels curried in m.
+func (m *MetricVec) uncurriedLabels(values []string) Labels {
+	labels := make(Labels, len(values)-len(m.curry))
+	for i, name := range m.desc.variableLabels.labelNames() {
+		if isCurried(i, m.curry) {
+			continue
+		}
+		labels[name] = values[i]
+	}
+	return labels
+}
+
+// SetCardinalityLimit sets the limit on the number of children the vector may
+// hold. See CardinalityLimit for details. The limit applies to the vector and
+// all vectors curried from it. It only affects the creation of new children,
+// i.e. already existing children are kept even if their number exceeds the
+// new limit. A limit with a zero MaxSeries removes any previously set limit.
+//
+// SetCardinalityLimit must be called before the vector is registered, as it
+// changes the descriptors the vector describes itself with. The typed vectors
+// in this package call it during construction, based on the CardinalityLimit
+// field in their VecOpts.
+func (m *MetricVec) SetCardinalityLimit(limit CardinalityLimit) {
+	m.metricMap.setCardinalityLimit(limit)
+}
+
+// SetTTL enables the expiry of idle children. A child that has neither been
+// accessed through the vector (with GetMetricWith..., With..., or any of their
+// curried variants) nor changed its value for longer than the provided ttl is
+// deleted from the vector, as if Delete had been called for it. Changes of the
+// value are detected by comparing the result of the Write method of the child
+// during each expiry run with the result of the previous run (or with the
+// initial state of the child for the first run). Therefore, children kept and
+// updated outside of the vector do not expire as long as they are updated.
+//
+// Expiry only happens when SweepExpired is called, so that collection is not
+// slowed down. Call SweepExpired regularly or run RunTTLSweeper in a goroutine.
+// A ttl <= 0 (the default) disables expiry. The ttl applies to the vector and
+// all vectors curried from it. The overflow child of a CardinalityLimit never
+// expires.
+//
+// The typed vectors in this package call SetTTL during construction, based on
+// the TTL field in their VecOpts.
+func (m *MetricVec) SetTTL(ttl time.Duration) {
+	m.metricMap.setTTL(ttl)
+}
+
+// SweepExpired deletes all children that have expired according to the TTL
+// set with SetTTL. It returns the number of deleted children. It is a no-op if
+// no TTL is set.
+func (m *MetricVec) SweepExpired() int {
+	return m.metricMap.sweepExpired()
+}
+
+// RunTTLSweeper calls SweepExpired every interval until the provided context
+// is canceled. It blocks and should therefore be run in its own goroutine.
+func (m *MetricVec) RunTTLSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.SweepExpired()
+		}
+	}
+}
+
 // Without explicit forwarding of Describe, Collect, Reset, those methods won't
 // show up in GoDoc.
 
@@ -190,7 +349,10 @@ func (m *MetricVec) CurryWith(labels Labels) (*MetricVec, error) {
 // Metric with the same label values is created later.
 //
 // An error is returned if the number of label values is not the same as the
-// number of variable labels in Desc (minus any curried labels).
+// number of variable labels in Desc (minus any curried labels). A
+// CardinalityLimitError is returned if a new Metric would have to be created
+// but the vector has reached its CardinalityLimit (unless the limit is
+// configured to fold into an overflow child).
 //
 // Note that for more than one label value, this method is prone to mistakes
 // caused by an incorrect order of arguments. Consider GetMetricWith(Labels) as
@@ -208,7 +370,7 @@ func (m *MetricVec) GetMetricWithLabelValues(lvs ...string) (Metric, error) {
 		return nil, err
 	}
 
-	return m.metricMap.getOrCreateMetricWithLabelValues(h, lvs, m.curry), nil
+	return m.metricMap.getOrCreateMetricWithLabelValues(h, lvs, m.curry)
 }
 
 // GetMetricWith returns the Metric for the given Labels map (the label names
@@ -218,7 +380,8 @@ func (m *MetricVec) GetMetricWithLabelValues(lvs ...string) (Metric, error) {
 // are the same as for GetMetricWithLabelValues.
 //
 // An error is returned if the number and names of the Labels are inconsistent
-// with those of the variable labels in Desc (minus any curried labels).
+// with those of the variable labels in Desc (minus any curried labels), or if
+// the CardinalityLimit of the vector is reached (see GetMetricWithLabelValues).
 //
 // This method is used for the same purpose as
 // GetMetricWithLabelValues(...string). See there for pros and cons of the two
@@ -234,7 +397,7 @@ func (m *MetricVec) GetMetricWith(labels Labels) (Metric, error) {
 		return nil, err
 	}
 
-	return m.metricMap.getOrCreateMetricWithLabels(h, labels, m.curry), nil
+	return m.metricMap.getOrCreateMetricWithLabels(h, labels, m.curry)
 }
 
 func (m *MetricVec) hashLabelValues(vals []string) (uint64, error) {
@@ -294,6 +457,34 @@ func (m *MetricVec) hashLabels(labels Labels) (uint64, error) {
 type metricWithLabelValues struct {
 	values []string
 	metric Metric
+	// expiry is only set if the metricMap has a TTL.
+	expiry *childExpiry
+}
+
+// childExpiry tracks when a child of a metricMap was last updated.
+type childExpiry struct {
+	// lastUpdate is the time of the last access or detected change in
+	// Unix nanoseconds. Accessed atomically.
+	lastUpdate int64
+	// lastWritten is the result of Write at the time of the last expiry
+	// run (or at the creation of the childExpiry), used to detect changes.
+	// Protected by the sweepMtx of the metricMap.
+	lastWritten *dto.Metric
+}
+
+// newChildExpiry returns a childExpiry for the provided metric, last updated at
+// the provided time.
+func newChildExpiry(metric Metric, now time.Time) *childExpiry {
+	e := &childExpiry{lastUpdate: now.UnixNano()}
+	written := &dto.Metric{}
+	if err := metric.Write(written); err == nil {
+		e.lastWritten = written
+	}
+	return e
+}
+
+func (e *childExpiry) touch(now time.Time) {
+	atomic.StoreInt64(&e.lastUpdate, now.UnixNano())
 }
 
 // curriedLabelValue sets the curried value for a label at the given index.
@@ -305,16 +496,40 @@ type curriedLabelValue struct {
 // metricMap is a helper for metricVec and shared between differently curried
 // metricVecs.
 type metricMap struct {
-	mtx       sync.RWMutex // Protects metrics.
+	mtx       sync.RWMutex // Protects metrics, count, limit, and overflow.
 	metrics   map[uint64][]metricWithLabelValues
 	desc      *Desc
 	newMetric func(labelValues ...string) Metric
+
+	// count is the number of children in metrics (which might differ from
+	// len(metrics) in case of hash collisions).
+	count int
+	// limit, overflow, droppedDesc, and dropped are only used if a
+	// CardinalityLimit has been set.
+	limit       CardinalityLimit
+	overflow    Metric
+	droppedDesc *Desc
+	dropped     uint64 // Accessed atomically.
+
+	// ttl is the duration after which idle children expire. Zero means no
+	// expiry. Protected by mtx.
+	ttl time.Duration
+	// sweepMtx serializes expiry runs, which only hold mtx for reading
+	// while detecting changes.
+	sweepMtx sync.Mutex
+	now      func() time.Time // To mock out time.Now() for testing.
 }
 
 // Describe implements Collector. It will send exactly one Desc to the provided
-// channel.
+// channel, or two if a CardinalityLimit is set.
 func (m *metricMap) Describe(ch chan<- *Desc) {
 	ch <- m.desc
+	m.mtx.RLock()
+	droppedDesc := m.droppedDesc
+	m.mtx.RUnlock()
+	if droppedDesc != nil {
+		ch <- droppedDesc
+	}
 }
 
 // Collect implements Collector.
@@ -327,6 +542,14 @@ func (m *metricMap) Collect(ch chan<- Metric) {
 			ch <- metric.metric
 		}
 	}
+	if m.overflow != nil {
+		ch <- m.overflow
+	}
+	if m.droppedDesc != nil {
+		ch <- MustNewConstMetric(
+			m.droppedDesc, CounterValue, float64(atomic.LoadUint64(&m.dropped)),
+		)
+	}
 }
 
 // Reset deletes all metrics in this vector.
@@ -337,6 +560,185 @@ func (m *metricMap) Reset() {
 	for h := range m.metrics {
 		delete(m.metrics, h)
 	}
+	m.count = 0
+	m.overflow = nil
+}
+
+func (m *metricMap) setCardinalityLimit(limit CardinalityLimit) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.limit = limit
+	if limit.MaxSeries <= 0 {
+		m.droppedDesc = nil
+		m.overflow = nil
+		return
+	}
+	if !limit.Overflow {
+		m.overflow = nil
+	}
+	if m.droppedDesc != nil {
+		return
+	}
+	m.droppedDesc = NewDesc(
+		"prometheus_vec_dropped_series_total",
+		"Total number of new series rejected or folded into the overflow series because the cardinality limit of a metric vector was reached.",
+		nil, Labels{"metric_name": m.desc.fqName},
+	)
+}
+
+func (m *metricMap) setTTL(ttl time.Duration) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if ttl <= 0 {
+		m.ttl = 0
+		for _, metrics := range m.metrics {
+			for i := range metrics {
+				metrics[i].expiry = nil
+			}
+		}
+		return
+	}
+	m.ttl = ttl
+	now := m.now()
+	for _, metrics := range m.metrics {
+		for i := range metrics {
+			if metrics[i].expiry == nil {
+				metrics[i].expiry = newChildExpiry(metrics[i].metric, now)
+			}
+		}
+	}
+}
+
+// sweepExpired deletes all children that have been neither accessed nor
+// changed within the TTL and returns the number of deleted children.
+//
+// Changes are detected while only holding the read mutex, so that the
+// vector stays accessible. The write mutex is only held to delete the
+// expired children.
+func (m *metricMap) sweepExpired() int {
+	m.sweepMtx.Lock()
+	defer m.sweepMtx.Unlock()
+
+	m.mtx.RLock()
+	if m.ttl <= 0 {
+		m.mtx.RUnlock()
+		return 0
+	}
+	var (
+		now      = m.now()
+		deadline = now.Add(-m.ttl).UnixNano()
+		hashes   []uint64 // Hashes of buckets with expired children.
+	)
+	for h, metrics := range m.metrics {
+		var found bool
+		for _, metric := range metrics {
+			// Check all children to record their current state.
+			if metric.expiry.expired(metric.metric, now, deadline) {
+				found = true
+			}
+		}
+		if found {
+			hashes = append(hashes, h)
+		}
+	}
+	m.mtx.RUnlock()
+	if len(hashes) == 0 {
+		return 0
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.ttl <= 0 {
+		// The TTL has been disabled in the meantime.
+		return 0
+	}
+	deadline = now.Add(-m.ttl).UnixNano() // In case the TTL has changed.
+	var expired int
+	for _, h := range hashes {
+		metrics := m.metrics[h]
+		kept := metrics[:0]
+		for _, metric := range metrics {
+			// Children might have been accessed or created in the
+			// meantime.
+			if metric.expiry == nil || atomic.LoadInt64(&metric.expiry.lastUpdate) >= deadline {
+				kept = append(kept, metric)
+			}
+		}
+		if len(kept) == len(metrics) {
+			continue
+		}
+		for i := len(kept); i < len(metrics); i++ {
+			metrics[i] = metricWithLabelValues{}
+		}
+		expired += len(metrics) - len(kept)
+		if len(kept) == 0 {
+			delete(m.metrics, h)
+		} else {
+			m.metrics[h] = kept
+		}
+	}
+	m.count -= expired
+	return expired
+}
+
+// expired writes the metric and compares the result with the result of the
+// previous call (or the state at creation) to detect changes. It returns true
+// if the metric has been neither accessed nor changed since deadline (in Unix
+// nanoseconds). Must be called while holding the sweepMtx and the read mutex
+// of the metricMap.
+func (e *childExpiry) expired(metric Metric, now time.Time, deadline int64) bool {
+	if e == nil {
+		return false
+	}
+	written := &dto.Metric{}
+	if err := metric.Write(written); err != nil {
+		// A metric that cannot be written is left alone and will be
+		// reported during collection.
+		return false
+	}
+	if e.lastWritten == nil || !proto.Equal(e.lastWritten, written) {
+		// If there is no previous state to compare with, the metric
+		// might have changed.
+		e.touch(now)
+	}
+	e.lastWritten = written
+	return atomic.LoadInt64(&e.lastUpdate) < deadline
+}
+
+// addMetric creates a new metric for the provided (inlined) label values and
+// adds it to the bucket for hash. If the CardinalityLimit is reached, it
+// returns the overflow metric or a CardinalityLimitError instead. Must be
+// called while holding the write mutex.
+func (m *metricMap) addMetric(hash uint64, lvs []string) (Metric, error) {
+	if m.limit.MaxSeries > 0 && m.count >= m.limit.MaxSeries {
+		atomic.AddUint64(&m.dropped, 1)
+		if !m.limit.Overflow {
+			return nil, CardinalityLimitError{
+				FQName:      m.desc.fqName,
+				MaxSeries:   m.limit.MaxSeries,
+				LabelValues: lvs,
+			}
+		}
+		if m.overflow == nil {
+			overflowLVs := make([]string, len(lvs))
+			for i := range overflowLVs {
+				overflowLVs[i] = OverflowLabelValue
+			}
+			m.overflow = m.newMetric(overflowLVs...)
+		}
+		return m.overflow, nil
+	}
+	metric := m.newMetric(lvs...)
+	var expiry *childExpiry
+	if m.ttl > 0 {
+		expiry = newChildExpiry(metric, m.now())
+	}
+	m.metrics[hash] = append(m.metrics[hash], metricWithLabelValues{values: lvs, metric: metric, expiry: expiry})
+	m.count++
+	return metric, nil
 }
 
 // deleteByHashWithLabelValues removes the metric from the hash bucket h. If
@@ -365,6 +767,7 @@ func (m *metricMap) deleteByHashWithLabelValues(
 	} else {
 		delete(m.metrics, h)
 	}
+	m.count--
 	return true
 }
 
@@ -393,6 +796,7 @@ func (m *metricMap) deleteByHashWithLabels(
 	} else {
 		delete(m.metrics, h)
 	}
+	m.count--
 	return true
 }
 
@@ -410,12 +814,87 @@ func (m *metricMap) deleteByLabels(labels Labels, curry []curriedLabelValue) int
 			continue
 		}
 		delete(m.metrics, h)
+		m.count -= len(metrics)
 		numDeleted++
 	}
 
 	return numDeleted
 }
 
+// childrenMatching returns all children that match the provided curried label
+// values and all of the provided LabelMatchers (see matchLabelMatchers).
+//
+// This function holds the mutex.
+func (m *metricMap) childrenMatching(matchers []*LabelMatcher, curry []curriedLabelValue) []metricWithLabelValues {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	var children []metricWithLabelValues
+	for _, metrics := range m.metrics {
+		for _, metric := range metrics {
+			if matchCurriedLabelValues(metric.values, curry) && matchLabelMatchers(m.desc, metric.values, matchers) {
+				children = append(children, metric)
+			}
+		}
+	}
+	return children
+}
+
+// matchLabelMatchers returns whether the provided (inlined) label values, the
+// constant labels, and the fully-qualified name of desc match all of the
+// provided LabelMatchers.
+func matchLabelMatchers(desc *Desc, values []string, matchers []*LabelMatcher) bool {
+	for _, matcher := range matchers {
+		if !matcher.Matches(labelValue(desc, values, matcher.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// labelValue returns the value of the label with the provided name, looked up
+// in the provided (inlined) variable label values and in the constant labels
+// of desc, or the empty string if there is no such label. The name
+// MetricNameLabel returns the fully-qualified name of desc.
+func labelValue(desc *Desc, values []string, name string) string {
+	if name == MetricNameLabel {
+		return desc.fqName
+	}
+	for i, labelName := range desc.variableLabels.labelNames() {
+		if labelName == name {
+			return values[i]
+		}
+	}
+	for _, lp := range desc.constLabelPairs {
+		if lp.GetName() == name {
+			return lp.GetValue()
+		}
+	}
+	return ""
+}
+
+// matchCurriedLabelValues returns whether the provided (inlined) label values
+// contain the curried label values.
+func matchCurriedLabelValues(values []string, curry []curriedLabelValue) bool {
+	for _, c := range curry {
+		if values[c.index] != c.value {
+			return false
+		}
+	}
+	return true
+}
+
+// isCurried returns whether the variable label with the provided index is
+// curried.
+func isCurried(index int, curry []curriedLabelValue) bool {
+	for _, c := range curry {
+		if c.index == index {
+			return true
+		}
+	}
+	return false
+}
+
 // findMetricWithPartialLabel returns the index of the matching metric or
 // len(metrics) if not found.
 func findMetricWithPartialLabels(
@@ -473,53 +952,51 @@ func matchPartialLabels(desc *Desc, values []string, labels Labels, curry []curr
 }
 
 // getOrCreateMetricWithLabelValues retrieves the metric by hash and label value
-// or creates it and returns the new one.
+// or creates it and returns the new one. An error is only returned if the
+// CardinalityLimit is reached.
 //
 // This function holds the mutex.
 func (m *metricMap) getOrCreateMetricWithLabelValues(
 	hash uint64, lvs []string, curry []curriedLabelValue,
-) Metric {
+) (Metric, error) {
 	m.mtx.RLock()
 	metric, ok := m.getMetricWithHashAndLabelValues(hash, lvs, curry)
 	m.mtx.RUnlock()
 	if ok {
-		return metric
+		return metric, nil
 	}
 
 	m.mtx.Lock()
 	defer m.mtx.Unlock()
 	metric, ok = m.getMetricWithHashAndLabelValues(hash, lvs, curry)
 	if !ok {
-		inlinedLVs := inlineLabelValues(lvs, curry)
-		metric = m.newMetric(inlinedLVs...)
-		m.metrics[hash] = append(m.metrics[hash], metricWithLabelValues{values: inlinedLVs, metric: metric})
+		return m.addMetric(hash, inlineLabelValues(lvs, curry))
 	}
-	return metric
+	return metric, nil
 }
 
 // getOrCreateMetricWithLabelValues retrieves the metric by hash and label value
-// or creates it and returns the new one.
+// or creates it and returns the new one. An error is only returned if the
+// CardinalityLimit is reached.
 //
 // This function holds the mutex.
 func (m *metricMap) getOrCreateMetricWithLabels(
 	hash uint64, labels Labels, curry []curriedLabelValue,
-) Metric {
+) (Metric, error) {
 	m.mtx.RLock()
 	metric, ok := m.getMetricWithHashAndLabels(hash, labels, curry)
 	m.mtx.RUnlock()
 	if ok {
-		return metric
+		return metric, nil
 	}
 
 	m.mtx.Lock()
 	defer m.mtx.Unlock()
 	metric, ok = m.getMetricWithHashAndLabels(hash, labels, curry)
 	if !ok {
-		lvs := extractLabelValues(m.desc, labels, curry)
-		metric = m.newMetric(lvs...)
-		m.metrics[hash] = append(m.metrics[hash], metricWithLabelValues{values: lvs, metric: metric})
+		return m.addMetric(hash, extractLabelValues(m.desc, labels, curry))
 	}
-	return metric
+	return metric, nil
 }
 
 // getMetricWithHashAndLabelValues gets a metric while handling possible
@@ -530,6 +1007,10 @@ func (m *metricMap) getMetricWithHashAndLabelValues(
 	metrics, ok := m.metrics[h]
 	if ok {
 		if i := findMetricWithLabelValues(metrics, lvs, curry); i < len(metrics) {
+			// Only look at the clock if a TTL is set.
+			if e := metrics[i].expiry; e != nil {
+				e.touch(m.now())
+			}
 			return metrics[i].metric, true
 		}
 	}
@@ -544,6 +1025,10 @@ func (m *metricMap) getMetricWithHashAndLabels(
 	metrics, ok := m.metrics[h]
 	if ok {
 		if i := findMetricWithLabels(m.desc, metrics, labels, curry); i < len(metrics) {
+			// Only look at the clock if a TTL is set.
+			if e := metrics[i].expiry; e != nil {
+				e.touch(m.now())
+			}
 			return metrics[i].metric, true
 		}
 	}
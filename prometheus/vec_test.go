@@ -14,9 +14,13 @@
 package prometheus
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	dto "github.com/prometheus/client_model/go"
 )
@@ -47,11 +51,11 @@ func TestDeleteWithCollisions(t *testing.T) {
 
 func TestDeleteWithConstraints(t *testing.T) {
 	vec := V2.NewGaugeVec(GaugeVecOpts{
-		GaugeOpts{
+		GaugeOpts: GaugeOpts{
 			Name: "test",
 			Help: "helpless",
 		},
-		ConstrainedLabels{
+		VariableLabels: ConstrainedLabels{
 			{Name: "l1"},
 			{Name: "l2", Constraint: func(s string) string { return "x" + s }},
 		},
@@ -115,11 +119,11 @@ func TestDeleteLabelValuesWithCollisions(t *testing.T) {
 
 func TestDeleteLabelValuesWithConstraints(t *testing.T) {
 	vec := V2.NewGaugeVec(GaugeVecOpts{
-		GaugeOpts{
+		GaugeOpts: GaugeOpts{
 			Name: "test",
 			Help: "helpless",
 		},
-		ConstrainedLabels{
+		VariableLabels: ConstrainedLabels{
 			{Name: "l1"},
 			{Name: "l2", Constraint: func(s string) string { return "x" + s }},
 		},
@@ -167,11 +171,11 @@ func TestDeletePartialMatch(t *testing.T) {
 
 func TestDeletePartialMatchWithConstraints(t *testing.T) {
 	vec := V2.NewGaugeVec(GaugeVecOpts{
-		GaugeOpts{
+		GaugeOpts: GaugeOpts{
 			Name: "test",
 			Help: "helpless",
 		},
-		ConstrainedLabels{
+		VariableLabels: ConstrainedLabels{
 			{Name: "l1"},
 			{Name: "l2", Constraint: func(s string) string { return "x" + s }},
 			{Name: "l3"},
@@ -343,11 +347,11 @@ func testMetricVec(t *testing.T, vec *GaugeVec) {
 func TestMetricVecWithConstraints(t *testing.T) {
 	constraint := func(s string) string { return "x" + s }
 	vec := V2.NewGaugeVec(GaugeVecOpts{
-		GaugeOpts{
+		GaugeOpts: GaugeOpts{
 			Name: "test",
 			Help: "helpless",
 		},
-		ConstrainedLabels{
+		VariableLabels: ConstrainedLabels{
 			{Name: "l1"},
 			{Name: "l2", Constraint: constraint},
 		},
@@ -473,11 +477,11 @@ func TestCurryVecWithConstraints(t *testing.T) {
 	constraint := func(s string) string { return "x" + s }
 	t.Run("constrainedLabels overlap variableLabels", func(t *testing.T) {
 		vec := V2.NewCounterVec(CounterVecOpts{
-			CounterOpts{
+			CounterOpts: CounterOpts{
 				Name: "test",
 				Help: "helpless",
 			},
-			ConstrainedLabels{
+			VariableLabels: ConstrainedLabels{
 				{Name: "one"},
 				{Name: "two"},
 				{Name: "three", Constraint: constraint},
@@ -488,11 +492,11 @@ func TestCurryVecWithConstraints(t *testing.T) {
 	t.Run("constrainedLabels reducing cardinality", func(t *testing.T) {
 		constraint := func(s string) string { return "x" }
 		vec := V2.NewCounterVec(CounterVecOpts{
-			CounterOpts{
+			CounterOpts: CounterOpts{
 				Name: "test",
 				Help: "helpless",
 			},
-			ConstrainedLabels{
+			VariableLabels: ConstrainedLabels{
 				{Name: "one"},
 				{Name: "two"},
 				{Name: "three", Constraint: constraint},
@@ -904,6 +908,235 @@ func testConstrainedCurryVec(t *testing.T, vec *CounterVec, constraint func(stri
 	})
 }
 
+func TestCardinalityLimit(t *testing.T) {
+	t.Run("reject", func(t *testing.T) {
+		vec := V2.NewCounterVec(CounterVecOpts{
+			CounterOpts:      CounterOpts{Name: "test", Help: "helpless"},
+			VariableLabels:   UnconstrainedLabels{"l1", "l2"},
+			CardinalityLimit: CardinalityLimit{MaxSeries: 2},
+		})
+		vec.WithLabelValues("a", "1").Inc()
+		vec.With(Labels{"l1": "b", "l2": "1"}).Inc()
+		// Existing children are still accessible.
+		vec.WithLabelValues("a", "1").Inc()
+
+		_, err := vec.GetMetricWithLabelValues("c", "1")
+		var limitErr CardinalityLimitError
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("got error %v, want CardinalityLimitError", err)
+		}
+		if got, want := limitErr.MaxSeries, 2; got != want {
+			t.Errorf("got MaxSeries %d, want %d", got, want)
+		}
+		if _, err := vec.MustCurryWith(Labels{"l2": "2"}).GetMetricWith(Labels{"l1": "a"}); !errors.As(err, &limitErr) {
+			t.Errorf("got error %v, want CardinalityLimitError", err)
+		}
+
+		// Deleting a child makes room for a new one.
+		if !vec.DeleteLabelValues("b", "1") {
+			t.Fatal("deletion failed")
+		}
+		if _, err := vec.GetMetricWithLabelValues("c", "1"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		if got, want := collectDroppedSeries(t, vec), 2.; got != want {
+			t.Errorf("got %v dropped series, want %v", got, want)
+		}
+	})
+	t.Run("overflow", func(t *testing.T) {
+		vec := V2.NewGaugeVec(GaugeVecOpts{
+			GaugeOpts:        GaugeOpts{Name: "test", Help: "helpless"},
+			VariableLabels:   UnconstrainedLabels{"l1"},
+			CardinalityLimit: CardinalityLimit{MaxSeries: 1, Overflow: true},
+		})
+		vec.WithLabelValues("a").Set(1)
+		vec.WithLabelValues("b").Add(2)
+		vec.WithLabelValues("c").Add(3)
+
+		m := &dto.Metric{}
+		if err := vec.WithLabelValues("d").Write(m); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := m.GetLabel()[0].GetValue(), OverflowLabelValue; got != want {
+			t.Errorf("got label value %q, want %q", got, want)
+		}
+		if got, want := m.GetGauge().GetValue(), 5.; got != want {
+			t.Errorf("got value %v, want %v", got, want)
+		}
+		if got, want := collectDroppedSeries(t, vec), 3.; got != want {
+			t.Errorf("got %v dropped series, want %v", got, want)
+		}
+
+		vec.Reset()
+		vec.WithLabelValues("b").Set(1)
+		if err := vec.WithLabelValues("b").Write(m); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := m.GetLabel()[0].GetValue(), "b"; got != want {
+			t.Errorf("got label value %q, want %q", got, want)
+		}
+	})
+	t.Run("registration", func(t *testing.T) {
+		// The dropped series counters of vectors with different constant
+		// labels (even one named like the label of the counter) must
+		// not collide.
+		reg := NewPedanticRegistry()
+		for name, constLabels := range map[string]Labels{
+			"a_total": {"env": "x"},
+			"b_total": nil,
+			"c_total": {"metric_name": "c"},
+		} {
+			if err := reg.Register(V2.NewCounterVec(CounterVecOpts{
+				CounterOpts:      CounterOpts{Name: name, Help: "helpless", ConstLabels: constLabels},
+				VariableLabels:   UnconstrainedLabels{"l1"},
+				CardinalityLimit: CardinalityLimit{MaxSeries: 1},
+			})); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := reg.Gather(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestTTL(t *testing.T) {
+	now := time.Now()
+	vec := V2.NewCounterVec(CounterVecOpts{
+		CounterOpts:    CounterOpts{Name: "test", Help: "helpless"},
+		VariableLabels: UnconstrainedLabels{"l1", "l2"},
+		TTL:            time.Minute,
+	})
+	vec.now = func() time.Time { return now }
+	curried := vec.MustCurryWith(Labels{"l2": "x"})
+
+	vec.WithLabelValues("idle", "x").Inc()
+	vec.WithLabelValues("accessed", "x").Inc()
+	cached := vec.WithLabelValues("cached", "x")
+	unchanged := vec.WithLabelValues("unchanged", "x")
+	if got, want := vec.SweepExpired(), 0; got != want {
+		t.Errorf("got %d expired children, want %d", got, want)
+	}
+
+	now = now.Add(50 * time.Second)
+	curried.WithLabelValues("accessed")
+	cached.Inc()
+	unchanged.Add(0)
+	if got, want := vec.SweepExpired(), 0; got != want {
+		t.Errorf("got %d expired children, want %d", got, want)
+	}
+
+	now = now.Add(50 * time.Second)
+	// Collection does not expire children.
+	if got, want := len(collectMetrics(vec)), 4; got != want {
+		t.Errorf("got %d collected children, want %d", got, want)
+	}
+	if got, want := vec.SweepExpired(), 2; got != want {
+		t.Errorf("got %d expired children, want %d", got, want)
+	}
+	if vec.DeleteLabelValues("idle", "x") || vec.DeleteLabelValues("unchanged", "x") {
+		t.Error("expired child still present")
+	}
+	if !curried.DeleteLabelValues("accessed") || !vec.DeleteLabelValues("cached", "x") {
+		t.Error("unexpired child missing")
+	}
+
+	// Expiry is disabled with a zero TTL.
+	vec.WithLabelValues("idle", "x").Inc()
+	vec.SetTTL(0)
+	now = now.Add(time.Hour)
+	if got, want := vec.SweepExpired(), 0; got != want {
+		t.Errorf("got %d expired children, want %d", got, want)
+	}
+}
+
+func TestTTLHeldReference(t *testing.T) {
+	now := time.Now()
+	vec := V2.NewCounterVec(CounterVecOpts{
+		CounterOpts:    CounterOpts{Name: "test", Help: "helpless"},
+		VariableLabels: UnconstrainedLabels{"l1"},
+		TTL:            time.Minute,
+	})
+	vec.now = func() time.Time { return now }
+
+	// A child only updated through a held reference doesn't expire, even
+	// if no expiry run has happened before.
+	held := vec.WithLabelValues("held")
+	for i := 0; i < 10; i++ {
+		now = now.Add(10 * time.Second)
+		held.Inc()
+	}
+	if got, want := vec.SweepExpired(), 0; got != want {
+		t.Errorf("got %d expired children, want %d", got, want)
+	}
+
+	// Once it isn't updated anymore, it expires.
+	now = now.Add(2 * time.Minute)
+	if got, want := vec.SweepExpired(), 1; got != want {
+		t.Errorf("got %d expired children, want %d", got, want)
+	}
+}
+
+func TestTTLConcurrent(t *testing.T) {
+	vec := V2.NewHistogramVec(HistogramVecOpts{
+		HistogramOpts:  HistogramOpts{Name: "test", Help: "helpless"},
+		VariableLabels: UnconstrainedLabels{"l1"},
+		TTL:            time.Nanosecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go vec.RunTTLSweeper(ctx, time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				vec.WithLabelValues(fmt.Sprint(j % 10)).Observe(float64(i))
+				if j%100 == 0 {
+					collectMetrics(vec)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// collectMetrics returns all metrics collected from the provided Collector.
+func collectMetrics(c Collector) []Metric {
+	ch := make(chan Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	var metrics []Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// collectDroppedSeries returns the value of the dropped series counter
+// collected from the provided Collector.
+func collectDroppedSeries(t *testing.T, c Collector) float64 {
+	t.Helper()
+
+	var dropped float64
+	for _, m := range collectMetrics(c) {
+		if m.Desc().fqName != "prometheus_vec_dropped_series_total" {
+			continue
+		}
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatal(err)
+		}
+		dropped = pb.GetCounter().GetValue()
+	}
+	return dropped
+}
+
 func BenchmarkMetricVecWithLabelValuesBasic(b *testing.B) {
 	benchmarkMetricVecWithLabelValues(b, map[string][]string{
 		"l1": {"onevalue"},
@@ -975,3 +1208,99 @@ func benchmarkMetricVecWithLabelValues(b *testing.B, labels map[string][]string)
 		vec.WithLabelValues(values...)
 	}
 }
+
+func TestRange(t *testing.T) {
+	vec := NewGaugeVec(
+		GaugeOpts{
+			Name: "test",
+			Help: "helpless",
+		},
+		[]string{"l1", "l2"},
+	)
+	vec.WithLabelValues("a", "x").Set(1)
+	vec.WithLabelValues("a", "y").Set(2)
+	vec.WithLabelValues("b", "x").Set(3)
+
+	collect := func(rangeFunc func(f func(Labels, Metric) bool)) map[string]float64 {
+		got := map[string]float64{}
+		rangeFunc(func(labels Labels, metric Metric) bool {
+			m := &dto.Metric{}
+			if err := metric.(Gauge).Write(m); err != nil {
+				t.Fatal(err)
+			}
+			got[fmt.Sprint(labels)] = m.GetGauge().GetValue()
+			return true
+		})
+		return got
+	}
+
+	if got, want := collect(vec.Range), map[string]float64{
+		"map[l1:a l2:x]": 1, "map[l1:a l2:y]": 2, "map[l1:b l2:x]": 3,
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	partial := func(matchers ...*LabelMatcher) func(f func(Labels, Metric) bool) {
+		return func(f func(Labels, Metric) bool) { vec.RangePartialMatch(matchers, f) }
+	}
+	mustMatcher := func(mt MatchType, name, value string) *LabelMatcher {
+		m, err := NewLabelMatcher(mt, name, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	if got, want := collect(partial(mustMatcher(MatchEqual, "l2", "x"))), map[string]float64{
+		"map[l1:a l2:x]": 1, "map[l1:b l2:x]": 3,
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := collect(partial(mustMatcher(MatchNotEqual, "l2", "x"))), map[string]float64{
+		"map[l1:a l2:y]": 2,
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := collect(partial(
+		mustMatcher(MatchRegexp, "l1", "a|b"),
+		mustMatcher(MatchNotRegexp, "l2", "y"),
+		mustMatcher(MatchEqual, MetricNameLabel, "test"),
+	)), map[string]float64{
+		"map[l1:a l2:x]": 1, "map[l1:b l2:x]": 3,
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := collect(partial(mustMatcher(MatchEqual, "lx", "x"))); len(got) != 0 {
+		t.Errorf("got %v for unknown label, want nothing", got)
+	}
+	if got, want := collect(partial(mustMatcher(MatchEqual, "lx", ""))), 3; len(got) != want {
+		t.Errorf("got %d children for empty unknown label, want %d", len(got), want)
+	}
+
+	curried := vec.MustCurryWith(Labels{"l1": "a"})
+	if got, want := collect(curried.Range), map[string]float64{
+		"map[l2:x]": 1, "map[l2:y]": 2,
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Stop early.
+	var visited int
+	vec.Range(func(Labels, Metric) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Errorf("visited %d children, want 1", visited)
+	}
+
+	// Prune while ranging.
+	curried.Range(func(labels Labels, _ Metric) bool {
+		if !curried.Delete(labels) {
+			t.Errorf("could not delete %v", labels)
+		}
+		return true
+	})
+	if got, want := collect(vec.Range), map[string]float64{"map[l1:b l2:x]": 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -14,6 +14,7 @@
 package prometheus
 
 import (
+	"context"
 	"fmt"
 	"sort"
 
@@ -124,9 +125,13 @@ type wrappingCollector struct {
 }
 
 func (c *wrappingCollector) Collect(ch chan<- Metric) {
+	c.CollectWithContext(context.Background(), ch)
+}
+
+func (c *wrappingCollector) CollectWithContext(ctx context.Context, ch chan<- Metric) {
 	wrappedCh := make(chan Metric)
 	go func() {
-		c.wrappedCollector.Collect(wrappedCh)
+		collectWithContext(ctx, c.wrappedCollector, wrappedCh)
 		close(wrappedCh)
 	}()
 	for m := range wrappedCh {
@@ -204,7 +209,8 @@ func wrapDesc(desc *Desc, prefix string, labels Labels) *Desc {
 		constLabels[ln] = lv
 	}
 	// NewDesc will do remaining validations.
-	newDesc := V2.NewDesc(prefix+desc.fqName, desc.help, desc.variableLabels, constLabels)
+	newDesc := V2.NewDescWithUnit(prefix+desc.fqName, desc.help, desc.unit, desc.variableLabels, constLabels)
+	newDesc.openMetricsType = desc.openMetricsType
 	// Propagate errors if there was any. This will override any errer
 	// created by NewDesc above, i.e. earlier errors get precedence.
 	if desc.err != nil {